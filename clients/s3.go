@@ -2,6 +2,7 @@ package clients
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"io"
 	"time"
@@ -183,6 +184,32 @@ func (c *S3Connection) ReadFileStream(ctx context.Context, bucket, key string) (
 	return result.Body, nil
 }
 
+// WriteFile uploads a small object in one request.
+func (c *S3Connection) WriteFile(ctx context.Context, bucket, key string, body []byte) error {
+	_, err := c.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error writing file to S3: %s", key)
+		return err
+	}
+	return nil
+}
+
+func (c *S3Connection) DeleteFile(ctx context.Context, bucket, key string) error {
+	_, err := c.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error deleting file from S3: %s", key)
+		return err
+	}
+	return nil
+}
+
 func (c *S3Connection) WriteFileStream(ctx context.Context, bucket, key string, reader *io.PipeReader) error {
 	bufferedReader := bufio.NewReaderSize(reader, 512*1024) // 512KB buffer
 
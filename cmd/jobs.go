@@ -7,6 +7,7 @@ import (
 	"sync"
 	"syscall"
 	"vivek-ray/jobs"
+	"vivek-ray/migrations"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -17,6 +18,9 @@ var JobCmd = &cobra.Command{
 	Short: "Start the jobs",
 	Long:  "Start the jobs",
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := migrations.CheckVersion(); err != nil {
+			log.Fatal().Err(err).Msg("Schema version check failed")
+		}
 		ctx, cancel := context.WithCancel(context.Background())
 		var wg sync.WaitGroup
 
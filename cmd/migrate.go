@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"vivek-ray/migrations"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage PostgreSQL and Elasticsearch schema migrations",
+	Long:  "Apply, revert and inspect the versioned PostgreSQL tables and Elasticsearch indices this binary depends on",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		target, _ := cmd.Flags().GetInt("to")
+		if err := migrations.NewMigrator().Up(cmd.Context(), target); err != nil {
+			log.Fatal().Err(err).Msg("Migration up failed")
+		}
+		log.Info().Msg("Migrations are up to date")
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert the most recently applied migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		steps, _ := cmd.Flags().GetInt("steps")
+		if err := migrations.NewMigrator().Down(cmd.Context(), steps); err != nil {
+			log.Fatal().Err(err).Msg("Migration down failed")
+		}
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show applied and pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		statuses, err := migrations.NewMigrator().Status()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Unable to read migration status")
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(writer, "%04d\t%s\t%s\t%s\n", status.Version, status.Name, state, status.AppliedAt)
+		}
+		writer.Flush()
+	},
+}
+
+func init() {
+	migrateUpCmd.Flags().Int("to", 0, "apply migrations up to this version (default latest)")
+	migrateDownCmd.Flags().Int("steps", 1, "number of migrations to revert")
+
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"vivek-ray/jobs"
+	"vivek-ray/migrations"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var purgeDeletedCmd = &cobra.Command{
+	Use:   "purge-deleted",
+	Short: "Hard delete records soft deleted longer ago than the retention period",
+	Long:  "Hard delete the contacts and companies soft deleted more than --retention-days days ago, as the scheduled purge_deleted job does",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := migrations.CheckVersion(); err != nil {
+			log.Fatal().Err(err).Msg("Schema version check failed")
+		}
+		days, _ := cmd.Flags().GetInt("retention-days")
+		if _, _, err := jobs.PurgeDeleted(cmd.Context(), days); err != nil {
+			log.Error().Err(err).Msg("Purge of deleted records failed")
+		}
+	},
+}
+
+func init() {
+	purgeDeletedCmd.Flags().Int("retention-days", 0, "days deleted records are kept (default DELETED_RETENTION_DAYS, or 30)")
+	rootCmd.AddCommand(purgeDeletedCmd)
+}
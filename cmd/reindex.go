@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"vivek-ray/constants"
+	"vivek-ray/jobs"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var reindexServices = map[string]string{
+	"contacts":  constants.ContactsService,
+	"companies": constants.CompaniesService,
+}
+
+var reindexCmd = &cobra.Command{
+	Use:       "reindex [contacts|companies]",
+	Short:     "Rebuild an Elasticsearch index from PostgreSQL",
+	Long:      "Create a new versioned index from the bundled mapping, copy every PostgreSQL row into it and atomically swap the alias",
+	ValidArgs: []string{"contacts", "companies"},
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		resume, _ := cmd.Flags().GetBool("resume")
+		deleteOld, _ := cmd.Flags().GetBool("delete-old")
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+
+		if err := jobs.RunReindex(cmd.Context(), reindexServices[args[0]], batchSize, resume, deleteOld); err != nil {
+			log.Error().Err(err).Msgf("Reindex of %s failed", args[0])
+			return
+		}
+		log.Info().Msgf("Reindex of %s completed", args[0])
+	},
+}
+
+func init() {
+	reindexCmd.Flags().Bool("resume", false, "continue the last interrupted reindex for this service")
+	reindexCmd.Flags().Bool("delete-old", false, "delete the indices the alias pointed to before the swap")
+	reindexCmd.Flags().Int("batch-size", constants.DefaultReindexBatchSize, "rows fetched from PostgreSQL per bulk request")
+	rootCmd.AddCommand(reindexCmd)
+}
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"syscall"
 	"vivek-ray/middleware"
+	"vivek-ray/migrations"
 	"vivek-ray/modules/common"
 	"vivek-ray/modules/companies"
 	"vivek-ray/modules/contacts"
@@ -32,6 +33,9 @@ func init() {
 }
 
 func startServer() {
+	if err := migrations.CheckVersion(); err != nil {
+		log.Fatal().Err(err).Msg("Schema version check failed")
+	}
 	gin.SetMode(gin.ReleaseMode)
 
 	router := gin.New()
@@ -17,11 +17,17 @@ type app struct {
 }
 
 type jobConfig struct {
-	JobInQueuedSize int    `mapstructure:"JOB_IN_QUEUE_SIZE"`
-	ParallelJobs    int    `mapstructure:"PARALLEL_JOBS"`
-	TickerInterval  int    `mapstructure:"TICKER_INTERVAL_MINUTES"`
-	BatchSize       int    `mapstructure:"BATCH_SIZE_FOR_INSERTION"`
-	JobType         string `mapstructure:"JOB_TYPE"`
+	JobInQueuedSize      int    `mapstructure:"JOB_IN_QUEUE_SIZE"`
+	ParallelJobs         int    `mapstructure:"PARALLEL_JOBS"`
+	TickerInterval       int    `mapstructure:"TICKER_INTERVAL_MINUTES"`
+	BatchSize            int    `mapstructure:"BATCH_SIZE_FOR_INSERTION"`
+	JobType              string `mapstructure:"JOB_TYPE"`
+	DeletedRetentionDays int    `mapstructure:"DELETED_RETENTION_DAYS"`
+	ExportRetentionDays  int    `mapstructure:"EXPORT_RETENTION_DAYS"`
+	ExportWorkers        int    `mapstructure:"EXPORT_WORKERS"`
+	ExportSliceBuffer    int    `mapstructure:"EXPORT_SLICE_BUFFER_BATCHES"`
+	WorkerID             string `mapstructure:"JOB_WORKER_ID"`
+	LeaseSeconds         int    `mapstructure:"JOB_LEASE_SECONDS"`
 }
 
 type database struct {
@@ -55,6 +61,7 @@ type s3Storage struct {
 	S3SSL            bool   `mapstructure:"S3_SSL"`
 	S3Debug          bool   `mapstructure:"S3_DEBUG"`
 	S3UploadURLTTL   int    `mapstructure:"S3_UPLOAD_URL_TTL_HOURS"`
+	S3DownloadURLTTL int    `mapstructure:"S3_DOWNLOAD_URL_TTL_MINUTES"`
 	S3UploadFilePath string `mapstructure:"S3_UPLOAD_FILE_PATH_PRIFIX"`
 }
 
@@ -1,5 +1,7 @@
 package constants
 
+import "time"
+
 var (
 	CompanyIndex        = "companies_index"
 	ContactIndex        = "contacts_index"
@@ -10,5 +12,8 @@ var (
 	DefaultPageSize      = 25
 	MaxElasticPageNumber = 10
 	MaxPageSize          = 100
+
+	DefaultReindexBatchSize = 1000
+	ReconcileStaleTolerance = 5 * time.Second
 )
 
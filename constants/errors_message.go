@@ -15,6 +15,7 @@ var (
 	JobTypeRequiredError       = errors.New("ERR_MISSING_JOB_TYPE: the 'job_type' field is required; specify a valid job type such as 'insert_csv_file' or 'export_csv_file'")
 	JobDataRequiredError       = errors.New("ERR_MISSING_JOB_DATA: the 'job_data' field is required; include the necessary payload for job execution")
 	RetryCountNegativeError    = errors.New("ERR_INVALID_RETRY_COUNT: 'retry_count' must be a non-negative integer; use 0 for no retries or a positive number for retry attempts")
+	RetryIntervalNegativeError = errors.New("ERR_INVALID_RETRY_INTERVAL: 'retry_interval' must be a non-negative number of seconds; use 0 for the default of 30")
 	LimitNegativeError         = errors.New("ERR_INVALID_LIMIT: 'limit' must be a non-negative integer; use 0 for default or specify a positive value")
 	LimitExceededError         = errors.New("ERR_LIMIT_TOO_HIGH: 'limit' exceeds the maximum of 100 records per request; reduce the value or use pagination")
 	BatchSizeExceededError     = errors.New("ERR_BATCH_TOO_LARGE: the number of records in the batch exceeds the allowed maximum; split the data into smaller chunks")
@@ -22,10 +23,80 @@ var (
 
 	InvalidServiceError     = errors.New("ERR_UNKNOWN_SERVICE: the provided service identifier is not recognized; use 'contacts' or 'companies'")
 	InvalidServiceTypeError = errors.New("ERR_UNSUPPORTED_SERVICE: the specified service type is not supported for this operation; verify the endpoint and try again")
+
+	InvalidAsOfError = errors.New("ERR_INVALID_AS_OF: 'as_of' must be an RFC3339 timestamp such as 2024-05-01T00:00:00Z")
+
+	ContactNotFoundError  = errors.New("ERR_CONTACT_NOT_FOUND: no contact exists with the given uuid; verify the identifier and try again")
+	CompanyNotFoundError  = errors.New("ERR_COMPANY_NOT_FOUND: no company exists with the given uuid; verify the identifier and try again")
+	RecordNotFoundAtError = errors.New("ERR_RECORD_NOT_FOUND_AT: the record did not exist yet at the requested 'as_of' time")
+
+	EmptyPatchError     = errors.New("ERR_EMPTY_PATCH: the request body contains no fields to update; include at least one field besides 'updated_at'")
+	UpdateConflictError = errors.New("ERR_UPDATE_CONFLICT: the record was modified after the given 'updated_at'; fetch it again and retry the update")
+
+	SourceJobRequiredError = errors.New("ERR_MISSING_SOURCE_JOB: 'job_uuid' is required; specify the uuid of the import job to roll back")
+	PatchRequiredError     = errors.New("ERR_MISSING_PATCH: 'patch' is required for update_by_vql; provide at least one field to set")
+
+	FilterKeyRequiredError     = errors.New("ERR_MISSING_FILTER_KEY: 'key' is required; specify the record field the filter applies to")
+	DisplayNameRequiredError   = errors.New("ERR_MISSING_DISPLAY_NAME: 'display_name' is required; specify the label shown for the filter")
+	FilterNotFoundError        = errors.New("ERR_FILTER_NOT_FOUND: the service has no filter with the given key; verify the key and try again")
+	FilterExistsError          = errors.New("ERR_FILTER_EXISTS: the service already has a filter with this key; edit the existing filter instead")
+	FilterDirectDerivedError   = errors.New("ERR_FILTER_DIRECT_DERIVED: the filter reads its values straight from the record column and has no filter data to rebuild")
+	FilterNotAggregatableError = errors.New("ERR_FILTER_NOT_AGGREGATABLE: only keyword filters can be scoped to a 'where' query; omit 'where' to list the stored values")
+
+	DeleteMatchLimitExceededError = errors.New("ERR_DELETE_MATCH_LIMIT_EXCEEDED: the query matches more than 10000 records; narrow the query or run a 'delete_by_vql' job")
+
+	JobNotFoundError        = errors.New("ERR_JOB_NOT_FOUND: no job exists with the given uuid; verify the identifier and try again")
+	JobNotExportError       = errors.New("ERR_JOB_NOT_EXPORT: the job is not an 'export_csv_file' job and has no files to download")
+	ExportNotReadyError     = errors.New("ERR_EXPORT_NOT_READY: the export job has not completed; check its status and try again once it is 'completed'")
+	ExportExpiredError      = errors.New("ERR_EXPORT_EXPIRED: the export files were removed after the retention period; run the export again")
+	InvalidDownloadTTLError = errors.New("ERR_INVALID_DOWNLOAD_TTL: 'ttl_minutes' must be a positive integer of at most 10080 (7 days)")
+	JobCancelledError       = errors.New("ERR_JOB_CANCELLED: the job was cancelled before it finished")
+	JobNotCancellableError  = errors.New("ERR_JOB_NOT_CANCELLABLE: the job has already finished as 'completed', 'cancelled' or 'dead' and cannot be cancelled")
+	JobNotDeadError         = errors.New("ERR_JOB_NOT_DEAD: only 'dead' jobs, which used up their retries, can be requeued")
+
+	ParallelExportBundleError    = errors.New("ERR_PARALLEL_EXPORT_BUNDLE: an unordered parallel export writes its parts at the same time and cannot bundle them; set 'ordered' or drop 'bundle'")
+	ParquetMaxBytesPerPartError  = errors.New("ERR_PARQUET_MAX_BYTES_PER_PART: a parquet export holds a row group in memory until it is full and cannot split parts by size; use 'max_rows_per_part' instead")
+	ParallelExportUuidRangeError = errors.New("ERR_PARALLEL_EXPORT_UUID_RANGE: a parallel export splits the records by uuid and cannot also take a 'uuid' range query; drop 'parallel' or the range")
+
+	ExportTemplateNameRequiredError    = errors.New("ERR_MISSING_EXPORT_TEMPLATE_NAME: 'name' is required; name the template so that export jobs can reference it")
+	ExportTemplateColumnsRequiredError = errors.New("ERR_MISSING_EXPORT_TEMPLATE_COLUMNS: 'columns' is required; define at least one output column")
+	ExportTemplateNotFoundError        = errors.New("ERR_EXPORT_TEMPLATE_NOT_FOUND: the service has no export template with the given name; verify the name and try again")
+	ExportTemplateExistsError          = errors.New("ERR_EXPORT_TEMPLATE_EXISTS: the service already has an export template with this name; edit the existing template instead")
+	ExportTemplateConflictError        = errors.New("ERR_EXPORT_TEMPLATE_CONFLICT: an export takes either a 'template' or 'select_columns' with 'column_labels', not both")
+
+	ReindexCheckpointNotFoundError = errors.New("ERR_REINDEX_CHECKPOINT_NOT_FOUND: no resumable reindex was found for this service; start a fresh reindex without --resume")
 )
 
 func InvalidJobTypeError(jobType string) error {
-	return fmt.Errorf("ERR_INVALID_JOB_TYPE: job type '%s' is not recognized; supported types are 'insert_csv_file' and 'export_csv_file'", jobType)
+	return fmt.Errorf("ERR_INVALID_JOB_TYPE: job type '%s' is not recognized; supported types are 'insert_csv_file', 'export_csv_file', 'reconcile', 'rollback_import', 'purge_deleted', 'update_by_vql', 'delete_by_vql', 'rebuild_filter_data' and 'purge_exports'", jobType)
+}
+
+func InvalidExportFormatError(format string) error {
+	return fmt.Errorf("ERR_INVALID_EXPORT_FORMAT: export format '%s' is not supported; use 'csv', 'jsonl', 'xlsx' or 'parquet'", format)
+}
+
+func InvalidExportColumnError(column, service string) error {
+	return fmt.Errorf("ERR_INVALID_EXPORT_COLUMN: '%s' is not a column of a %s export; use a record field, or company.<field> in contact exports", column, service)
+}
+
+func DuplicateExportHeaderError(header string) error {
+	return fmt.Errorf("ERR_DUPLICATE_EXPORT_HEADER: more than one export column is headed '%s'; select each column once and give the labels distinct names", header)
+}
+
+func InvalidExportTransformError(header, transform string) error {
+	return fmt.Errorf("ERR_INVALID_EXPORT_TRANSFORM: transform '%s' of column '%s' is not supported; use 'join', 'coalesce', 'lowercase' or 'format_phone'", transform, header)
+}
+
+func ExportTemplateSourceError(header string) error {
+	return fmt.Errorf("ERR_INVALID_EXPORT_TEMPLATE_SOURCE: column '%s' needs exactly one of 'source' or 'sources', and a 'header' when it reads several sources", header)
+}
+
+func ExportTemplateArityError(header string) error {
+	return fmt.Errorf("ERR_EXPORT_TEMPLATE_ARITY: column '%s' reads several sources but never makes them one value; add a 'join' or 'coalesce' transform", header)
+}
+
+func InvalidExportCompressionError(compression string) error {
+	return fmt.Errorf("ERR_INVALID_EXPORT_COMPRESSION: export compression '%s' is not supported; use 'gzip' or leave it empty", compression)
 }
 
 func ElasticsearchError(statusCode int, body string) error {
@@ -35,3 +106,47 @@ func ElasticsearchError(statusCode int, body string) error {
 func ElasticsearchBulkError(statusCode int, body string) error {
 	return fmt.Errorf("ERR_ELASTICSEARCH_BULK_FAILURE: bulk indexing operation returned status %d; details: %s", statusCode, body)
 }
+
+func SchemaOutdatedError(current, expected int) error {
+	return fmt.Errorf("ERR_SCHEMA_OUTDATED: database schema is at version %d but this binary expects version %d; run 'connectra-api migrate up' before starting", current, expected)
+}
+
+func MigrationFailedError(version int, name string, err error) error {
+	return fmt.Errorf("ERR_MIGRATION_FAILED: migration %04d_%s could not be applied; details: %w", version, name, err)
+}
+
+func MigrationIrreversibleError(reason string) error {
+	return fmt.Errorf("ERR_MIGRATION_IRREVERSIBLE: this migration cannot be reverted, %s; drop the objects by hand if they really must go", reason)
+}
+
+func InvalidMergeFieldError(field string) error {
+	return fmt.Errorf("ERR_INVALID_MERGE_FIELD: '%s' in 'merge_policy.fields' is not a data field of the records; use the json name of a contact or company field, system fields such as uuid and the provenance fields cannot take a policy", field)
+}
+
+func InvalidMergePolicyError(field, policy string) error {
+	return fmt.Errorf("ERR_INVALID_MERGE_POLICY: merge policy '%s' for '%s' is not recognized; use 'overwrite', 'keep_existing', 'prefer_newer' or 'union'", policy, field)
+}
+
+func InvalidPatchFieldError(field string) error {
+	return fmt.Errorf("ERR_INVALID_PATCH_FIELD: field '%s' does not exist or is managed by the system and cannot be updated", field)
+}
+
+func InvalidPatchValueError(field string, err error) error {
+	return fmt.Errorf("ERR_INVALID_PATCH_VALUE: value of field '%s' has the wrong type; details: %w", field, err)
+}
+
+func VQLMatchLimitExceededError(matched, limit int64) error {
+	return fmt.Errorf("ERR_VQL_MATCH_LIMIT_EXCEEDED: the query matches %d records, more than the allowed %d; narrow the query or raise 'max_records'", matched, limit)
+}
+
+func InvalidFilterTypeError(filterType string) error {
+	return fmt.Errorf("ERR_INVALID_FILTER_TYPE: filter type '%s' is not recognized; use 'keyword', 'text' or 'range'", filterType)
+}
+
+func InvalidFilterSortError(sortBy string) error {
+	return fmt.Errorf("ERR_INVALID_FILTER_SORT: 'sort_by' value '%s' is not recognized; use 'count' or 'alpha'", sortBy)
+}
+
+func InvalidFilterKeyError(key, service string) error {
+	return fmt.Errorf("ERR_INVALID_FILTER_KEY: '%s' is not a field of a %s record; use one of its data columns", key, service)
+}
@@ -1,17 +1,95 @@
 package constants
 
+import "time"
+
 var (
 	OpenJobStatus       = "open"
 	InQueueJobStatus    = "in_queue"
 	ProcessingJobStatus = "processing"
 	CompletedJobStatus  = "completed"
 	FailedJobStatus     = "failed"
+	CancelledJobStatus  = "cancelled"
+	DeadJobStatus       = "dead"
 
 	RetryInQueuedJobStatus = "retry_in_queued"
 	RetryingJobStatus      = "retrying"
 
-	FirstTimeJobType = "first_time"
-	RetryJobType     = "retry"
-	InsertCsvFile    = "insert_csv_file"
-	ExportCsvFile    = "export_csv_file"
+	// LeasedJobStatuses are held by the worker that claimed the job.
+	LeasedJobStatuses = []string{InQueueJobStatus, ProcessingJobStatus, RetryInQueuedJobStatus}
+
+	FirstTimeJobType  = "first_time"
+	RetryJobType      = "retry"
+	InsertCsvFile     = "insert_csv_file"
+	ExportCsvFile     = "export_csv_file"
+	Reconcile         = "reconcile"
+	RollbackImport    = "rollback_import"
+	PurgeDeleted      = "purge_deleted"
+	UpdateByVQL       = "update_by_vql"
+	DeleteByVQL       = "delete_by_vql"
+	RebuildFilterData = "rebuild_filter_data"
+	PurgeExports      = "purge_exports"
+
+	DefaultDeletedRetentionDays = 30
+	DefaultExportRetentionDays  = 7
+	DefaultVQLMutationLimit     = int64(10000)
+
+	// POST /delete deletes at most MaxDeleteByFiltersRecords matches, in
+	// batches of DeleteByFiltersBatchSize.
+	MaxDeleteByFiltersRecords = int64(10000)
+	DeleteByFiltersBatchSize  = 500
+
+	// PurgeDeletedInterval is how often purge_deleted runs; every run
+	// queues the next one.
+	PurgeDeletedInterval = 24 * time.Hour
+
+	// Retries wait retry_interval seconds, doubled on every attempt up to
+	// MaxJobRetryBackoff, less up to half of that as jitter.
+	DefaultJobRetryInterval = 30
+	MaxJobRetryBackoff      = time.Hour
+
+	// DefaultJobLeaseSeconds is how long a worker holds a job it claimed
+	// without renewing the lease; it renews every third of that.
+	DefaultJobLeaseSeconds = 60
+	// LegacyJobReapAfter is how long a job claimed before leases existed may
+	// go without an update before the reaper returns it to the queue; old
+	// workers do not heartbeat, so it has to outlast their longest job.
+	LegacyJobReapAfter = 24 * time.Hour
+
+	// JobCancelPollInterval is how often a running job checks whether it
+	// was asked to stop.
+	JobCancelPollInterval = 5 * time.Second
+
+	// Presigned export download links; S3 does not sign links for longer
+	// than 7 days.
+	DefaultDownloadURLTTLMinutes = 60
+	MaxDownloadURLTTLMinutes     = 7 * 24 * 60
+)
+
+// Export file formats; the format is also the file extension.
+var (
+	ExportFormatCsv     = "csv"
+	ExportFormatJsonl   = "jsonl"
+	ExportFormatXlsx    = "xlsx"
+	ExportFormatParquet = "parquet"
+
+	ExportCompressionGzip = "gzip"
+
+	XlsxMaxSheetRows    = 1048576
+	ParquetRowGroupSize = int64(50000)
+
+	// Parallel exports: the workers, the batches each slice of an ordered
+	// export buffers, and the most slices an export is split into.
+	DefaultExportWorkers     = 4
+	DefaultExportSliceBuffer = 8
+	MaxExportSlices          = 4096
+
+	// Transforms of export template columns.
+	ExportTransformJoin        = "join"
+	ExportTransformCoalesce    = "coalesce"
+	ExportTransformLowercase   = "lowercase"
+	ExportTransformFormatPhone = "format_phone"
+
+	// CompanyColumnPrefix marks the company columns of a contact export,
+	// such as company.name.
+	CompanyColumnPrefix = "company."
 )
@@ -5,3 +5,16 @@ var (
 	CompaniesService = "company"
 	AuthService      = "auth"
 )
+
+// Filter types follow the VQL clause a filter's values are used in.
+var (
+	FilterTypeKeyword = "keyword"
+	FilterTypeText    = "text"
+	FilterTypeRange   = "range"
+)
+
+// Orders of the options returned for a filter scoped to a VQL query.
+var (
+	FilterSortCount = "count"
+	FilterSortAlpha = "alpha"
+)
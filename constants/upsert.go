@@ -0,0 +1,14 @@
+package constants
+
+var (
+	MergeOverwrite    = "overwrite"
+	MergeKeepExisting = "keep_existing"
+	MergePreferNewer  = "prefer_newer"
+	MergeUnion        = "union"
+)
+
+var (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
@@ -0,0 +1,9 @@
+package examples
+
+import _ "embed"
+
+//go:embed contact_index_create.json
+var ContactIndexMapping []byte
+
+//go:embed company_index_create.json
+var CompanyIndexMapping []byte
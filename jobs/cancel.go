@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"context"
+	"time"
+	"vivek-ray/constants"
+
+	"github.com/rs/zerolog/log"
+)
+
+// watchCancellation returns a context for running the job that is cancelled
+// with JobCancelledError once the job's cancel request is seen. Jobs stop at
+// their next check between batches. The returned func stops the polling.
+func (j *JobStruct) watchCancellation(ctx context.Context, jobUUID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(constants.JobCancelPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				requested, err := j.JobsRepository.CancelRequested(jobUUID)
+				if err != nil {
+					log.Error().Err(err).Msgf("Failed to check job %s for cancellation", jobUUID)
+					continue
+				}
+				if requested {
+					log.Info().Msgf("Cancelling job %s", jobUUID)
+					cancel(constants.JobCancelledError)
+					return
+				}
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// cancellableExportWriter stops an export between batches once its job is
+// cancelled.
+type cancellableExportWriter struct {
+	exportWriter
+	ctx context.Context
+}
+
+func (w *cancellableExportWriter) Flush() error {
+	if err := context.Cause(w.ctx); err != nil {
+		return err
+	}
+	return w.exportWriter.Flush()
+}
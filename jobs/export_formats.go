@@ -0,0 +1,461 @@
+package jobs
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/utilities"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+)
+
+// exportColumn is one column of an export: the header written to the file and
+// the Go type of its values, which the typed formats map to a column type.
+// Columns are checked against the record fields before they are typed, so
+// Type is never nil.
+type exportColumn struct {
+	Header string
+	Type   reflect.Type
+}
+
+// exportWriter streams the rows of an export in one file format. Flush is
+// called after every batch; Close finishes the file and must be called once
+// all rows are written.
+type exportWriter interface {
+	Write(values []any) error
+	Flush() error
+	Close() error
+}
+
+// exportExtension returns the file extension of format, which defaults to csv.
+func exportExtension(format string) (string, error) {
+	switch format {
+	case "", constants.ExportFormatCsv:
+		return constants.ExportFormatCsv, nil
+	case constants.ExportFormatJsonl, constants.ExportFormatXlsx, constants.ExportFormatParquet:
+		return format, nil
+	}
+	return "", constants.InvalidExportFormatError(format)
+}
+
+func newExportWriter(format string, output io.Writer, columns []exportColumn) (exportWriter, error) {
+	switch format {
+	case "", constants.ExportFormatCsv:
+		return newCsvExportWriter(output, columns)
+	case constants.ExportFormatJsonl:
+		return newJsonlExportWriter(output, columns), nil
+	case constants.ExportFormatXlsx:
+		return newXlsxExportWriter(output, columns)
+	case constants.ExportFormatParquet:
+		return newParquetExportWriter(output, columns), nil
+	}
+	return nil, constants.InvalidExportFormatError(format)
+}
+
+// dereference returns the value a non-nil pointer points to, and nil for a
+// nil pointer, map or slice, so that the writers only see plain values.
+func dereference(value any) any {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return rv.Elem().Interface()
+	case reflect.Map, reflect.Slice:
+		if rv.IsNil() {
+			return nil
+		}
+	}
+	return value
+}
+
+// countingExportWriter counts the rows written through it.
+type countingExportWriter struct {
+	exportWriter
+	rows int64
+}
+
+func (w *countingExportWriter) Write(values []any) error {
+	if err := w.exportWriter.Write(values); err != nil {
+		return err
+	}
+	w.rows++
+	return nil
+}
+
+// exportOutput counts and hashes the bytes of an export on their way to S3.
+type exportOutput struct {
+	writer io.Writer
+	hash   hash.Hash
+	bytes  int64
+}
+
+func newExportOutput(writer io.Writer) *exportOutput {
+	return &exportOutput{writer: writer, hash: sha256.New()}
+}
+
+func (o *exportOutput) Write(p []byte) (int, error) {
+	n, err := o.writer.Write(p)
+	o.hash.Write(p[:n])
+	o.bytes += int64(n)
+	return n, err
+}
+
+func (o *exportOutput) Checksum() string {
+	return hex.EncodeToString(o.hash.Sum(nil))
+}
+
+type csvExportWriter struct {
+	csvWriter *csv.Writer
+	row       []string
+}
+
+func newCsvExportWriter(output io.Writer, columns []exportColumn) (*csvExportWriter, error) {
+	writer := &csvExportWriter{csvWriter: csv.NewWriter(output), row: make([]string, len(columns))}
+	for i, column := range columns {
+		writer.row[i] = column.Header
+	}
+	return writer, writer.csvWriter.Write(writer.row)
+}
+
+func (w *csvExportWriter) Write(values []any) error {
+	for i, value := range values {
+		w.row[i] = utilities.CsvValue(dereference(value))
+	}
+	return w.csvWriter.Write(w.row)
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.csvWriter.Flush()
+	return w.csvWriter.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	return w.Flush()
+}
+
+// jsonlExportWriter writes one json object per row with the keys in column
+// order. Arrays stay arrays and missing values are null.
+type jsonlExportWriter struct {
+	output  *bufio.Writer
+	keys    []string
+	line    bytes.Buffer
+	encoder *json.Encoder
+}
+
+func newJsonlExportWriter(output io.Writer, columns []exportColumn) *jsonlExportWriter {
+	writer := &jsonlExportWriter{output: bufio.NewWriter(output), keys: make([]string, len(columns))}
+	writer.encoder = json.NewEncoder(&writer.line)
+	writer.encoder.SetEscapeHTML(false)
+	for i, column := range columns {
+		key, _ := json.Marshal(column.Header)
+		writer.keys[i] = string(key) + ":"
+	}
+	return writer
+}
+
+func (w *jsonlExportWriter) Write(values []any) error {
+	w.line.Reset()
+	w.line.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			w.line.WriteByte(',')
+		}
+		w.line.WriteString(w.keys[i])
+		if err := w.encoder.Encode(dereference(value)); err != nil {
+			return err
+		}
+		// Encode ends every value with a newline
+		w.line.Truncate(w.line.Len() - 1)
+	}
+	w.line.WriteString("}\n")
+	_, err := w.output.Write(w.line.Bytes())
+	return err
+}
+
+func (w *jsonlExportWriter) Flush() error {
+	return w.output.Flush()
+}
+
+func (w *jsonlExportWriter) Close() error {
+	return w.output.Flush()
+}
+
+// xlsxExportWriter streams a workbook with inline strings, so rows are never
+// held in memory. A sheet that reaches the Excel row limit is continued on a
+// new sheet with the same header; the workbook parts listing the sheets are
+// written on Close.
+type xlsxExportWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	columns []exportColumn
+
+	sheets    int
+	sheetRows int
+	cell      bytes.Buffer
+}
+
+const xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const xlsxSheetFooter = `</sheetData></worksheet>`
+
+func newXlsxExportWriter(output io.Writer, columns []exportColumn) (*xlsxExportWriter, error) {
+	writer := &xlsxExportWriter{archive: zip.NewWriter(output), columns: columns}
+	return writer, writer.nextSheet()
+}
+
+// nextSheet ends the current sheet, if any, and starts the next one with the
+// header row.
+func (w *xlsxExportWriter) nextSheet() error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+	w.sheets++
+	file, err := w.archive.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", w.sheets))
+	if err != nil {
+		return err
+	}
+	w.sheet = bufio.NewWriter(file)
+	w.sheetRows = 0
+	if _, err := w.sheet.WriteString(xlsxSheetHeader); err != nil {
+		return err
+	}
+	header := make([]any, len(w.columns))
+	for i, column := range w.columns {
+		header[i] = column.Header
+	}
+	return w.writeRow(header)
+}
+
+func (w *xlsxExportWriter) endSheet() error {
+	if w.sheet == nil {
+		return nil
+	}
+	if _, err := w.sheet.WriteString(xlsxSheetFooter); err != nil {
+		return err
+	}
+	return w.sheet.Flush()
+}
+
+func (w *xlsxExportWriter) writeRow(values []any) error {
+	w.cell.Reset()
+	w.cell.WriteString("<row>")
+	for _, value := range values {
+		switch v := dereference(value).(type) {
+		case nil:
+			w.cell.WriteString("<c/>")
+		case int, int32, int64, uint, uint32, uint64, float32, float64:
+			fmt.Fprintf(&w.cell, "<c><v>%v</v></c>", v)
+		case bool:
+			fmt.Fprintf(&w.cell, `<c t="b"><v>%d</v></c>`, utilities.InlineIf(v, 1, 0))
+		default:
+			text := xlsxText(v)
+			w.cell.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(&w.cell, []byte(text)); err != nil {
+				return err
+			}
+			w.cell.WriteString("</t></is></c>")
+		}
+	}
+	w.cell.WriteString("</row>")
+	w.sheetRows++
+	_, err := w.sheet.Write(w.cell.Bytes())
+	return err
+}
+
+func xlsxText(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ", ")
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
+
+func (w *xlsxExportWriter) Write(values []any) error {
+	if w.sheetRows >= constants.XlsxMaxSheetRows {
+		if err := w.nextSheet(); err != nil {
+			return err
+		}
+	}
+	return w.writeRow(values)
+}
+
+func (w *xlsxExportWriter) Flush() error {
+	return w.sheet.Flush()
+}
+
+func (w *xlsxExportWriter) Close() error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+	var contentTypes, workbook, workbookRels strings.Builder
+	contentTypes.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	workbookRels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= w.sheets; i++ {
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+		fmt.Fprintf(&workbook, `<sheet name="Sheet%d" sheetId="%d" r:id="rId%d"/>`, i, i, i)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" `+
+			`Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	workbookRels.WriteString(`</Relationships>`)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", workbookRels.String()},
+	}
+	for _, part := range parts {
+		file, err := w.archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.content); err != nil {
+			return err
+		}
+	}
+	return w.archive.Close()
+}
+
+// parquetExportWriter writes snappy compressed row groups of at most
+// ParquetRowGroupSize rows, typed after the record fields: arrays become
+// repeated strings, timestamps millisecond timestamps, and anything else
+// without a direct parquet type a json string.
+type parquetExportWriter struct {
+	writer  *parquet.Writer
+	columns []exportColumn
+	row     map[string]any
+}
+
+func parquetNode(columnType reflect.Type) parquet.Node {
+	if columnType.Kind() == reflect.Ptr {
+		columnType = columnType.Elem()
+	}
+	if columnType == reflect.TypeOf(time.Time{}) {
+		return parquet.Optional(parquet.Timestamp(parquet.Millisecond))
+	}
+	switch columnType.Kind() {
+	case reflect.String:
+		return parquet.Optional(parquet.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return parquet.Optional(parquet.Int(64))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return parquet.Optional(parquet.Uint(64))
+	case reflect.Float32, reflect.Float64:
+		return parquet.Optional(parquet.Leaf(parquet.DoubleType))
+	case reflect.Bool:
+		return parquet.Optional(parquet.Leaf(parquet.BooleanType))
+	case reflect.Slice:
+		if columnType.Elem().Kind() == reflect.String {
+			return parquet.Repeated(parquet.String())
+		}
+	}
+	return parquet.Optional(parquet.JSON())
+}
+
+// parquetColumns is a group whose fields keep the order of the export
+// columns, where a plain parquet.Group sorts them by name.
+type parquetColumns struct {
+	parquet.Group
+	headers []string
+}
+
+func (g parquetColumns) Fields() []parquet.Field {
+	fields := g.Group.Fields()
+	slices.SortStableFunc(fields, func(a, b parquet.Field) int {
+		return slices.Index(g.headers, a.Name()) - slices.Index(g.headers, b.Name())
+	})
+	return fields
+}
+
+func newParquetExportWriter(output io.Writer, columns []exportColumn) *parquetExportWriter {
+	group, headers := make(parquet.Group, len(columns)), make([]string, 0, len(columns))
+	for _, column := range columns {
+		group[column.Header] = parquetNode(column.Type)
+		headers = append(headers, column.Header)
+	}
+	return &parquetExportWriter{
+		writer: parquet.NewWriter(
+			output,
+			parquet.NewSchema("export", parquetColumns{Group: group, headers: headers}),
+			parquet.Compression(&snappy.Codec{}),
+			parquet.MaxRowsPerRowGroup(constants.ParquetRowGroupSize),
+		),
+		columns: columns,
+		row:     make(map[string]any, len(columns)),
+	}
+}
+
+func (w *parquetExportWriter) Write(values []any) error {
+	for i, value := range values {
+		value = dereference(value)
+		if value != nil && !parquetNativeValue(value) {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			value = string(encoded)
+		}
+		w.row[w.columns[i].Header] = value
+	}
+	return w.writer.Write(w.row)
+}
+
+// parquetNativeValue reports whether value can be written as is to the
+// column parquetNode picked for its type.
+func parquetNativeValue(value any) bool {
+	switch value.(type) {
+	case string, []string, time.Time, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
+
+// Flush is a no-op: the writer flushes a row group whenever it is full, and
+// smaller row groups would only make the file slower to read.
+func (w *parquetExportWriter) Flush() error {
+	return nil
+}
+
+func (w *parquetExportWriter) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,103 @@
+package jobs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var testExportColumns = []exportColumn{
+	{Header: "name", Type: reflect.TypeOf("")},
+	{Header: "employees", Type: reflect.TypeOf(int64(0))},
+	{Header: "departments", Type: reflect.TypeOf([]string{})},
+	{Header: "source_date", Type: reflect.TypeOf(&time.Time{})},
+	{Header: "revenue", Type: reflect.TypeOf((*int64)(nil))},
+}
+
+func testExportRows() [][]any {
+	sourceDate, revenue := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), int64(1000000)
+	return [][]any{
+		{"Acme, Inc.", int64(250), []string{"sales", "ops"}, &sourceDate, &revenue},
+		{"", int64(0), []string(nil), (*time.Time)(nil), (*int64)(nil)},
+	}
+}
+
+func writeTestExport(t *testing.T, format string) []byte {
+	t.Helper()
+	var output bytes.Buffer
+	writer, err := newExportWriter(format, &output, testExportColumns)
+	if err != nil {
+		t.Fatalf("newExportWriter(%q) error = %v", format, err)
+	}
+	for _, row := range testExportRows() {
+		if err := writer.Write(row); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return output.Bytes()
+}
+
+func TestExportWriters(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "csv",
+			format: "csv",
+			want: "name,employees,departments,source_date,revenue\n" +
+				"\"Acme, Inc.\",250,\"sales,ops\",2024-05-01 10:30:00 +0000 UTC,1000000\n" +
+				",0,,,\n",
+		},
+		{
+			name:   "jsonl",
+			format: "jsonl",
+			want: `{"name":"Acme, Inc.","employees":250,"departments":["sales","ops"],"source_date":"2024-05-01T10:30:00Z","revenue":1000000}` + "\n" +
+				`{"name":"","employees":0,"departments":null,"source_date":null,"revenue":null}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(writeTestExport(t, test.format)); got != test.want {
+				t.Errorf("export =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParquetExportWriter(t *testing.T) {
+	output := writeTestExport(t, "parquet")
+	file, err := parquet.OpenFile(bytes.NewReader(output), int64(len(output)))
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	headers := make([]string, 0, len(testExportColumns))
+	for _, field := range file.Schema().Fields() {
+		headers = append(headers, field.Name())
+	}
+	if want := []string{"name", "employees", "departments", "source_date", "revenue"}; !reflect.DeepEqual(headers, want) {
+		t.Errorf("columns = %v, want %v", headers, want)
+	}
+
+	reader := parquet.NewReader(bytes.NewReader(output))
+	want := []map[string]any{
+		{"name": "Acme, Inc.", "employees": int64(250), "departments": []any{"sales", "ops"}, "source_date": time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC).UnixMilli(), "revenue": int64(1000000)},
+		{"name": "", "employees": int64(0), "departments": []any{}, "source_date": nil, "revenue": nil},
+	}
+	for i, wantRow := range want {
+		row := make(map[string]any)
+		if err := reader.Read(&row); err != nil {
+			t.Fatalf("Read() row %d error = %v", i, err)
+		}
+		if !reflect.DeepEqual(row, wantRow) {
+			t.Errorf("row %d = %#v, want %#v", i, row, wantRow)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"vivek-ray/conf"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	companyService "vivek-ray/modules/companies/service"
+	contactService "vivek-ray/modules/contacts/service"
+	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
+)
+
+// exportSource writes the records matching a query to the writer, one batch
+// per Flush, like ExportContactsToStream.
+type exportSource func(writer exportWriter, vql utilities.VQLQuery) error
+
+var errExportStopped = errors.New("export stopped")
+
+// laneExportWriter is an exportWriter that takes rows from several goroutines
+// at once, each through a lane of its own.
+type laneExportWriter interface {
+	exportWriter
+	Lane() exportWriter
+}
+
+// uuidSlices splits the uuids into count ranges of the same width, bounded by
+// hex prefixes, and returns their range conditions from the highest range to
+// the lowest, the order of a serial export. The first range has no upper
+// bound and the last no lower one, so every uuid falls in exactly one range.
+func uuidSlices(count int) []map[string]any {
+	bounds := make([]string, count+1)
+	for i := 1; i < count; i++ {
+		bounds[i] = fmt.Sprintf("%08x", (uint64(i)<<32)/uint64(count))
+	}
+	ranges := make([]map[string]any, 0, count)
+	for i := count - 1; i >= 0; i-- {
+		condition := make(map[string]any)
+		if bounds[i] != "" {
+			condition["gte"] = bounds[i]
+		}
+		if bounds[i+1] != "" {
+			condition["lt"] = bounds[i+1]
+		}
+		ranges = append(ranges, condition)
+	}
+	return ranges
+}
+
+// sliceVQL narrows the query to one uuid range.
+func sliceVQL(vql utilities.VQLQuery, condition map[string]any) utilities.VQLQuery {
+	if len(condition) == 0 {
+		return vql
+	}
+	vql.Where.RangeQuery.Must = maps.Clone(vql.Where.RangeQuery.Must)
+	if vql.Where.RangeQuery.Must == nil {
+		vql.Where.RangeQuery.Must = make(map[string]any)
+	}
+	vql.Where.RangeQuery.Must["uuid"] = condition
+	return vql
+}
+
+func countExport(service string, vql utilities.VQLQuery) (int64, error) {
+	if service == constants.ContactsService {
+		return contactService.NewContactService([]*models.ModelFilter{}).CountByFilters(vql)
+	}
+	return companyService.NewCompanyService([]*models.ModelFilter{}).CountByFilters(vql)
+}
+
+// sliceExportWriter hands the rows of one slice of an ordered export to the
+// goroutine writing the file, a batch per Flush. Flush blocks while the slice
+// has buffered as many batches as allowed.
+type sliceExportWriter struct {
+	rows    [][]any
+	batches chan [][]any
+	done    <-chan struct{}
+}
+
+func (w *sliceExportWriter) Write(values []any) error {
+	w.rows = append(w.rows, values)
+	return nil
+}
+
+func (w *sliceExportWriter) Flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	select {
+	case w.batches <- w.rows:
+		w.rows = nil
+		return nil
+	case <-w.done:
+		return errExportStopped
+	}
+}
+
+func (w *sliceExportWriter) Close() error {
+	return w.Flush()
+}
+
+// exportRun is the state the workers of a parallel export share: the slices
+// not taken yet and the first error, which stops every worker.
+type exportRun struct {
+	next chan int
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newExportRun(slices int) *exportRun {
+	run := &exportRun{next: make(chan int, slices), done: make(chan struct{})}
+	for i := 0; i < slices; i++ {
+		run.next <- i
+	}
+	close(run.next)
+	return run
+}
+
+func (r *exportRun) fail(err error) {
+	r.once.Do(func() {
+		r.err = err
+		close(r.done)
+	})
+}
+
+func (r *exportRun) stopped() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// take returns the next slice, or false once there is none or the run failed.
+func (r *exportRun) take() (int, bool) {
+	if r.stopped() {
+		return 0, false
+	}
+	slice, ok := <-r.next
+	return slice, ok
+}
+
+// exportInParallel reads the slices of the export with EXPORT_WORKERS workers.
+// An ordered export writes the slices one after the other through writer,
+// while later slices are read ahead into a buffer of EXPORT_SLICE_BUFFER_BATCHES
+// batches each; the slices are sized to fit that buffer, so the workers
+// rarely wait. An unordered export gives every worker a lane of the writer.
+func exportInParallel(jobData utilities.ExportFileJobData, export exportSource, writer *countingExportWriter, vql utilities.VQLQuery) error {
+	if _, ok := vql.Where.RangeQuery.Must["uuid"]; ok {
+		return constants.ParallelExportUuidRangeError
+	}
+	workers := utilities.InlineIf(conf.JobConfig.ExportWorkers > 0, conf.JobConfig.ExportWorkers, constants.DefaultExportWorkers).(int)
+	buffer := utilities.InlineIf(conf.JobConfig.ExportSliceBuffer > 0, conf.JobConfig.ExportSliceBuffer, constants.DefaultExportSliceBuffer).(int)
+
+	if !jobData.Ordered {
+		lanes, ok := writer.exportWriter.(laneExportWriter)
+		if !ok {
+			return fmt.Errorf("export writer %T cannot take rows from several workers", writer.exportWriter)
+		}
+		return exportUnordered(export, lanes, writer, vql, workers)
+	}
+
+	total, err := countExport(jobData.Service, vql)
+	if err != nil {
+		return err
+	}
+	sliceRows := int64(buffer * utilities.InlineIf(vql.Limit > 0, vql.Limit, constants.DefaultPageSize).(int))
+	count := int((total + sliceRows - 1) / sliceRows)
+	count = min(max(count, workers), constants.MaxExportSlices)
+	log.Info().Msgf("Exporting %d records in %d slices with %d workers", total, count, workers)
+	return exportOrdered(export, writer, vql, uuidSlices(count), workers, buffer)
+}
+
+func exportOrdered(export exportSource, writer exportWriter, vql utilities.VQLQuery, slices []map[string]any, workers, buffer int) error {
+	run := newExportRun(len(slices))
+	batches := make([]chan [][]any, len(slices))
+	for i := range batches {
+		batches[i] = make(chan [][]any, buffer)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				slice, ok := run.take()
+				if !ok {
+					return
+				}
+				sliceWriter := &sliceExportWriter{batches: batches[slice], done: run.done}
+				err := export(sliceWriter, sliceVQL(vql, slices[slice]))
+				if err == nil {
+					err = sliceWriter.Close()
+				}
+				close(batches[slice])
+				if err != nil {
+					run.fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+	// the slices are taken in order, so the one written next is always
+	// being read or done already
+	for slice := range slices {
+		for rows := range batches[slice] {
+			if err := writeBatch(writer, rows); err != nil {
+				run.fail(err)
+			}
+			if run.stopped() {
+				break
+			}
+		}
+		if run.stopped() {
+			break
+		}
+	}
+	wg.Wait()
+	return run.err
+}
+
+func writeBatch(writer exportWriter, rows [][]any) error {
+	for _, values := range rows {
+		if err := writer.Write(values); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+func exportUnordered(export exportSource, lanes laneExportWriter, writer *countingExportWriter, vql utilities.VQLQuery, workers int) error {
+	slices := uuidSlices(workers)
+	run := newExportRun(len(slices))
+	counters := make([]*countingExportWriter, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		counters[w] = &countingExportWriter{exportWriter: lanes.Lane()}
+		wg.Add(1)
+		go func(lane *countingExportWriter) {
+			defer wg.Done()
+			for {
+				slice, ok := run.take()
+				if !ok {
+					break
+				}
+				if err := export(lane, sliceVQL(vql, slices[slice])); err != nil {
+					run.fail(err)
+					return
+				}
+			}
+			if err := lane.Close(); err != nil {
+				run.fail(err)
+			}
+		}(counters[w])
+	}
+	wg.Wait()
+
+	for _, counter := range counters {
+		writer.rows += counter.rows
+	}
+	return run.err
+}
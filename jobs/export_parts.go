@@ -0,0 +1,335 @@
+package jobs
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"vivek-ray/conf"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
+)
+
+// s3Upload streams one object to S3 through a pipe. If the upload fails, the
+// pipe is closed with its error so that writes to it fail instead of blocking.
+type s3Upload struct {
+	key  string
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func startS3Upload(bucket, key string) *s3Upload {
+	reader, writer := io.Pipe()
+	upload := &s3Upload{key: key, pipe: writer, done: make(chan error, 1)}
+	go func() {
+		err := connections.S3Connection.WriteFileStream(context.Background(), bucket, key, reader)
+		if err != nil {
+			reader.CloseWithError(err)
+		}
+		upload.done <- err
+	}()
+	return upload
+}
+
+// finish ends the object and waits for the upload. A non-nil err aborts the
+// upload instead, so that no truncated object is left behind.
+func (u *s3Upload) finish(err error) error {
+	u.pipe.CloseWithError(err)
+	uploadErr := <-u.done
+	if err != nil {
+		return err
+	}
+	return uploadErr
+}
+
+// exportLayout names the files of an export. A split export is a folder of
+// numbered parts, otherwise the export is a single file named after the job.
+// Unordered parallel exports write a part per worker, so they are split too.
+type exportLayout struct {
+	prefix    string
+	split     bool
+	extension string
+}
+
+func newExportLayout(jobUUID string, jobData utilities.ExportFileJobData, extension string) exportLayout {
+	if jobData.Compression == constants.ExportCompressionGzip {
+		extension += ".gz"
+	}
+	return exportLayout{
+		prefix:    fmt.Sprintf("%s/%s", conf.S3StorageConfig.S3UploadFilePath, jobUUID),
+		split:     jobData.MaxRowsPerPart > 0 || jobData.MaxBytesPerPart > 0 || (jobData.Parallel && !jobData.Ordered),
+		extension: extension,
+	}
+}
+
+func (l exportLayout) partKey(number int) string {
+	if !l.split {
+		return l.prefix + "." + l.extension
+	}
+	return fmt.Sprintf("%s/part-%04d.%s", l.prefix, number, l.extension)
+}
+
+func (l exportLayout) bundleKey() string {
+	return utilities.InlineIf(l.split, l.prefix+"/bundle.zip", l.prefix+".zip").(string)
+}
+
+// location is where the job response points to: the single file, or the
+// folder of the parts.
+func (l exportLayout) location() string {
+	return utilities.InlineIf(l.split, l.prefix+"/", l.partKey(1)).(string)
+}
+
+// exportPart is the part being written: its upload, the optional gzip stream
+// in front of it and the format writer in front of that.
+type exportPart struct {
+	number int
+	upload *s3Upload
+	output *exportOutput
+	gzip   *gzip.Writer
+	writer exportWriter
+	rows   int64
+}
+
+// partedExportWriter is the exportWriter of export jobs. It starts a new part
+// once the current one has max_rows_per_part rows, or, checked after every
+// batch, max_bytes_per_part bytes, which parquet exports do not take. Every part is a complete file of the format
+// with its own header, gzipped when asked. With a bundle, the parts are also
+// copied into a zip as they are written.
+type partedExportWriter struct {
+	jobData utilities.ExportFileJobData
+	layout  exportLayout
+	columns []exportColumn
+
+	main  *exportLane
+	lanes []*exportLane
+
+	mu      sync.Mutex
+	started int
+	parts   []utilities.ExportPart
+
+	bundle        *s3Upload
+	bundleArchive *zip.Writer
+	// bundled is set once the bundle is uploaded
+	bundled bool
+}
+
+// exportLane writes parts one after the other. Besides its own, a parted
+// writer has a lane for every worker of an unordered parallel export.
+type exportLane struct {
+	writer  *partedExportWriter
+	current *exportPart
+}
+
+func newPartedExportWriter(jobData utilities.ExportFileJobData, layout exportLayout, columns []exportColumn) *partedExportWriter {
+	writer := &partedExportWriter{jobData: jobData, layout: layout, columns: columns}
+	writer.main = &exportLane{writer: writer}
+	writer.lanes = []*exportLane{writer.main}
+	if jobData.Bundle {
+		writer.bundle = startS3Upload(jobData.FileS3Bucket, layout.bundleKey())
+		writer.bundleArchive = zip.NewWriter(writer.bundle.pipe)
+	}
+	return writer
+}
+
+// Lane returns a writer of its own parts for one goroutine. Lanes have to be
+// closed before the parted writer.
+func (w *partedExportWriter) Lane() exportWriter {
+	lane := &exportLane{writer: w}
+	w.mu.Lock()
+	w.lanes = append(w.lanes, lane)
+	w.mu.Unlock()
+	return lane
+}
+
+// Parts returns the uploaded parts in the order of their numbers.
+func (w *partedExportWriter) Parts() []utilities.ExportPart {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	parts := slices.Clone(w.parts)
+	// keys past part-9999 grow a digit, so they do not sort as strings
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts
+}
+
+func (l *exportLane) startPart() error {
+	w := l.writer
+	w.mu.Lock()
+	w.started++
+	number := w.started
+	w.mu.Unlock()
+
+	key := w.layout.partKey(number)
+	part := &exportPart{number: number, upload: startS3Upload(w.jobData.FileS3Bucket, key)}
+	part.output = newExportOutput(part.upload.pipe)
+	var output io.Writer = part.output
+	if w.bundleArchive != nil {
+		// gzip, xlsx and parquet files are compressed already
+		method := zip.Deflate
+		if w.jobData.Compression != "" || w.jobData.Format == constants.ExportFormatXlsx || w.jobData.Format == constants.ExportFormatParquet {
+			method = zip.Store
+		}
+		entry, err := w.bundleArchive.CreateHeader(&zip.FileHeader{Name: path.Base(key), Method: method, Modified: time.Now()})
+		if err != nil {
+			return abortPart(part, err)
+		}
+		output = io.MultiWriter(part.output, entry)
+	}
+	if w.jobData.Compression == constants.ExportCompressionGzip {
+		part.gzip = gzip.NewWriter(output)
+		output = part.gzip
+	}
+	writer, err := newExportWriter(w.jobData.Format, output, w.columns)
+	if err != nil {
+		return abortPart(part, err)
+	}
+	part.writer = writer
+	l.current = part
+	return nil
+}
+
+func abortPart(part *exportPart, err error) error {
+	part.upload.finish(err)
+	return err
+}
+
+func (l *exportLane) finishPart() error {
+	part := l.current
+	l.current = nil
+	err := part.writer.Close()
+	if err == nil && part.gzip != nil {
+		err = part.gzip.Close()
+	}
+	if err := part.upload.finish(err); err != nil {
+		return err
+	}
+	l.writer.mu.Lock()
+	l.writer.parts = append(l.writer.parts, utilities.ExportPart{
+		Number:   part.number,
+		S3Key:    part.upload.key,
+		Rows:     part.rows,
+		Bytes:    part.output.bytes,
+		Checksum: part.output.Checksum(),
+	})
+	l.writer.mu.Unlock()
+	log.Info().Msgf("Uploaded export part %s with %d rows", part.upload.key, part.rows)
+	return nil
+}
+
+func (l *exportLane) Write(values []any) error {
+	maxRows := l.writer.jobData.MaxRowsPerPart
+	if l.current != nil && maxRows > 0 && l.current.rows >= maxRows {
+		if err := l.finishPart(); err != nil {
+			return err
+		}
+	}
+	if l.current == nil {
+		if err := l.startPart(); err != nil {
+			return err
+		}
+	}
+	if err := l.current.writer.Write(values); err != nil {
+		return err
+	}
+	l.current.rows++
+	return nil
+}
+
+func (l *exportLane) Flush() error {
+	if l.current == nil {
+		return nil
+	}
+	if err := l.current.writer.Flush(); err != nil {
+		return err
+	}
+	maxBytes := l.writer.jobData.MaxBytesPerPart
+	if maxBytes <= 0 {
+		return nil
+	}
+	// the size is measured as uploaded, so whatever the gzip stream holds
+	// back has to reach the upload first
+	if l.current.gzip != nil {
+		if err := l.current.gzip.Flush(); err != nil {
+			return err
+		}
+	}
+	if l.current.output.bytes >= maxBytes {
+		return l.finishPart()
+	}
+	return nil
+}
+
+// Close finishes the part the lane is writing.
+func (l *exportLane) Close() error {
+	if l.current == nil {
+		return nil
+	}
+	return l.finishPart()
+}
+
+func (w *partedExportWriter) Write(values []any) error {
+	return w.main.Write(values)
+}
+
+func (w *partedExportWriter) Flush() error {
+	return w.main.Flush()
+}
+
+// Close finishes the last part and the bundle. An export without rows still
+// gets one part, holding only the header.
+func (w *partedExportWriter) Close() error {
+	if err := w.main.Close(); err != nil {
+		return err
+	}
+	if len(w.Parts()) == 0 {
+		if err := w.main.startPart(); err != nil {
+			return err
+		}
+		if err := w.main.finishPart(); err != nil {
+			return err
+		}
+	}
+	if w.bundle != nil {
+		err := w.bundle.finish(w.bundleArchive.Close())
+		w.bundle, w.bundled = nil, err == nil
+		return err
+	}
+	return nil
+}
+
+// Abort stops the uploads in progress and deletes the parts already
+// uploaded, so that a failed export leaves nothing behind. After Close, it
+// deletes the parts and the bundle of an export that failed later on.
+func (w *partedExportWriter) Abort(err error) {
+	for _, lane := range w.lanes {
+		if lane.current != nil {
+			lane.current.upload.finish(err)
+			lane.current = nil
+		}
+	}
+	if w.bundle != nil {
+		w.bundle.finish(err)
+		w.bundle = nil
+	}
+	for _, part := range w.parts {
+		if deleteErr := connections.S3Connection.DeleteFile(context.Background(), w.jobData.FileS3Bucket, part.S3Key); deleteErr != nil {
+			log.Error().Err(deleteErr).Msgf("Failed to delete part %s of a failed export", part.S3Key)
+		}
+	}
+	w.parts = nil
+	if w.bundled {
+		bundleKey := w.layout.bundleKey()
+		if deleteErr := connections.S3Connection.DeleteFile(context.Background(), w.jobData.FileS3Bucket, bundleKey); deleteErr != nil {
+			log.Error().Err(deleteErr).Msgf("Failed to delete bundle %s of a failed export", bundleKey)
+		}
+		w.bundled = false
+	}
+}
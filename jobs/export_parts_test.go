@@ -0,0 +1,24 @@
+package jobs
+
+import (
+	"reflect"
+	"testing"
+	"vivek-ray/utilities"
+)
+
+func TestPartedExportWriterParts(t *testing.T) {
+	layout := exportLayout{prefix: "exports/job", split: true, extension: "csv"}
+	writer := &partedExportWriter{layout: layout}
+	// parts finish in any order when workers write them in parallel
+	for _, number := range []int{10000, 2, 9999, 1} {
+		writer.parts = append(writer.parts, utilities.ExportPart{Number: number, S3Key: layout.partKey(number)})
+	}
+	got := make([]string, 0, len(writer.parts))
+	for _, part := range writer.Parts() {
+		got = append(got, part.S3Key)
+	}
+	want := []string{"exports/job/part-0001.csv", "exports/job/part-0002.csv", "exports/job/part-9999.csv", "exports/job/part-10000.csv"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parts() = %v, want %v", got, want)
+	}
+}
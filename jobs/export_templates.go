@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"reflect"
+	"strings"
+	"time"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	"vivek-ray/utilities"
+)
+
+// templateColumn is an export template column with the positions of its
+// sources in the rows the export source writes.
+type templateColumn struct {
+	utilities.ExportTemplateColumn
+	inputs []int
+}
+
+// loadExportTemplate returns the columns of the service's template and the
+// source columns the export has to select for them.
+func loadExportTemplate(service, name string) ([]templateColumn, []string, error) {
+	template, err := models.ExportTemplatesRepository(connections.PgDBConnection.Client).GetByName(service, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if template == nil {
+		return nil, nil, constants.ExportTemplateNotFoundError
+	}
+	if err := utilities.ValidateExportTemplate(template.Columns); err != nil {
+		return nil, nil, err
+	}
+	sources := utilities.ExportTemplateSources(template.Columns)
+	positions := make(map[string]int, len(sources))
+	for i, source := range sources {
+		positions[source] = i
+	}
+	columns := make([]templateColumn, 0, len(template.Columns))
+	for _, column := range template.Columns {
+		inputs := make([]int, 0, len(column.Inputs()))
+		for _, input := range column.Inputs() {
+			inputs = append(inputs, positions[input])
+		}
+		columns = append(columns, templateColumn{ExportTemplateColumn: column, inputs: inputs})
+	}
+	return columns, sources, nil
+}
+
+// templateExportColumns heads and types the output columns. A column that
+// passes its source through keeps the source's type, computed ones are text.
+func templateExportColumns(columns []templateColumn, sources []exportColumn) []exportColumn {
+	output := make([]exportColumn, 0, len(columns))
+	for _, column := range columns {
+		columnType := reflect.TypeOf("")
+		if column.Typed() {
+			columnType = sources[column.inputs[0]].Type
+		}
+		output = append(output, exportColumn{Header: column.OutputHeader(), Type: columnType})
+	}
+	return output
+}
+
+// exportText is the text of a computed column value: times as RFC3339,
+// anything else as the csv format writes it. nil stays nil.
+func exportText(value any) any {
+	switch value := dereference(value).(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return value.Format(time.RFC3339)
+	default:
+		return utilities.CsvValue(value)
+	}
+}
+
+func emptyExportValue(value any) bool {
+	return value == nil || value == ""
+}
+
+func applyExportTransform(transform utilities.ExportTransform, values []any) []any {
+	switch transform.Type {
+	case constants.ExportTransformJoin:
+		separator := " "
+		if transform.Separator != nil {
+			separator = *transform.Separator
+		}
+		parts := make([]string, 0, len(values))
+		for _, value := range values {
+			if !emptyExportValue(value) {
+				parts = append(parts, value.(string))
+			}
+		}
+		if len(parts) == 0 {
+			return []any{nil}
+		}
+		return []any{strings.Join(parts, separator)}
+	case constants.ExportTransformCoalesce:
+		for _, value := range values {
+			if !emptyExportValue(value) {
+				return []any{value}
+			}
+		}
+		return []any{nil}
+	case constants.ExportTransformLowercase:
+		for i, value := range values {
+			if !emptyExportValue(value) {
+				values[i] = strings.ToLower(value.(string))
+			}
+		}
+	case constants.ExportTransformFormatPhone:
+		for i, value := range values {
+			if !emptyExportValue(value) {
+				phone := utilities.GetCleanedPhoneNumber(value.(string))
+				values[i] = utilities.InlineIf(phone != "+", phone, nil)
+			}
+		}
+	}
+	return values
+}
+
+// value computes the column from a row of source values.
+func (c templateColumn) value(row []any) any {
+	if c.Typed() {
+		return row[c.inputs[0]]
+	}
+	values := make([]any, len(c.inputs))
+	for i, input := range c.inputs {
+		values[i] = exportText(row[input])
+	}
+	for _, transform := range c.Transforms {
+		values = applyExportTransform(transform, values)
+	}
+	if emptyExportValue(values[0]) && c.Default != "" {
+		return c.Default
+	}
+	return values[0]
+}
+
+// templateExportWriter turns the rows of source values an export source
+// writes into the rows of the template's columns.
+type templateExportWriter struct {
+	exportWriter
+	columns []templateColumn
+}
+
+func (w *templateExportWriter) Write(values []any) error {
+	row := make([]any, len(w.columns))
+	for i, column := range w.columns {
+		row[i] = column.value(values)
+	}
+	return w.exportWriter.Write(row)
+}
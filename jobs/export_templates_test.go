@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"reflect"
+	"testing"
+	"vivek-ray/constants"
+	"vivek-ray/utilities"
+)
+
+func TestApplyExportTransform(t *testing.T) {
+	dash := "-"
+	tests := []struct {
+		name      string
+		transform utilities.ExportTransform
+		values    []any
+		want      []any
+	}{
+		{
+			name:      "join skips empty values with a space by default",
+			transform: utilities.ExportTransform{Type: constants.ExportTransformJoin},
+			values:    []any{"Vivek", nil, "", "Ray"},
+			want:      []any{"Vivek Ray"},
+		},
+		{
+			name:      "join with a separator",
+			transform: utilities.ExportTransform{Type: constants.ExportTransformJoin, Separator: &dash},
+			values:    []any{"Pune", "India"},
+			want:      []any{"Pune-India"},
+		},
+		{
+			name:      "join of empty values is empty",
+			transform: utilities.ExportTransform{Type: constants.ExportTransformJoin},
+			values:    []any{nil, ""},
+			want:      []any{nil},
+		},
+		{
+			name:      "coalesce takes the first value set",
+			transform: utilities.ExportTransform{Type: constants.ExportTransformCoalesce},
+			values:    []any{nil, "", "a@example.com", "b@example.com"},
+			want:      []any{"a@example.com"},
+		},
+		{
+			name:      "coalesce of empty values is empty",
+			transform: utilities.ExportTransform{Type: constants.ExportTransformCoalesce},
+			values:    []any{nil, ""},
+			want:      []any{nil},
+		},
+		{
+			name:      "lowercase keeps empty values",
+			transform: utilities.ExportTransform{Type: constants.ExportTransformLowercase},
+			values:    []any{"A@Example.COM", nil},
+			want:      []any{"a@example.com", nil},
+		},
+		{
+			name:      "format phone",
+			transform: utilities.ExportTransform{Type: constants.ExportTransformFormatPhone},
+			values:    []any{"(020) 555-0100", "+91 98765 43210", "+", nil},
+			want:      []any{"+0205550100", "+919876543210", nil, nil},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := applyExportTransform(test.transform, test.values); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("applyExportTransform() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
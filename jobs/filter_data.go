@@ -0,0 +1,171 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"vivek-ray/conf"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
+)
+
+type RebuildFilterDataReport struct {
+	Scanned  int64 `json:"scanned"`
+	Upserted int64 `json:"upserted"`
+	Pruned   int64 `json:"pruned"`
+}
+
+// RebuildFilterDataStruct recomputes the filters_data values of one filter
+// from the live Postgres rows of its service. Every value found is upserted,
+// which touches its last_seen_at, so the values to prune are those not seen
+// since the scan started; values imported meanwhile are kept.
+type RebuildFilterDataStruct struct {
+	service   string
+	filterKey string
+	batchSize int
+
+	pgContactRepository   models.PgContactSvcRepo
+	pgCompanyRepository   models.PgCompanySvcRepo
+	filtersDataRepository models.FiltersDataSvcRepo
+
+	report RebuildFilterDataReport
+}
+
+func NewRebuildFilterDataService(jobData utilities.RebuildFilterDataJobData) (*RebuildFilterDataStruct, error) {
+	if jobData.Service != constants.ContactsService && jobData.Service != constants.CompaniesService {
+		return nil, constants.InvalidServiceError
+	}
+	if jobData.FilterKey == "" {
+		return nil, constants.FilterKeyRequiredError
+	}
+	filter, err := models.FiltersRepository(connections.PgDBConnection.Client).GetByKey(jobData.Service, jobData.FilterKey)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, constants.FilterNotFoundError
+	}
+	if filter.DirectDerived {
+		return nil, constants.FilterDirectDerivedError
+	}
+	return &RebuildFilterDataStruct{
+		service:   jobData.Service,
+		filterKey: jobData.FilterKey,
+		batchSize: utilities.InlineIf(conf.JobConfig.BatchSize > 0, conf.JobConfig.BatchSize, constants.DefaultReindexBatchSize).(int),
+
+		pgContactRepository:   models.PgContactRepository(connections.PgDBConnection.Client),
+		pgCompanyRepository:   models.PgCompanyRepository(connections.PgDBConnection.Client),
+		filtersDataRepository: models.FiltersDataRepository(connections.PgDBConnection.Client),
+	}, nil
+}
+
+// nextRecords returns the next page of live records after afterId and the id
+// to continue from.
+func (r *RebuildFilterDataStruct) nextRecords(afterId uint64) ([]any, uint64, error) {
+	records := make([]any, 0, r.batchSize)
+	if r.service == constants.ContactsService {
+		contacts, err := r.pgContactRepository.ListAfterId(afterId, nil, r.batchSize)
+		if err != nil || len(contacts) == 0 {
+			return nil, afterId, err
+		}
+		for _, contact := range contacts {
+			records = append(records, contact)
+		}
+		return records, contacts[len(contacts)-1].ID, nil
+	}
+	companies, err := r.pgCompanyRepository.ListAfterId(afterId, nil, r.batchSize)
+	if err != nil || len(companies) == 0 {
+		return nil, afterId, err
+	}
+	for _, company := range companies {
+		records = append(records, company)
+	}
+	return records, companies[len(companies)-1].ID, nil
+}
+
+// collect upserts the distinct values of a page.
+func (r *RebuildFilterDataStruct) collect(records []any) error {
+	seen := make(map[string]struct{})
+	filtersData := make([]*models.ModelFilterData, 0)
+	for _, record := range records {
+		for _, value := range utilities.FilterValues(r.service, record, r.filterKey) {
+			filterUUID := utilities.GenerateUUID5(r.filterKey + r.service + value)
+			if _, ok := seen[filterUUID]; ok {
+				continue
+			}
+			seen[filterUUID] = struct{}{}
+			filtersData = append(filtersData, &models.ModelFilterData{
+				UUID:         filterUUID,
+				FilterKey:    r.filterKey,
+				Service:      r.service,
+				DisplayValue: value,
+				Value:        value,
+			})
+		}
+	}
+	r.report.Upserted += int64(len(filtersData))
+	return r.filtersDataRepository.BulkUpsert(filtersData)
+}
+
+// Run scans the live records and then prunes the values it did not find. It
+// stops between pages once ctx is cancelled, and never prunes after a partial
+// scan.
+func (r *RebuildFilterDataStruct) Run(ctx context.Context) error {
+	scanStartedAt, err := r.filtersDataRepository.ServerTime()
+	if err != nil {
+		return err
+	}
+	var afterId uint64
+	for {
+		if err := context.Cause(ctx); err != nil {
+			return err
+		}
+		records, nextId, err := r.nextRecords(afterId)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			break
+		}
+		if err := r.collect(records); err != nil {
+			return err
+		}
+		r.report.Scanned += int64(len(records))
+		afterId = nextId
+	}
+	log.Info().Msgf("Scanned %d %s records for filter %s, upserted %d values", r.report.Scanned, r.service, r.filterKey, r.report.Upserted)
+	r.report.Pruned, err = r.filtersDataRepository.PruneUnseen(r.service, r.filterKey, scanStartedAt)
+	return err
+}
+
+func ProcessRebuildFilterData(ctx context.Context, job *models.ModelJobs) error {
+	var jobData utilities.RebuildFilterDataJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return err
+	}
+	rebuildService, err := NewRebuildFilterDataService(jobData)
+	if err != nil {
+		return err
+	}
+	err = rebuildService.Run(ctx)
+	report := rebuildService.report
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			job.AddMessage(fmt.Sprintf(
+				"cancelled after scanning %d %s records: %d values for %s upserted, nothing pruned",
+				report.Scanned, jobData.Service, report.Upserted, jobData.FilterKey,
+			))
+			return cause
+		}
+		return err
+	}
+	job.AddMessage(fmt.Sprintf(
+		"scanned %d %s records: %d values for %s upserted, %d stale values pruned",
+		report.Scanned, jobData.Service, report.Upserted, jobData.FilterKey, report.Pruned,
+	))
+	return nil
+}
@@ -2,23 +2,32 @@ package jobs
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 	"vivek-ray/conf"
 	"vivek-ray/connections"
 	"vivek-ray/constants"
 	"vivek-ray/models"
+	"vivek-ray/utilities"
 
 	"github.com/rs/zerolog/log"
 )
 
 type JobStruct struct {
 	JobsRepository models.JobsSvcRepo
+	// WorkerID identifies this runner in the leases of the jobs it claims.
+	WorkerID string
+	Lease    time.Duration
 }
 
 func NewJobService() JobSvc {
 	return &JobStruct{
 		JobsRepository: models.JobsRepository(connections.PgDBConnection.Client),
+		WorkerID:       workerID(),
+		Lease:          time.Duration(utilities.InlineIf(conf.JobConfig.LeaseSeconds > 0, conf.JobConfig.LeaseSeconds, constants.DefaultJobLeaseSeconds).(int)) * time.Second,
 	}
 }
 
@@ -31,55 +40,117 @@ type JobSvc interface {
 
 func (j *JobStruct) JobConsumer(wg *sync.WaitGroup, ctx context.Context, jobsChannel chan models.ModelJobs) {
 	defer wg.Done()
-	serverTime := time.Now()
 	for job := range jobsChannel {
+		// jobs cancelled while they waited in the channel never start
+		if requested, err := j.JobsRepository.CancelRequested(job.UUID); err != nil {
+			log.Error().Err(err).Msgf("Failed to check job %s for cancellation", job.UUID)
+		} else if requested {
+			job.Status = constants.CancelledJobStatus
+			job.MarkCancelled(time.Now())
+			j.release(&job)
+			continue
+		}
+
 		job.Status = constants.ProcessingJobStatus
-		if err := j.JobsRepository.BulkUpsert([]*models.ModelJobs{&job}); err != nil {
-			log.Error().Err(err).Msg("Failed to bulk upsert jobs")
+		job.Attempts++
+		if !j.save(&job) {
 			continue
 		}
 
+		// shutting down lets the running job finish, so only a cancel
+		// request stops it
+		jobCtx, stopWatching := j.watchCancellation(context.WithoutCancel(ctx), job.UUID)
 		var jobError error
 		switch job.JobType {
 		case constants.InsertCsvFile:
-			if err := ProcessInsertCsvFile(&job); err != nil {
+			if err := ProcessInsertCsvFile(jobCtx, &job); err != nil {
 				jobError = err
 			}
 		case constants.ExportCsvFile:
-			if err := ProcessExportCsvFile(&job); err != nil {
+			if err := ProcessExportCsvFile(jobCtx, &job); err != nil {
+				jobError = err
+			}
+		case constants.Reconcile:
+			if err := ProcessReconcile(jobCtx, &job); err != nil {
+				jobError = err
+			}
+		case constants.RollbackImport:
+			if err := ProcessRollbackImport(jobCtx, &job); err != nil {
+				jobError = err
+			}
+		case constants.PurgeDeleted:
+			if err := ProcessPurgeDeleted(jobCtx, &job); err != nil {
+				jobError = err
+			}
+		case constants.UpdateByVQL, constants.DeleteByVQL:
+			if err := ProcessVQLMutation(jobCtx, &job); err != nil {
+				jobError = err
+			}
+		case constants.RebuildFilterData:
+			if err := ProcessRebuildFilterData(jobCtx, &job); err != nil {
+				jobError = err
+			}
+		case constants.PurgeExports:
+			if err := ProcessPurgeExports(jobCtx, &job); err != nil {
 				jobError = err
 			}
 		default:
 			jobError = constants.InvalidJobTypeError(job.JobType)
 		}
 
-		if jobError != nil {
-			retryAfter := serverTime.Add(time.Duration(job.RetryInterval) * time.Second)
-			job.Status = constants.FailedJobStatus
-			job.AddRuntimeError(jobError.Error())
-			job.RunAfter = &retryAfter
+		cancelled := errors.Is(context.Cause(jobCtx), constants.JobCancelledError)
+		stopWatching()
+
+		if jobError != nil && cancelled {
+			job.Status = constants.CancelledJobStatus
+			job.MarkCancelled(time.Now())
+		} else if jobError != nil {
+			job.AddRuntimeError(fmt.Sprintf("attempt %d: %s", job.Attempts, jobError))
+			if job.Attempts > job.RetryCount {
+				log.Error().Err(jobError).Msgf("Job %s is dead after %d attempts", job.UUID, job.Attempts)
+				job.Status = constants.DeadJobStatus
+			} else {
+				retryAfter := time.Now().Add(retryBackoff(job.RetryInterval, job.Attempts))
+				job.Status = constants.FailedJobStatus
+				job.RunAfter = &retryAfter
+			}
 		} else {
 			job.Status = constants.CompletedJobStatus
 		}
 
-		if err := j.JobsRepository.BulkUpsert([]*models.ModelJobs{&job}); err != nil {
-			log.Error().Err(err).Msg("Failed to bulk upsert jobs")
-		}
+		j.release(&job)
+	}
+}
+
+// save writes the job while this worker holds its lease, and reports whether
+// it still does.
+func (j *JobStruct) save(job *models.ModelJobs) bool {
+	saved, err := j.JobsRepository.SaveLeased(job, j.WorkerID, j.Lease)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to save job %s", job.UUID)
+		return false
+	}
+	if !saved {
+		log.Warn().Msgf("Job %s is no longer leased to worker %s; dropping its update", job.UUID, j.WorkerID)
 	}
+	return saved
+}
+
+// release saves the job and gives up its lease.
+func (j *JobStruct) release(job *models.ModelJobs) {
+	job.WorkerID = ""
+	job.LeaseExpiresAt = nil
+	j.save(job)
 }
 
 func (j *JobStruct) DequeueJobs(jobsChannel chan models.ModelJobs, status string) {
-	jobs := make([]*models.ModelJobs, 0, len(jobsChannel))
+	dequeued := 0
 	for job := range jobsChannel {
 		job.Status = status
-		jobs = append(jobs, &job)
+		j.release(&job)
+		dequeued++
 	}
-	if len(jobs) > 0 {
-		if err := j.JobsRepository.BulkUpsert(jobs); err != nil {
-			log.Error().Err(err).Msg("Failed to bulk upsert jobs")
-		}
-	}
-	log.Info().Msgf("Dequeued %d jobs with status: %s", len(jobs), status)
+	log.Info().Msgf("Dequeued %d jobs with status: %s", dequeued, status)
 }
 
 func (j *JobStruct) FirstTimeJob(ctx context.Context, args []string) {
@@ -87,12 +158,19 @@ func (j *JobStruct) FirstTimeJob(ctx context.Context, args []string) {
 	jobsChannel := make(chan models.ModelJobs, 1000)
 
 	ticker := time.NewTicker(time.Duration(conf.JobConfig.TickerInterval) * time.Second)
+	stopLeases := j.keepLeases()
 	defer func() {
 		ticker.Stop()
 		wg.Wait()
+		stopLeases()
 		log.Info().Msg("All workers stopped")
 	}()
 
+	// the retention purge reschedules itself; this starts the chain
+	if err := schedulePurgeDeleted(json.RawMessage(`{}`), time.Now(), ""); err != nil {
+		log.Error().Err(err).Msg("Failed to schedule the purge_deleted job")
+	}
+
 	for i := 0; i < conf.JobConfig.ParallelJobs; i++ {
 		wg.Add(1)
 		go j.JobConsumer(&wg, ctx, jobsChannel)
@@ -110,27 +188,19 @@ func (j *JobStruct) FirstTimeJob(ctx context.Context, args []string) {
 			if len(jobsChannel) >= inQueSize {
 				continue
 			}
-			jobs, err := j.JobsRepository.ListByFilters(models.JobsFilters{
+			jobs, err := j.JobsRepository.Claim(models.JobsFilters{
 				Status: []string{constants.OpenJobStatus},
+				Due:    true,
 				Limit:  1,
-			})
-
+			}, constants.InQueueJobStatus, j.WorkerID, j.Lease)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to list jobs")
+				log.Error().Err(err).Msg("Failed to claim jobs")
 				continue
 			}
 			if len(jobs) == 0 {
 				log.Info().Msg("No jobs found to insert file")
 				continue
 			}
-			for _, job := range jobs {
-				log.Info().Msgf("pushing job to in queue: %s", job.UUID)
-				job.Status = constants.InQueueJobStatus
-			}
-			if err = j.JobsRepository.BulkUpsert(jobs); err != nil {
-				log.Error().Err(err).Msg("Failed to bulk upsert jobs")
-				continue
-			}
 
 			for _, job := range jobs {
 				jobsChannel <- *job
@@ -149,9 +219,11 @@ func (j *JobStruct) RetryJobs(ctx context.Context, args []string) {
 	go j.JobConsumer(&wg, ctx, jobsChannel)
 
 	ticker := time.NewTicker(time.Duration(conf.JobConfig.TickerInterval) * time.Minute)
+	stopLeases := j.keepLeases()
 	defer func() {
 		ticker.Stop()
 		wg.Wait()
+		stopLeases()
 		log.Info().Msg("All workers stopped")
 	}()
 
@@ -163,13 +235,14 @@ func (j *JobStruct) RetryJobs(ctx context.Context, args []string) {
 			j.DequeueJobs(jobsChannel, constants.FailedJobStatus)
 			return
 		case <-ticker.C:
-			jobs, err := j.JobsRepository.ListByFilters(models.JobsFilters{
+			jobs, err := j.JobsRepository.Claim(models.JobsFilters{
 				Retrying: true,
 				Status:   []string{constants.FailedJobStatus},
+				Due:      true,
 				Limit:    1,
-			})
+			}, constants.RetryInQueuedJobStatus, j.WorkerID, j.Lease)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to list jobs")
+				log.Error().Err(err).Msg("Failed to claim jobs")
 				continue
 			}
 			if len(jobs) == 0 {
@@ -177,14 +250,6 @@ func (j *JobStruct) RetryJobs(ctx context.Context, args []string) {
 				continue
 			}
 
-			for _, job := range jobs {
-				job.Status = constants.RetryInQueuedJobStatus
-			}
-			if err = j.JobsRepository.BulkUpsert(jobs); err != nil {
-				log.Error().Err(err).Msg("Failed to bulk upsert jobs")
-				continue
-			}
-
 			for _, job := range jobs {
 				jobsChannel <- *job
 				log.Info().Msgf("Job pushed to channel: %s", job.UUID)
@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+)
+
+// leaseStore keeps the lease of one job the way the jobs table does: claims
+// and heartbeats set it from the store's clock, and the job struct only ever
+// sees a copy.
+type leaseStore struct {
+	models.JobsSvcRepo
+	mu             sync.Mutex
+	workerID       string
+	status         string
+	leaseExpiresAt *time.Time
+	reaped         int
+	onSave         func(status string)
+}
+
+func (s *leaseStore) claim(job *models.ModelJobs, workerID string, lease time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt := time.Now().Add(lease)
+	s.workerID, s.status, s.leaseExpiresAt = workerID, constants.InQueueJobStatus, &expiresAt
+	job.WorkerID, job.Status, job.LeaseExpiresAt = workerID, constants.InQueueJobStatus, &expiresAt
+}
+
+func (s *leaseStore) heartbeat(lease time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt := time.Now().Add(lease)
+	s.leaseExpiresAt = &expiresAt
+}
+
+// reapExpired returns the job to the queue when its lease ran out.
+func (s *leaseStore) reapExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.Contains(constants.LeasedJobStatuses, s.status) && s.leaseExpiresAt != nil && s.leaseExpiresAt.Before(time.Now()) {
+		s.reaped++
+		s.workerID, s.status, s.leaseExpiresAt = "", constants.FailedJobStatus, nil
+	}
+}
+
+func (s *leaseStore) CancelRequested(string) (bool, error) {
+	return false, nil
+}
+
+func (s *leaseStore) SaveLeased(job *models.ModelJobs, workerID string, lease time.Duration) (bool, error) {
+	s.mu.Lock()
+	if s.workerID != workerID {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.workerID, s.status = job.WorkerID, job.Status
+	s.leaseExpiresAt = nil
+	if job.WorkerID != "" {
+		expiresAt := time.Now().Add(lease)
+		s.leaseExpiresAt = &expiresAt
+	}
+	job.LeaseExpiresAt = s.leaseExpiresAt
+	s.mu.Unlock()
+	if s.onSave != nil {
+		s.onSave(job.Status)
+	}
+	return true, nil
+}
+
+func TestJobConsumerKeepsTheLeaseOfAQueuedJob(t *testing.T) {
+	const lease = 50 * time.Millisecond
+	store := &leaseStore{}
+	// the reaper runs right as the job starts
+	store.onSave = func(status string) {
+		if status == constants.ProcessingJobStatus {
+			store.reapExpired()
+		}
+	}
+	runner := &JobStruct{JobsRepository: store, WorkerID: "worker-1", Lease: lease}
+
+	job := models.ModelJobs{UUID: "a", JobType: "unknown", RetryCount: 3}
+	store.claim(&job, runner.WorkerID, lease)
+	// the job waits in the channel longer than the lease it was claimed
+	// with, while the heartbeat keeps renewing it
+	time.Sleep(2 * lease)
+	store.heartbeat(lease)
+
+	jobsChannel := make(chan models.ModelJobs, 1)
+	jobsChannel <- job
+	close(jobsChannel)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	runner.JobConsumer(&wg, context.Background(), jobsChannel)
+
+	if store.reaped != 0 {
+		t.Fatalf("the job was reaped %d times while it was running", store.reaped)
+	}
+	if store.status != constants.FailedJobStatus || store.workerID != "" || store.leaseExpiresAt != nil {
+		t.Errorf("job ended %q held by %q until %v, want failed and released", store.status, store.workerID, store.leaseExpiresAt)
+	}
+}
@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"vivek-ray/conf"
+
+	"github.com/rs/zerolog/log"
+)
+
+// workerID names this runner in job leases: JOB_WORKER_ID when set, which
+// must then differ between runners, or the host and process id.
+func workerID() string {
+	if conf.JobConfig.WorkerID != "" {
+		return conf.JobConfig.WorkerID
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// keepLeases renews the leases of the jobs this worker holds every third of a
+// lease, and returns the jobs of workers whose leases ran out to the queue.
+// It runs until the returned func is called, after the workers have stopped,
+// so that jobs finishing during shutdown keep their leases.
+func (j *JobStruct) keepLeases() func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(j.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := j.JobsRepository.Heartbeat(j.WorkerID, j.Lease); err != nil {
+					log.Error().Err(err).Msgf("Failed to renew the job leases of worker %s", j.WorkerID)
+				}
+				reaped, err := j.JobsRepository.ReapExpired(retryBackoff)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to reap jobs with expired leases")
+				} else if reaped > 0 {
+					log.Warn().Msgf("Returned %d jobs with expired leases to the queue", reaped)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
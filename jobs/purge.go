@@ -0,0 +1,231 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"vivek-ray/conf"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	"vivek-ray/utilities"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+func retentionDays(jobData utilities.PurgeDeletedJobData) int {
+	switch {
+	case jobData.RetentionDays > 0:
+		return jobData.RetentionDays
+	case conf.JobConfig.DeletedRetentionDays > 0:
+		return conf.JobConfig.DeletedRetentionDays
+	}
+	return constants.DefaultDeletedRetentionDays
+}
+
+// purgeInBatches calls purge until a batch comes back short, or ctx is
+// cancelled, and returns the total number of rows removed.
+func purgeInBatches(ctx context.Context, purge func(before time.Time, limit int) (int64, error), before time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		if err := context.Cause(ctx); err != nil {
+			return total, err
+		}
+		purged, err := purge(before, batchSize)
+		total += purged
+		if err != nil {
+			return total, err
+		}
+		if purged < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// PurgeDeleted hard deletes contacts and companies that were soft deleted
+// more than days ago, or than the retention period when days is not
+// positive. Their Elasticsearch documents were already removed when they were
+// soft deleted, and their history stays in record_changes. It stops between
+// batches once ctx is cancelled.
+func PurgeDeleted(ctx context.Context, days int) (contacts int64, companies int64, err error) {
+	days = retentionDays(utilities.PurgeDeletedJobData{RetentionDays: days})
+	before := time.Now().AddDate(0, 0, -days)
+	batchSize := utilities.InlineIf(conf.JobConfig.BatchSize > 0, conf.JobConfig.BatchSize, constants.DefaultReindexBatchSize).(int)
+
+	contacts, err = purgeInBatches(ctx, models.PgContactRepository(connections.PgDBConnection.Client).PurgeDeleted, before, batchSize)
+	if err != nil {
+		return contacts, 0, err
+	}
+	companies, err = purgeInBatches(ctx, models.PgCompanyRepository(connections.PgDBConnection.Client).PurgeDeleted, before, batchSize)
+	if err != nil {
+		return contacts, companies, err
+	}
+	log.Info().Msgf("Purged %d contacts and %d companies deleted before %s", contacts, companies, before.Format(time.RFC3339))
+	return contacts, companies, nil
+}
+
+// ProcessPurgeDeleted runs PurgeDeleted for the retention period and queues
+// the next run, with the same job data, PurgeDeletedInterval later. A
+// cancelled run still queues the next one, so the schedule keeps going.
+func ProcessPurgeDeleted(ctx context.Context, job *models.ModelJobs) error {
+	var jobData utilities.PurgeDeletedJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return err
+	}
+	days := retentionDays(jobData)
+	contacts, companies, err := PurgeDeleted(ctx, days)
+	cause := context.Cause(ctx)
+	if err != nil && cause == nil {
+		return err
+	}
+
+	if err := schedulePurgeDeleted(job.Data, time.Now().Add(constants.PurgeDeletedInterval), job.UUID); err != nil {
+		log.Error().Err(err).Msg("Failed to schedule the next purge_deleted job")
+	}
+	if err != nil {
+		job.AddMessage(fmt.Sprintf("cancelled after purging %d contacts and %d companies deleted more than %d days ago", contacts, companies, days))
+		return cause
+	}
+	job.AddMessage(fmt.Sprintf("purged %d contacts and %d companies deleted more than %d days ago", contacts, companies, days))
+	return nil
+}
+
+// schedulePurgeDeleted queues a purge_deleted job to run at runAfter, unless
+// a job other than except is already waiting to run or running.
+func schedulePurgeDeleted(data json.RawMessage, runAfter time.Time, except string) error {
+	jobsRepository := models.JobsRepository(connections.PgDBConnection.Client)
+	pending, err := jobsRepository.ListByFilters(models.JobsFilters{
+		JobType: constants.PurgeDeleted,
+		Status: []string{constants.OpenJobStatus, constants.InQueueJobStatus, constants.ProcessingJobStatus,
+			constants.FailedJobStatus, constants.RetryInQueuedJobStatus},
+		Limit: 2,
+	})
+	if err != nil {
+		return err
+	}
+	for _, job := range pending {
+		if job.UUID != except {
+			return nil
+		}
+	}
+	return jobsRepository.BulkUpsert([]*models.ModelJobs{{
+		UUID:     uuid.New().String(),
+		JobType:  constants.PurgeDeleted,
+		Data:     data,
+		RunAfter: &runAfter,
+	}})
+}
+
+func exportRetentionDays(jobData utilities.PurgeExportsJobData) int {
+	switch {
+	case jobData.RetentionDays > 0:
+		return jobData.RetentionDays
+	case conf.JobConfig.ExportRetentionDays > 0:
+		return conf.JobConfig.ExportRetentionDays
+	}
+	return constants.DefaultExportRetentionDays
+}
+
+// scheduleExportPurge queues the purge_exports job that removes the files of
+// a completed export once the retention period is over.
+func scheduleExportPurge(exportJob string) error {
+	data, err := json.Marshal(utilities.PurgeExportsJobData{JobUUID: exportJob})
+	if err != nil {
+		return err
+	}
+	runAfter := time.Now().AddDate(0, 0, exportRetentionDays(utilities.PurgeExportsJobData{}))
+	return models.JobsRepository(connections.PgDBConnection.Client).BulkUpsert([]*models.ModelJobs{{
+		UUID:     uuid.New().String(),
+		JobType:  constants.PurgeExports,
+		Data:     data,
+		RunAfter: &runAfter,
+	}})
+}
+
+// expireExport deletes the files of a completed export and records when, so
+// that downloads of it fail with ExportExpiredError.
+func expireExport(job *models.ModelJobs) error {
+	var jobData utilities.ExportFileJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return err
+	}
+	bucket := utilities.InlineIf(jobData.FileS3Bucket != "", jobData.FileS3Bucket, conf.S3StorageConfig.S3Bucket).(string)
+
+	var keys []string
+	response := job.Response()
+	switch {
+	case response.ExportReport != nil:
+		keys = response.ExportReport.Keys()
+	case response.S3Key != "":
+		keys = []string{response.S3Key}
+	}
+	for _, key := range keys {
+		if err := connections.S3Connection.DeleteFile(context.Background(), bucket, key); err != nil {
+			return err
+		}
+	}
+	job.MarkExpired(time.Now())
+	log.Info().Msgf("Removed %d files of export %s", len(keys), job.UUID)
+	return models.JobsRepository(connections.PgDBConnection.Client).BulkUpsert([]*models.ModelJobs{job})
+}
+
+// ProcessPurgeExports removes the files of exports past the retention period.
+// Every export queues one for itself when it completes; one without job_uuid
+// sweeps all exports completed more than retention_days ago, and stops between
+// batches once ctx is cancelled.
+func ProcessPurgeExports(ctx context.Context, job *models.ModelJobs) error {
+	var jobData utilities.PurgeExportsJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return err
+	}
+	jobsRepository := models.JobsRepository(connections.PgDBConnection.Client)
+
+	if jobData.JobUUID != "" {
+		exports, err := jobsRepository.ListByFilters(models.JobsFilters{
+			Uuids:   []string{jobData.JobUUID},
+			JobType: constants.ExportCsvFile,
+			Status:  []string{constants.CompletedJobStatus},
+		})
+		if err != nil {
+			return err
+		}
+		if len(exports) == 0 || exports[0].Response().ExpiredAt != nil {
+			job.AddMessage(fmt.Sprintf("export %s has no files to remove", jobData.JobUUID))
+			return nil
+		}
+		if err := expireExport(exports[0]); err != nil {
+			return err
+		}
+		job.AddMessage(fmt.Sprintf("removed the files of export %s", jobData.JobUUID))
+		return nil
+	}
+
+	days := exportRetentionDays(jobData)
+	before := time.Now().AddDate(0, 0, -days)
+	batchSize := utilities.InlineIf(conf.JobConfig.BatchSize > 0, conf.JobConfig.BatchSize, constants.DefaultReindexBatchSize).(int)
+	var expired int64
+	for {
+		if cause := context.Cause(ctx); cause != nil {
+			job.AddMessage(fmt.Sprintf("cancelled after removing the files of %d exports completed more than %d days ago", expired, days))
+			return cause
+		}
+		exports, err := jobsRepository.ListExportsToExpire(before, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, export := range exports {
+			if err := expireExport(export); err != nil {
+				return err
+			}
+			expired++
+		}
+		if len(exports) < batchSize {
+			break
+		}
+	}
+
+	job.AddMessage(fmt.Sprintf("removed the files of %d exports completed more than %d days ago", expired, days))
+	return nil
+}
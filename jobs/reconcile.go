@@ -0,0 +1,402 @@
+package jobs
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"vivek-ray/conf"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	driftMissingInElastic = "missing_in_elastic"
+	driftStaleInElastic   = "stale_in_elastic"
+	driftOrphanInElastic  = "orphan_in_elastic"
+	// driftUndatedInElastic is a document without updated_at, whose age
+	// cannot be compared with its row; a reindex gives it one.
+	driftUndatedInElastic = "undated_in_elastic"
+)
+
+var reconcileReportHeaders = []string{"uuid", "drift", "pg_updated_at", "es_updated_at", "repaired"}
+
+type ReconcileReport struct {
+	PgChecked int64 `json:"pg_checked"`
+	EsChecked int64 `json:"es_checked"`
+	Missing   int64 `json:"missing"`
+	Stale     int64 `json:"stale"`
+	Orphans   int64 `json:"orphans"`
+	Undated   int64 `json:"undated"`
+	Reindexed int64 `json:"reindexed"`
+	Deleted   int64 `json:"deleted"`
+}
+
+type ReconcileStruct struct {
+	*ReindexStruct
+	repair    bool
+	csvWriter *csv.Writer
+	report    ReconcileReport
+}
+
+func NewReconcileService(jobData utilities.ReconcileJobData) (*ReconcileStruct, error) {
+	reindexService, err := NewReindexService(jobData.Service, jobData.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconcileStruct{
+		ReindexStruct: reindexService,
+		repair:        jobData.Repair,
+	}, nil
+}
+
+func formatDriftTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (r *ReconcileStruct) writeDrift(uuid, drift string, pgUpdatedAt, esUpdatedAt *time.Time) error {
+	return r.csvWriter.Write([]string{
+		uuid,
+		drift,
+		formatDriftTime(pgUpdatedAt),
+		formatDriftTime(esUpdatedAt),
+		fmt.Sprintf("%t", r.repair),
+	})
+}
+
+// isStale reports whether the indexed copy lags behind Postgres. A small
+// tolerance absorbs the clock gap between building the document and the
+// database default stamping updated_at.
+func isStale(pgUpdatedAt *time.Time, esUpdatedAt time.Time) bool {
+	if pgUpdatedAt == nil {
+		return false
+	}
+	return esUpdatedAt.Add(constants.ReconcileStaleTolerance).Before(*pgUpdatedAt)
+}
+
+// elasticUpdatedAt looks up the given uuids in the alias and returns the
+// updated_at of every document that exists.
+func (r *ReconcileStruct) elasticUpdatedAt(uuids []string) (map[string]time.Time, error) {
+	query := map[string]any{
+		"_source": []string{"uuid", "updated_at"},
+		"size":    len(uuids),
+		"query":   map[string]any{"ids": map[string]any{"values": uuids}},
+	}
+	result := make(map[string]time.Time, len(uuids))
+	switch r.service {
+	case constants.ContactsService:
+		hits, err := r.esContactRepository.ListByQueryMap(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range hits {
+			result[hit.Contact.UUID] = hit.Contact.UpdatedAt
+		}
+	case constants.CompaniesService:
+		hits, err := r.esCompanyRepository.ListByQueryMap(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range hits {
+			result[hit.Company.UUID] = hit.Company.UpdatedAt
+		}
+	}
+	return result, nil
+}
+
+// checkPostgresChunk compares one keyset page of Postgres rows against the
+// index, reporting documents that are missing, undated or older than their
+// row. With repair, all of them are reindexed.
+func (r *ReconcileStruct) checkPostgresChunk(afterId uint64) (uint64, int, error) {
+	switch r.service {
+	case constants.ContactsService:
+		contacts, err := r.pgContactRepository.ListAfterId(afterId, nil, r.batchSize)
+		if err != nil || len(contacts) == 0 {
+			return afterId, 0, err
+		}
+		uuids := make([]string, 0, len(contacts))
+		for _, contact := range contacts {
+			uuids = append(uuids, contact.UUID)
+		}
+		esUpdatedAt, err := r.elasticUpdatedAt(uuids)
+		if err != nil {
+			return afterId, 0, err
+		}
+
+		drifted := make([]*models.PgContact, 0)
+		for _, contact := range contacts {
+			updatedAt, ok := esUpdatedAt[contact.UUID]
+			switch {
+			case !ok:
+				r.report.Missing++
+				err = r.writeDrift(contact.UUID, driftMissingInElastic, contact.UpdatedAt, nil)
+			case updatedAt.IsZero():
+				r.report.Undated++
+				err = r.writeDrift(contact.UUID, driftUndatedInElastic, contact.UpdatedAt, nil)
+			case isStale(contact.UpdatedAt, updatedAt):
+				r.report.Stale++
+				err = r.writeDrift(contact.UUID, driftStaleInElastic, contact.UpdatedAt, &updatedAt)
+			default:
+				continue
+			}
+			if err != nil {
+				return afterId, 0, err
+			}
+			drifted = append(drifted, contact)
+		}
+		if r.repair && len(drifted) > 0 {
+			if err := r.indexContacts(constants.ContactIndex, drifted); err != nil {
+				return afterId, 0, err
+			}
+			r.report.Reindexed += int64(len(drifted))
+		}
+		return contacts[len(contacts)-1].ID, len(contacts), nil
+
+	case constants.CompaniesService:
+		companies, err := r.pgCompanyRepository.ListAfterId(afterId, nil, r.batchSize)
+		if err != nil || len(companies) == 0 {
+			return afterId, 0, err
+		}
+		uuids := make([]string, 0, len(companies))
+		for _, company := range companies {
+			uuids = append(uuids, company.UUID)
+		}
+		esUpdatedAt, err := r.elasticUpdatedAt(uuids)
+		if err != nil {
+			return afterId, 0, err
+		}
+
+		drifted := make([]*models.PgCompany, 0)
+		for _, company := range companies {
+			updatedAt, ok := esUpdatedAt[company.UUID]
+			switch {
+			case !ok:
+				r.report.Missing++
+				err = r.writeDrift(company.UUID, driftMissingInElastic, company.UpdatedAt, nil)
+			case updatedAt.IsZero():
+				r.report.Undated++
+				err = r.writeDrift(company.UUID, driftUndatedInElastic, company.UpdatedAt, nil)
+			case isStale(company.UpdatedAt, updatedAt):
+				r.report.Stale++
+				err = r.writeDrift(company.UUID, driftStaleInElastic, company.UpdatedAt, &updatedAt)
+			default:
+				continue
+			}
+			if err != nil {
+				return afterId, 0, err
+			}
+			drifted = append(drifted, company)
+		}
+		if r.repair && len(drifted) > 0 {
+			if err := r.indexCompanies(constants.CompanyIndex, drifted); err != nil {
+				return afterId, 0, err
+			}
+			r.report.Reindexed += int64(len(drifted))
+		}
+		return companies[len(companies)-1].ID, len(companies), nil
+	}
+	return afterId, 0, constants.InvalidServiceError
+}
+
+// checkElasticChunk walks the index in uuid order and reports documents whose
+// row no longer exists (or is soft deleted) in Postgres.
+func (r *ReconcileStruct) checkElasticChunk(cursor []string) ([]string, int, error) {
+	query := map[string]any{
+		"_source":          []string{"uuid", "updated_at"},
+		"size":             r.batchSize,
+		"sort":             []map[string]any{{"uuid": map[string]any{"order": "asc"}}},
+		"track_total_hits": false,
+		"query":            map[string]any{"match_all": map[string]any{}},
+	}
+	if len(cursor) > 0 {
+		query["search_after"] = cursor
+	}
+
+	uuids, esUpdatedAt := make([]string, 0, r.batchSize), make(map[string]time.Time)
+	live := make(map[string]struct{})
+	switch r.service {
+	case constants.ContactsService:
+		hits, err := r.esContactRepository.ListByQueryMap(query)
+		if err != nil || len(hits) == 0 {
+			return cursor, 0, err
+		}
+		for _, hit := range hits {
+			uuids = append(uuids, hit.Contact.UUID)
+			esUpdatedAt[hit.Contact.UUID] = hit.Contact.UpdatedAt
+		}
+		cursor = hits[len(hits)-1].Cursor
+
+		contacts, err := r.pgContactRepository.ListByFilters(models.PgContactFilters{
+			Uuids:         uuids,
+			SelectColumns: []string{"uuid", "deleted_at"},
+		})
+		if err != nil {
+			return cursor, 0, err
+		}
+		for _, contact := range contacts {
+			if contact.DeletedAt == nil {
+				live[contact.UUID] = struct{}{}
+			}
+		}
+	case constants.CompaniesService:
+		hits, err := r.esCompanyRepository.ListByQueryMap(query)
+		if err != nil || len(hits) == 0 {
+			return cursor, 0, err
+		}
+		for _, hit := range hits {
+			uuids = append(uuids, hit.Company.UUID)
+			esUpdatedAt[hit.Company.UUID] = hit.Company.UpdatedAt
+		}
+		cursor = hits[len(hits)-1].Cursor
+
+		companies, err := r.pgCompanyRepository.ListByFilters(models.PgCompanyFilters{
+			Uuids:         uuids,
+			SelectColumns: []string{"uuid", "deleted_at"},
+		})
+		if err != nil {
+			return cursor, 0, err
+		}
+		for _, company := range companies {
+			if company.DeletedAt == nil {
+				live[company.UUID] = struct{}{}
+			}
+		}
+	default:
+		return cursor, 0, constants.InvalidServiceError
+	}
+
+	orphans := make([]string, 0)
+	for _, uuid := range uuids {
+		if _, ok := live[uuid]; ok {
+			continue
+		}
+		updatedAt := esUpdatedAt[uuid]
+		if err := r.writeDrift(uuid, driftOrphanInElastic, nil, &updatedAt); err != nil {
+			return cursor, 0, err
+		}
+		orphans = append(orphans, uuid)
+	}
+	r.report.Orphans += int64(len(orphans))
+
+	if r.repair && len(orphans) > 0 {
+		var deleted int64
+		var err error
+		if r.service == constants.ContactsService {
+			deleted, err = r.esContactRepository.BulkDelete(orphans)
+		} else {
+			deleted, err = r.esCompanyRepository.BulkDelete(orphans)
+		}
+		if err != nil {
+			return cursor, 0, err
+		}
+		r.report.Deleted += deleted
+	}
+	return cursor, len(uuids), nil
+}
+
+// Run writes the drift report to writer, checking Postgres against the index
+// and then the index against Postgres. It stops between chunks once ctx is
+// cancelled.
+func (r *ReconcileStruct) Run(ctx context.Context, writer io.Writer) error {
+	r.csvWriter = csv.NewWriter(writer)
+	defer r.csvWriter.Flush()
+	if err := r.csvWriter.Write(reconcileReportHeaders); err != nil {
+		return err
+	}
+
+	var afterId uint64
+	for {
+		if err := context.Cause(ctx); err != nil {
+			return err
+		}
+		lastId, checked, err := r.checkPostgresChunk(afterId)
+		if err != nil {
+			return err
+		}
+		if checked == 0 {
+			break
+		}
+		afterId = lastId
+		r.report.PgChecked += int64(checked)
+		r.csvWriter.Flush()
+	}
+	log.Info().Msgf("Reconcile %s: checked %d postgres rows", r.service, r.report.PgChecked)
+
+	var cursor []string
+	for {
+		if err := context.Cause(ctx); err != nil {
+			return err
+		}
+		nextCursor, checked, err := r.checkElasticChunk(cursor)
+		if err != nil {
+			return err
+		}
+		if checked == 0 {
+			break
+		}
+		cursor = nextCursor
+		r.report.EsChecked += int64(checked)
+		r.csvWriter.Flush()
+	}
+	log.Info().Msgf("Reconcile %s: checked %d elasticsearch documents", r.service, r.report.EsChecked)
+	return r.csvWriter.Error()
+}
+
+// ProcessReconcile streams the drift report of the job's service to S3. Docs
+// without updated_at are reported as undated rather than stale; a repair run
+// reindexes them, which gives them one.
+func ProcessReconcile(ctx context.Context, job *models.ModelJobs) error {
+	var jobData utilities.ReconcileJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return err
+	}
+	if jobData.FileS3Bucket == "" {
+		jobData.FileS3Bucket = conf.S3StorageConfig.S3Bucket
+	}
+	reconcileService, err := NewReconcileService(jobData)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	reconciled := make(chan struct{})
+	go func() {
+		defer close(reconciled)
+		writer.CloseWithError(reconcileService.Run(ctx, writer))
+	}()
+
+	// the upload is stopped by closing the pipe, so that a cancelled job
+	// can still abort its multipart upload
+	s3Key := fmt.Sprintf("%s/%s_reconcile.csv", conf.S3StorageConfig.S3UploadFilePath, job.UUID)
+	err = connections.S3Connection.WriteFileStream(context.Background(), jobData.FileS3Bucket, s3Key, reader)
+	if err != nil {
+		// unblock the reconcile goroutine if the upload gave up early
+		reader.CloseWithError(err)
+	}
+	<-reconciled
+
+	report := reconcileService.report
+	message := fmt.Sprintf(
+		"checked %d postgres rows and %d elasticsearch documents: %d missing, %d stale, %d undated, %d orphans; reindexed %d, deleted %d",
+		report.PgChecked, report.EsChecked, report.Missing, report.Stale, report.Undated, report.Orphans, report.Reindexed, report.Deleted,
+	)
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			job.AddMessage("cancelled after it " + message)
+			return cause
+		}
+		return err
+	}
+	job.AddS3Key(s3Key)
+	job.AddMessage(message)
+	return nil
+}
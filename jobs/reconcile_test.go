@@ -0,0 +1,114 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+)
+
+type companyRows struct {
+	models.PgCompanySvcRepo
+	rows []*models.PgCompany
+}
+
+func (s *companyRows) ListAfterId(afterId uint64, updatedAfter *time.Time, limit int) ([]*models.PgCompany, error) {
+	page := make([]*models.PgCompany, 0)
+	for _, row := range s.rows {
+		if updatedAfter != nil && (row.UpdatedAt == nil || !row.UpdatedAt.After(*updatedAfter)) {
+			continue
+		}
+		if row.ID > afterId && len(page) < limit {
+			page = append(page, row)
+		}
+	}
+	return page, nil
+}
+
+func (s *companyRows) Count() (int64, error) {
+	return int64(len(s.rows)), nil
+}
+
+type companyIndex struct {
+	models.ElasticCompanySvcRepo
+	docs    map[string]time.Time
+	indexed []string
+	// onUpsert runs after every bulk request
+	onUpsert func()
+}
+
+func (s *companyIndex) ListByQueryMap(query map[string]any) ([]*models.ElasticCompanySearchHit, error) {
+	hits := make([]*models.ElasticCompanySearchHit, 0)
+	for _, uuid := range query["query"].(map[string]any)["ids"].(map[string]any)["values"].([]string) {
+		if updatedAt, ok := s.docs[uuid]; ok {
+			hit := &models.ElasticCompanySearchHit{}
+			hit.Company.UUID, hit.Company.UpdatedAt = uuid, updatedAt
+			hits = append(hits, hit)
+		}
+	}
+	return hits, nil
+}
+
+func (s *companyIndex) BulkUpsertToIndex(index string, companies []*models.ElasticCompany) (int64, error) {
+	for _, company := range companies {
+		s.indexed = append(s.indexed, company.UUID)
+	}
+	if s.onUpsert != nil {
+		s.onUpsert()
+	}
+	return int64(len(companies)), nil
+}
+
+func TestCheckPostgresChunk(t *testing.T) {
+	updatedAt := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	older := updatedAt.Add(-time.Hour)
+	rows := &companyRows{rows: []*models.PgCompany{
+		{ID: 1, UUID: "synced", UpdatedAt: &updatedAt},
+		{ID: 2, UUID: "missing", UpdatedAt: &updatedAt},
+		{ID: 3, UUID: "undated", UpdatedAt: &updatedAt},
+		{ID: 4, UUID: "stale", UpdatedAt: &updatedAt},
+	}}
+	for _, repair := range []bool{false, true} {
+		index := &companyIndex{docs: map[string]time.Time{
+			"synced":  updatedAt,
+			"undated": {},
+			"stale":   older,
+		}}
+		report := &bytes.Buffer{}
+		r := &ReconcileStruct{
+			ReindexStruct: &ReindexStruct{
+				service:             constants.CompaniesService,
+				batchSize:           10,
+				pgCompanyRepository: rows,
+				esCompanyRepository: index,
+			},
+			repair:    repair,
+			csvWriter: csv.NewWriter(report),
+		}
+		lastId, checked, err := r.checkPostgresChunk(0)
+		if err != nil {
+			t.Fatalf("checkPostgresChunk() error = %v", err)
+		}
+		if lastId != 4 || checked != 4 {
+			t.Errorf("checkPostgresChunk() = %d, %d, want 4, 4", lastId, checked)
+		}
+		want := ReconcileReport{Missing: 1, Stale: 1, Undated: 1}
+		wantIndexed := []string{}
+		if repair {
+			want.Reindexed = 3
+			wantIndexed = []string{"missing", "stale", "undated"}
+		}
+		if r.report != want {
+			t.Errorf("repair %t: report = %+v, want %+v", repair, r.report, want)
+		}
+		indexed := append([]string{}, index.indexed...)
+		slices.Sort(indexed)
+		if !reflect.DeepEqual(indexed, wantIndexed) {
+			t.Errorf("repair %t: indexed = %v, want %v", repair, indexed, wantIndexed)
+		}
+	}
+}
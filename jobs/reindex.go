@@ -0,0 +1,315 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/examples"
+	"vivek-ray/models"
+	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
+)
+
+type ReindexStruct struct {
+	service   string
+	alias     string
+	mapping   []byte
+	batchSize int
+
+	indexRepository         models.ElasticIndexSvcRepo
+	checkpointsRepository   models.ReindexCheckpointsSvcRepo
+	recordChangesRepository models.RecordChangesSvcRepo
+	pgContactRepository     models.PgContactSvcRepo
+	pgCompanyRepository     models.PgCompanySvcRepo
+	esContactRepository     models.ElasticContactSvcRepo
+	esCompanyRepository     models.ElasticCompanySvcRepo
+}
+
+func NewReindexService(service string, batchSize int) (*ReindexStruct, error) {
+	r := &ReindexStruct{
+		service:                 service,
+		batchSize:               utilities.InlineIf(batchSize > 0, batchSize, constants.DefaultReindexBatchSize).(int),
+		indexRepository:         models.ElasticIndexRepository(connections.ElasticsearchConnection.Client),
+		checkpointsRepository:   models.ReindexCheckpointsRepository(connections.PgDBConnection.Client),
+		recordChangesRepository: models.RecordChangesRepository(connections.PgDBConnection.Client),
+		pgContactRepository:     models.PgContactRepository(connections.PgDBConnection.Client),
+		pgCompanyRepository:     models.PgCompanyRepository(connections.PgDBConnection.Client),
+		esContactRepository:     models.ElasticContactRepository(connections.ElasticsearchConnection.Client),
+		esCompanyRepository:     models.ElasticCompanyRepository(connections.ElasticsearchConnection.Client),
+	}
+	switch service {
+	case constants.ContactsService:
+		r.alias, r.mapping = constants.ContactIndex, examples.ContactIndexMapping
+	case constants.CompaniesService:
+		r.alias, r.mapping = constants.CompanyIndex, examples.CompanyIndexMapping
+	default:
+		return nil, constants.InvalidServiceError
+	}
+	return r, nil
+}
+
+// loadCheckpoint returns the checkpoint of the service's interrupted reindex.
+// Checkpoints live in Postgres, so a reindex can be resumed from any host.
+func (r *ReindexStruct) loadCheckpoint() (*models.ModelReindexCheckpoint, error) {
+	checkpoint, err := r.checkpointsRepository.Get(r.service)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint == nil {
+		return nil, constants.ReindexCheckpointNotFoundError
+	}
+	return checkpoint, nil
+}
+
+// indexContacts writes contacts, denormalised with their company, into index.
+func (r *ReindexStruct) indexContacts(index string, contacts []*models.PgContact) error {
+	companyIds := make([]string, 0, len(contacts))
+	for _, contact := range contacts {
+		companyIds = append(companyIds, contact.CompanyID)
+	}
+	companies, err := r.pgCompanyRepository.ListByFilters(models.PgCompanyFilters{Uuids: companyIds})
+	if err != nil {
+		return err
+	}
+	companiesMap := make(map[string]*models.PgCompany)
+	for _, company := range companies {
+		companiesMap[company.UUID] = company
+	}
+
+	esContacts := make([]*models.ElasticContact, 0, len(contacts))
+	for _, contact := range contacts {
+		company, ok := companiesMap[contact.CompanyID]
+		if !ok {
+			company = &models.PgCompany{}
+		}
+		esContacts = append(esContacts, models.ElasticContactFromRawData(contact, company))
+	}
+	_, err = r.esContactRepository.BulkUpsertToIndex(index, esContacts)
+	return err
+}
+
+func (r *ReindexStruct) indexCompanies(index string, companies []*models.PgCompany) error {
+	esCompanies := make([]*models.ElasticCompany, 0, len(companies))
+	for _, company := range companies {
+		esCompanies = append(esCompanies, models.ElasticCompanyFromRawData(company))
+	}
+	_, err := r.esCompanyRepository.BulkUpsertToIndex(index, esCompanies)
+	return err
+}
+
+// indexBatch copies up to batchSize rows with id > afterId into index and
+// returns the last id it saw along with the number of documents written.
+func (r *ReindexStruct) indexBatch(index string, afterId uint64, updatedAfter *time.Time) (uint64, int, error) {
+	switch r.service {
+	case constants.ContactsService:
+		contacts, err := r.pgContactRepository.ListAfterId(afterId, updatedAfter, r.batchSize)
+		if err != nil || len(contacts) == 0 {
+			return afterId, 0, err
+		}
+		if err := r.indexContacts(index, contacts); err != nil {
+			return afterId, 0, err
+		}
+		return contacts[len(contacts)-1].ID, len(contacts), nil
+
+	case constants.CompaniesService:
+		companies, err := r.pgCompanyRepository.ListAfterId(afterId, updatedAfter, r.batchSize)
+		if err != nil || len(companies) == 0 {
+			return afterId, 0, err
+		}
+		if err := r.indexCompanies(index, companies); err != nil {
+			return afterId, 0, err
+		}
+		return companies[len(companies)-1].ID, len(companies), nil
+	}
+	return afterId, 0, constants.InvalidServiceError
+}
+
+// deleteBatch removes from index up to batchSize records deleted since the
+// reindex started, after the delete with id afterId, and returns the last id
+// it saw along with the number of deletes read.
+func (r *ReindexStruct) deleteBatch(index string, afterId uint64, since time.Time) (uint64, int, error) {
+	changes, err := r.recordChangesRepository.ListDeletesSince(r.service, since, afterId, r.batchSize)
+	if err != nil || len(changes) == 0 {
+		return afterId, 0, err
+	}
+	uuids := make([]string, 0, len(changes))
+	for _, change := range changes {
+		uuids = append(uuids, change.RecordUUID)
+	}
+	if r.service == constants.ContactsService {
+		_, err = r.esContactRepository.BulkDeleteFromIndex(index, uuids)
+	} else {
+		_, err = r.esCompanyRepository.BulkDeleteFromIndex(index, uuids)
+	}
+	if err != nil {
+		return afterId, 0, err
+	}
+	return changes[len(changes)-1].ID, len(changes), nil
+}
+
+func (r *ReindexStruct) count() (int64, error) {
+	if r.service == constants.ContactsService {
+		return r.pgContactRepository.Count()
+	}
+	return r.pgCompanyRepository.Count()
+}
+
+// swapAlias points the alias at newIndex in one atomic request. A legacy
+// concrete index that still carries the alias name is dropped in the same
+// request, since an alias cannot coexist with an index of the same name.
+func (r *ReindexStruct) swapAlias(newIndex string) ([]string, error) {
+	oldIndices, err := r.indexRepository.GetAliasIndices(r.alias)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make([]map[string]any, 0, len(oldIndices)+1)
+	for _, oldIndex := range oldIndices {
+		actions = append(actions, map[string]any{
+			"remove": map[string]any{"index": oldIndex, "alias": r.alias},
+		})
+	}
+	if len(oldIndices) == 0 {
+		exists, err := r.indexRepository.Exists(r.alias)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			log.Warn().Msgf("Concrete index %s will be replaced by an alias", r.alias)
+			actions = append(actions, map[string]any{
+				"remove_index": map[string]any{"index": r.alias},
+			})
+		}
+	}
+	actions = append(actions, map[string]any{
+		"add": map[string]any{"index": newIndex, "alias": r.alias},
+	})
+	return oldIndices, r.indexRepository.UpdateAliases(actions)
+}
+
+func (r *ReindexStruct) Run(ctx context.Context, resume bool, deleteOld bool) error {
+	var checkpoint *models.ModelReindexCheckpoint
+	if resume {
+		loaded, err := r.loadCheckpoint()
+		if err != nil {
+			return err
+		}
+		exists, err := r.indexRepository.Exists(loaded.Index)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return constants.ReindexCheckpointNotFoundError
+		}
+		checkpoint = loaded
+		log.Info().Msgf("Resuming reindex of %s into %s after id %d", r.service, checkpoint.Index, checkpoint.LastId)
+	} else {
+		serverTime := time.Now().UTC()
+		checkpoint = &models.ModelReindexCheckpoint{
+			Service:   r.service,
+			Index:     fmt.Sprintf("%s_v%s", r.alias, serverTime.Format("20060102150405")),
+			StartedAt: serverTime,
+		}
+		if err := r.indexRepository.Create(checkpoint.Index, r.mapping); err != nil {
+			return err
+		}
+		if err := r.checkpointsRepository.Save(checkpoint); err != nil {
+			return err
+		}
+		log.Info().Msgf("Created index %s for %s", checkpoint.Index, r.service)
+	}
+
+	total, err := r.count()
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msgf("Reindex interrupted at id %d, rerun with --resume to continue", checkpoint.LastId)
+			return ctx.Err()
+		default:
+		}
+
+		lastId, indexed, err := r.indexBatch(checkpoint.Index, checkpoint.LastId, nil)
+		if err != nil {
+			return err
+		}
+		if indexed == 0 {
+			break
+		}
+		checkpoint.LastId = lastId
+		checkpoint.Indexed += int64(indexed)
+		if err := r.checkpointsRepository.Save(checkpoint); err != nil {
+			return err
+		}
+		log.Info().Msgf("Reindex %s: %d/%d documents into %s", r.service, checkpoint.Indexed, total, checkpoint.Index)
+	}
+
+	// Writes that landed on the old index while we were copying are picked up
+	// by replaying every delete logged, and then every row touched, since the
+	// reindex started. Deletes go first so that a record deleted and revived
+	// in the meantime ends up indexed.
+	var catchUpId uint64
+	for {
+		if err := context.Cause(ctx); err != nil {
+			log.Info().Msg("Reindex interrupted while catching up, rerun with --resume to continue")
+			return err
+		}
+		lastId, deleted, err := r.deleteBatch(checkpoint.Index, catchUpId, checkpoint.StartedAt)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			break
+		}
+		catchUpId = lastId
+		log.Info().Msgf("Reindex %s: removed %d documents deleted since %s", r.service, deleted, checkpoint.StartedAt.Format(time.RFC3339))
+	}
+
+	catchUpId = 0
+	for {
+		if err := context.Cause(ctx); err != nil {
+			log.Info().Msg("Reindex interrupted while catching up, rerun with --resume to continue")
+			return err
+		}
+		lastId, indexed, err := r.indexBatch(checkpoint.Index, catchUpId, &checkpoint.StartedAt)
+		if err != nil {
+			return err
+		}
+		if indexed == 0 {
+			break
+		}
+		catchUpId = lastId
+		log.Info().Msgf("Reindex %s: caught up %d documents updated since %s", r.service, indexed, checkpoint.StartedAt.Format(time.RFC3339))
+	}
+
+	if err := r.indexRepository.Refresh(checkpoint.Index); err != nil {
+		return err
+	}
+	oldIndices, err := r.swapAlias(checkpoint.Index)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Alias %s now points to %s", r.alias, checkpoint.Index)
+
+	if deleteOld {
+		if err := r.indexRepository.Delete(oldIndices); err != nil {
+			return err
+		}
+		log.Info().Msgf("Deleted old indices: %v", oldIndices)
+	}
+	return r.checkpointsRepository.Delete(r.service)
+}
+
+func RunReindex(ctx context.Context, service string, batchSize int, resume bool, deleteOld bool) error {
+	reindexService, err := NewReindexService(service, batchSize)
+	if err != nil {
+		return err
+	}
+	return reindexService.Run(ctx, resume, deleteOld)
+}
@@ -0,0 +1,127 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+)
+
+type checkpointStore struct {
+	models.ReindexCheckpointsSvcRepo
+	checkpoints map[string]models.ModelReindexCheckpoint
+}
+
+func (s *checkpointStore) Get(service string) (*models.ModelReindexCheckpoint, error) {
+	checkpoint, ok := s.checkpoints[service]
+	if !ok {
+		return nil, nil
+	}
+	return &checkpoint, nil
+}
+
+func (s *checkpointStore) Save(checkpoint *models.ModelReindexCheckpoint) error {
+	s.checkpoints[checkpoint.Service] = *checkpoint
+	return nil
+}
+
+func (s *checkpointStore) Delete(service string) error {
+	delete(s.checkpoints, service)
+	return nil
+}
+
+type indexStore struct {
+	models.ElasticIndexSvcRepo
+	indices map[string]bool
+	aliased string
+}
+
+func (s *indexStore) Create(index string, body []byte) error {
+	s.indices[index] = true
+	return nil
+}
+
+func (s *indexStore) Exists(index string) (bool, error) {
+	return s.indices[index], nil
+}
+
+func (s *indexStore) Refresh(index string) error {
+	return nil
+}
+
+func (s *indexStore) GetAliasIndices(alias string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *indexStore) UpdateAliases(actions []map[string]any) error {
+	for _, action := range actions {
+		if add, ok := action["add"].(map[string]any); ok {
+			s.aliased = add["index"].(string)
+		}
+	}
+	return nil
+}
+
+type noDeletes struct {
+	models.RecordChangesSvcRepo
+}
+
+func (noDeletes) ListDeletesSince(service string, since time.Time, afterId uint64, limit int) ([]*models.ModelRecordChange, error) {
+	return nil, nil
+}
+
+func TestReindexResumesFromItsCheckpoint(t *testing.T) {
+	updatedAt := time.Now().Add(-time.Hour)
+	rows := &companyRows{rows: []*models.PgCompany{
+		{ID: 1, UUID: "a", UpdatedAt: &updatedAt},
+		{ID: 2, UUID: "b", UpdatedAt: &updatedAt},
+		{ID: 3, UUID: "c", UpdatedAt: &updatedAt},
+		{ID: 4, UUID: "d", UpdatedAt: &updatedAt},
+	}}
+	checkpoints := &checkpointStore{checkpoints: make(map[string]models.ModelReindexCheckpoint)}
+	indices := &indexStore{indices: make(map[string]bool)}
+	documents := &companyIndex{}
+	newReindex := func() *ReindexStruct {
+		return &ReindexStruct{
+			service:                 constants.CompaniesService,
+			alias:                   constants.CompanyIndex,
+			batchSize:               2,
+			indexRepository:         indices,
+			checkpointsRepository:   checkpoints,
+			recordChangesRepository: noDeletes{},
+			pgCompanyRepository:     rows,
+			esCompanyRepository:     documents,
+		}
+	}
+
+	// the first run is interrupted after its first batch
+	ctx, cancel := context.WithCancel(context.Background())
+	documents.onUpsert = cancel
+	if err := newReindex().Run(ctx, false, false); !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted Run() error = %v, want %v", err, context.Canceled)
+	}
+	checkpoint, ok := checkpoints.checkpoints[constants.CompaniesService]
+	if !ok || checkpoint.LastId != 2 || checkpoint.Indexed != 2 || !indices.indices[checkpoint.Index] {
+		t.Fatalf("checkpoint = %+v, want the first batch of a created index", checkpoint)
+	}
+
+	documents.onUpsert = nil
+	if err := newReindex().Run(context.Background(), true, false); err != nil {
+		t.Fatalf("resumed Run() error = %v", err)
+	}
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(documents.indexed, want) {
+		t.Errorf("indexed = %v, want %v", documents.indexed, want)
+	}
+	if indices.aliased != checkpoint.Index {
+		t.Errorf("alias points to %q, want %q", indices.aliased, checkpoint.Index)
+	}
+	if _, ok := checkpoints.checkpoints[constants.CompaniesService]; ok {
+		t.Errorf("the checkpoint of a finished reindex was kept")
+	}
+	if err := newReindex().Run(context.Background(), true, false); !errors.Is(err, constants.ReindexCheckpointNotFoundError) {
+		t.Errorf("Run() without a checkpoint error = %v, want %v", err, constants.ReindexCheckpointNotFoundError)
+	}
+}
@@ -0,0 +1,22 @@
+package jobs
+
+import (
+	"math/rand/v2"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/utilities"
+)
+
+// retryBackoff is how long a job waits before its next attempt: the retry
+// interval doubled for every attempt after the first, capped at
+// MaxJobRetryBackoff, with up to half of it taken off at random so that jobs
+// failing together do not retry together.
+func retryBackoff(retryInterval, attempts int) time.Duration {
+	base := time.Duration(utilities.InlineIf(retryInterval > 0, retryInterval, constants.DefaultJobRetryInterval).(int)) * time.Second
+	backoff := base
+	for i := 1; i < attempts && backoff < constants.MaxJobRetryBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, constants.MaxJobRetryBackoff)
+	return backoff - rand.N(backoff/2+1)
+}
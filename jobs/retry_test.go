@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+	"vivek-ray/constants"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name          string
+		retryInterval int
+		attempts      int
+		want          time.Duration
+	}{
+		{name: "first attempt waits the interval", retryInterval: 10, attempts: 1, want: 10 * time.Second},
+		{name: "doubles for every attempt", retryInterval: 10, attempts: 4, want: 80 * time.Second},
+		{name: "default interval", retryInterval: 0, attempts: 1, want: time.Duration(constants.DefaultJobRetryInterval) * time.Second},
+		{name: "negative interval falls back to the default", retryInterval: -5, attempts: 2, want: 2 * time.Duration(constants.DefaultJobRetryInterval) * time.Second},
+		{name: "capped", retryInterval: 60, attempts: 10, want: constants.MaxJobRetryBackoff},
+		{name: "capped without overflowing", retryInterval: 60, attempts: 1000, want: constants.MaxJobRetryBackoff},
+		{name: "interval above the cap", retryInterval: 7200, attempts: 1, want: constants.MaxJobRetryBackoff},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// jitter takes up to half of the wait off
+			for range 50 {
+				got := retryBackoff(test.retryInterval, test.attempts)
+				if got > test.want || got < test.want-test.want/2 {
+					t.Fatalf("retryBackoff(%d, %d) = %s, want between %s and %s", test.retryInterval, test.attempts, got, test.want-test.want/2, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,598 @@
+package jobs
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"vivek-ray/conf"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	companyService "vivek-ray/modules/companies/service"
+	contactHelper "vivek-ray/modules/contacts/helper"
+	contactService "vivek-ray/modules/contacts/service"
+	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	rollbackDeleted  = "deleted"
+	rollbackRestored = "restored"
+	rollbackConflict = "conflict"
+	rollbackMissing  = "missing"
+	// rollbackChanged is a record written by someone between reading it and
+	// locking it for the restore; it is left alone
+	rollbackChanged = "changed"
+)
+
+var rollbackReportHeaders = []string{"service", "uuid", "action", "restored_fields", "conflicting_fields"}
+
+type RollbackReport struct {
+	Deleted        int64 `json:"deleted"`
+	Restored       int64 `json:"restored"`
+	Conflicts      int64 `json:"conflicts"`
+	Missing        int64 `json:"missing"`
+	FiltersCleaned int64 `json:"filters_cleaned"`
+}
+
+// rollbackPlan folds everything the source job did to one record: the field
+// values before its first change and after its last one.
+type rollbackPlan struct {
+	created     bool
+	deleted     bool
+	firstChange uint64
+	before      map[string]any
+	after       map[string]any
+}
+
+func buildRollbackPlans(changes []*models.ModelRecordChange) map[string]*rollbackPlan {
+	plans := make(map[string]*rollbackPlan)
+	for _, change := range changes {
+		plan, ok := plans[change.RecordUUID]
+		if !ok {
+			plan = &rollbackPlan{
+				created:     change.ChangeType == constants.ChangeCreate,
+				firstChange: change.ID,
+				before:      make(map[string]any),
+				after:       make(map[string]any),
+			}
+			plans[change.RecordUUID] = plan
+		}
+		plan.deleted = change.ChangeType == constants.ChangeDelete
+		for field, fieldChange := range change.Changes {
+			if _, seen := plan.before[field]; !seen {
+				plan.before[field] = fieldChange.Old
+			}
+			plan.after[field] = fieldChange.New
+		}
+	}
+	return plans
+}
+
+// resolve splits the fields the job wrote into those still holding the job's
+// value, which are safe to revert, and those changed by someone since.
+func (p *rollbackPlan) resolve(current map[string]any) ([]string, []string) {
+	restorable, conflicting := make([]string, 0), make([]string, 0)
+	for field, value := range p.after {
+		if reflect.DeepEqual(current[field], value) {
+			restorable = append(restorable, field)
+		} else {
+			conflicting = append(conflicting, field)
+		}
+	}
+	sort.Strings(restorable)
+	sort.Strings(conflicting)
+	return restorable, conflicting
+}
+
+// changedSince returns the fields that other changes to the record wrote after
+// the job first touched it, whether or not the job wrote them as well.
+func (p *rollbackPlan) changedSince(others []*models.ModelRecordChange) []string {
+	fields := make(map[string]bool)
+	for _, change := range others {
+		if change.ID <= p.firstChange {
+			continue
+		}
+		for field := range change.Changes {
+			fields[field] = true
+		}
+	}
+	changed := make([]string, 0, len(fields))
+	for field := range fields {
+		changed = append(changed, field)
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// mergeFields returns the sorted union of two sorted field lists.
+func mergeFields(a, b []string) []string {
+	merged := append(append(make([]string, 0, len(a)+len(b)), a...), b...)
+	sort.Strings(merged)
+	return slices.Compact(merged)
+}
+
+// restoredRecord is the restore planned for one record: the previous values
+// of fields, valid only while the row still has the updated_at it was planned
+// against.
+type restoredRecord struct {
+	uuid        string
+	updatedAt   string
+	patch       map[string]json.RawMessage
+	fields      []string
+	conflicting []string
+}
+
+func newRestoredRecord(uuid string, plan *rollbackPlan, state map[string]any, fields, conflicting []string) (restoredRecord, error) {
+	restored := restoredRecord{uuid: uuid, fields: fields, conflicting: conflicting, patch: make(map[string]json.RawMessage, len(fields))}
+	restored.updatedAt, _ = state["updated_at"].(string)
+	for _, field := range fields {
+		value, err := json.Marshal(plan.before[field])
+		if err != nil {
+			return restored, err
+		}
+		restored.patch[field] = value
+	}
+	return restored, nil
+}
+
+// unchanged reports whether the locked row is still the one the restore was
+// planned against, i.e. nobody wrote it in between.
+func (restored restoredRecord) unchanged(updatedAt *time.Time) bool {
+	if updatedAt == nil {
+		return restored.updatedAt == ""
+	}
+	plannedAt, err := time.Parse(time.RFC3339Nano, restored.updatedAt)
+	return err == nil && plannedAt.Equal(*updatedAt)
+}
+
+type RollbackStruct struct {
+	rollbackJob string
+	sourceJob   string
+	batchSize   int
+	provenance  utilities.Provenance
+	tempFilters []*models.ModelFilter
+
+	recordChangesRepository models.RecordChangesSvcRepo
+	filtersDataRepository   models.FiltersDataSvcRepo
+	pgContactRepository     models.PgContactSvcRepo
+	pgCompanyRepository     models.PgCompanySvcRepo
+	esContactRepository     models.ElasticContactSvcRepo
+	esCompanyRepository     models.ElasticCompanySvcRepo
+	contactService          contactService.ContactSvcRepo
+	companyService          companyService.CompanySvcRepo
+
+	// filter values the job wrote, per service and filter key; they are
+	// dropped from filters_data once no live record carries them
+	filterValues map[string]map[string]map[string]bool
+	csvWriter    *csv.Writer
+	report       RollbackReport
+}
+
+func NewRollbackService(rollbackJob string, jobData utilities.RollbackImportJobData) (*RollbackStruct, error) {
+	if jobData.SourceJob == "" {
+		return nil, constants.SourceJobRequiredError
+	}
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		return nil, err
+	}
+	serverTime := time.Now()
+	return &RollbackStruct{
+		rollbackJob: rollbackJob,
+		sourceJob:   jobData.SourceJob,
+		batchSize:   conf.JobConfig.BatchSize,
+		provenance:  utilities.Provenance{SourceJob: rollbackJob, IngestedAt: &serverTime},
+		tempFilters: tempFilters,
+
+		recordChangesRepository: models.RecordChangesRepository(connections.PgDBConnection.Client),
+		filtersDataRepository:   models.FiltersDataRepository(connections.PgDBConnection.Client),
+		pgContactRepository:     models.PgContactRepository(connections.PgDBConnection.Client),
+		pgCompanyRepository:     models.PgCompanyRepository(connections.PgDBConnection.Client),
+		esContactRepository:     models.ElasticContactRepository(connections.ElasticsearchConnection.Client),
+		esCompanyRepository:     models.ElasticCompanyRepository(connections.ElasticsearchConnection.Client),
+		contactService:          contactService.NewContactService(tempFilters),
+		companyService:          companyService.NewCompanyService(tempFilters),
+
+		filterValues: make(map[string]map[string]map[string]bool),
+	}, nil
+}
+
+func (r *RollbackStruct) writeOutcome(service, uuid, action string, restored, conflicting []string) error {
+	return r.csvWriter.Write([]string{service, uuid, action, strings.Join(restored, ";"), strings.Join(conflicting, ";")})
+}
+
+// collectFilterValues remembers the filter values the job wrote so they can
+// be checked once every record has been reverted.
+func (r *RollbackStruct) collectFilterValues(service string, plan *rollbackPlan) {
+	for _, filter := range r.tempFilters {
+		if filter.Service != service {
+			continue
+		}
+		value, ok := plan.after[filter.Key]
+		if !ok || value == nil {
+			continue
+		}
+		if _, ok := r.filterValues[service]; !ok {
+			r.filterValues[service] = make(map[string]map[string]bool)
+		}
+		if _, ok := r.filterValues[service][filter.Key]; !ok {
+			r.filterValues[service][filter.Key] = make(map[string]bool)
+		}
+		// a value is looked up in array columns if any record held it in one
+		values := r.filterValues[service][filter.Key]
+		switch typed := value.(type) {
+		case []any:
+			for _, item := range typed {
+				if text, ok := item.(string); ok {
+					values[text] = true
+				}
+			}
+		case string:
+			if _, ok := values[typed]; !ok {
+				values[typed] = false
+			}
+		}
+	}
+}
+
+func (r *RollbackStruct) currentStates(service string, uuids []string) (map[string]map[string]any, error) {
+	var records []any
+	switch service {
+	case constants.ContactsService:
+		contacts, err := r.pgContactRepository.ListByFilters(models.PgContactFilters{Uuids: uuids})
+		if err != nil {
+			return nil, err
+		}
+		for _, contact := range contacts {
+			records = append(records, contact)
+		}
+	case constants.CompaniesService:
+		companies, err := r.pgCompanyRepository.ListByFilters(models.PgCompanyFilters{Uuids: uuids})
+		if err != nil {
+			return nil, err
+		}
+		for _, company := range companies {
+			records = append(records, company)
+		}
+	}
+
+	states := make(map[string]map[string]any, len(records))
+	for _, record := range records {
+		state, err := models.RecordState(record)
+		if err != nil {
+			return nil, err
+		}
+		states[state["uuid"].(string)] = state
+	}
+	return states, nil
+}
+
+// applyContacts deletes and restores contacts, and returns the uuids of the
+// restores it skipped. Each restore is applied to its row locked in the upsert
+// transaction, and only if nobody wrote the row since the plan was read.
+func (r *RollbackStruct) applyContacts(deletes []string, restores []restoredRecord) (map[string]bool, error) {
+	if _, err := r.pgContactRepository.DeleteByUuids(deletes, r.provenance); err != nil {
+		return nil, err
+	}
+	if _, err := r.esContactRepository.BulkDelete(deletes); err != nil {
+		return nil, err
+	}
+	if len(restores) == 0 {
+		return nil, nil
+	}
+
+	planned := make(map[string]restoredRecord, len(restores))
+	contacts := make([]*models.PgContact, 0, len(restores))
+	for _, restored := range restores {
+		// a restore that does not decode fails before any row is locked
+		if _, err := utilities.ApplyPatch(&models.PgContact{}, restored.patch); err != nil {
+			return nil, err
+		}
+		planned[restored.uuid] = restored
+		contacts = append(contacts, &models.PgContact{UUID: restored.uuid})
+	}
+	skipped := make(map[string]bool)
+	_, err := r.contactService.UpsertLocked(contacts, func(stored, contact *models.PgContact) bool {
+		restored := planned[contact.UUID]
+		if stored == nil || !restored.unchanged(stored.UpdatedAt) {
+			skipped[contact.UUID] = true
+			return false
+		}
+		*contact = *stored
+		if _, err := utilities.ApplyPatch(contact, restored.patch); err != nil {
+			skipped[contact.UUID] = true
+			return false
+		}
+		// the original source of a reverted value is not known, so it falls
+		// back to the record level provenance of the rollback job
+		contact.FieldSources = maps.Clone(stored.FieldSources)
+		for _, field := range restored.fields {
+			delete(contact.FieldSources, field)
+		}
+		contact.SetProvenance(r.provenance).UpdatedAt = r.provenance.IngestedAt
+		return true
+	}, contactHelper.BuildElasticContacts)
+	return skipped, err
+}
+
+func (r *RollbackStruct) applyCompanies(deletes []string, restores []restoredRecord) (map[string]bool, error) {
+	if _, err := r.pgCompanyRepository.DeleteByUuids(deletes, r.provenance); err != nil {
+		return nil, err
+	}
+	if _, err := r.esCompanyRepository.BulkDelete(deletes); err != nil {
+		return nil, err
+	}
+	if len(restores) == 0 {
+		return nil, nil
+	}
+
+	planned := make(map[string]restoredRecord, len(restores))
+	companies := make([]*models.PgCompany, 0, len(restores))
+	for _, restored := range restores {
+		if _, err := utilities.ApplyPatch(&models.PgCompany{}, restored.patch); err != nil {
+			return nil, err
+		}
+		planned[restored.uuid] = restored
+		companies = append(companies, &models.PgCompany{UUID: restored.uuid})
+	}
+	skipped := make(map[string]bool)
+	_, err := r.companyService.UpsertLocked(companies, func(stored, company *models.PgCompany) bool {
+		restored := planned[company.UUID]
+		if stored == nil || !restored.unchanged(stored.UpdatedAt) {
+			skipped[company.UUID] = true
+			return false
+		}
+		*company = *stored
+		if _, err := utilities.ApplyPatch(company, restored.patch); err != nil {
+			skipped[company.UUID] = true
+			return false
+		}
+		company.FieldSources = maps.Clone(stored.FieldSources)
+		for _, field := range restored.fields {
+			delete(company.FieldSources, field)
+		}
+		company.SetProvenance(r.provenance).UpdatedAt = r.provenance.IngestedAt
+		return true
+	})
+	return skipped, err
+}
+
+// rollbackChunk reverts one page of records: records the job created are
+// deleted, records it updated get their previous values back. Fields changed
+// by anyone after the job are left alone and reported as conflicts, as are
+// records written while the page was being reverted.
+func (r *RollbackStruct) rollbackChunk(service string, uuids []string) error {
+	changes, err := r.recordChangesRepository.ListBySourceJob(r.sourceJob, service, uuids)
+	if err != nil {
+		return err
+	}
+	plans := buildRollbackPlans(changes)
+	otherChanges, err := r.recordChangesRepository.ListByOtherSources(r.sourceJob, service, uuids)
+	if err != nil {
+		return err
+	}
+	others := make(map[string][]*models.ModelRecordChange)
+	for _, change := range otherChanges {
+		others[change.RecordUUID] = append(others[change.RecordUUID], change)
+	}
+	states, err := r.currentStates(service, uuids)
+	if err != nil {
+		return err
+	}
+
+	deletes, restores := make([]string, 0), make([]restoredRecord, 0)
+	for _, uuid := range uuids {
+		plan, ok := plans[uuid]
+		if !ok {
+			continue
+		}
+		r.collectFilterValues(service, plan)
+
+		state, ok := states[uuid]
+		if !ok {
+			r.report.Missing++
+			if err := r.writeOutcome(service, uuid, rollbackMissing, nil, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		restorable, conflicting := plan.resolve(state)
+		if plan.created {
+			// deleting a record the job created would also drop what others
+			// wrote to it since, including fields the job never touched
+			conflicting = mergeFields(conflicting, plan.changedSince(others[uuid]))
+		}
+		var action string
+		switch {
+		case plan.deleted:
+			// the job's last word was a delete, yet the row exists again
+			action, restorable, conflicting = rollbackConflict, nil, nil
+		case plan.created && len(conflicting) == 0:
+			action = rollbackDeleted
+			deletes = append(deletes, uuid)
+		case plan.created || len(restorable) == 0:
+			action, restorable = rollbackConflict, nil
+		default:
+			// the outcome is known once the restore met its locked row
+			restored, err := newRestoredRecord(uuid, plan, state, restorable, conflicting)
+			if err != nil {
+				return err
+			}
+			restores = append(restores, restored)
+			continue
+		}
+
+		if action == rollbackDeleted {
+			r.report.Deleted++
+		}
+		if len(conflicting) > 0 || action == rollbackConflict {
+			r.report.Conflicts++
+		}
+		if err := r.writeOutcome(service, uuid, action, restorable, conflicting); err != nil {
+			return err
+		}
+	}
+
+	var skipped map[string]bool
+	if service == constants.ContactsService {
+		skipped, err = r.applyContacts(deletes, restores)
+	} else {
+		skipped, err = r.applyCompanies(deletes, restores)
+	}
+	if err != nil {
+		return err
+	}
+	for _, restored := range restores {
+		action, restorable, conflicting := rollbackRestored, restored.fields, restored.conflicting
+		if skipped[restored.uuid] {
+			action, restorable, conflicting = rollbackChanged, nil, restored.fields
+		} else {
+			r.report.Restored++
+		}
+		if len(conflicting) > 0 {
+			r.report.Conflicts++
+		}
+		if err := r.writeOutcome(service, restored.uuid, action, restorable, conflicting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanFiltersData soft deletes the filter values the job wrote that no live
+// record carries anymore. The values of each filter are checked in one query.
+func (r *RollbackStruct) cleanFiltersData() error {
+	stale := make([]string, 0)
+	for service, keys := range r.filterValues {
+		for key, valueArrays := range keys {
+			values, isArray := make([]string, 0, len(valueArrays)), false
+			for value, fromArray := range valueArrays {
+				values = append(values, value)
+				isArray = isArray || fromArray
+			}
+			var carried []string
+			var err error
+			if service == constants.ContactsService {
+				carried, err = r.pgContactRepository.CarriedValues(key, values, isArray)
+			} else {
+				carried, err = r.pgCompanyRepository.CarriedValues(key, values, isArray)
+			}
+			if err != nil {
+				return err
+			}
+			carriedSet := make(map[string]struct{}, len(carried))
+			for _, value := range carried {
+				carriedSet[value] = struct{}{}
+			}
+			for _, value := range values {
+				if _, ok := carriedSet[value]; !ok {
+					stale = append(stale, utilities.GenerateUUID5(key+service+value))
+				}
+			}
+		}
+	}
+	cleaned, err := r.filtersDataRepository.SoftDelete(stale)
+	r.report.FiltersCleaned = cleaned
+	return err
+}
+
+// Run reverts the source job's records page by page and writes the outcome of
+// each to writer. It stops between pages once ctx is cancelled, before the
+// filter values are cleaned.
+func (r *RollbackStruct) Run(ctx context.Context, writer io.Writer) error {
+	r.csvWriter = csv.NewWriter(writer)
+	defer r.csvWriter.Flush()
+	if err := r.csvWriter.Write(rollbackReportHeaders); err != nil {
+		return err
+	}
+
+	// contacts first, so a company the job created is no longer referenced by
+	// contacts from the same job when it is removed
+	for _, service := range []string{constants.ContactsService, constants.CompaniesService} {
+		var afterUuid string
+		for {
+			if err := context.Cause(ctx); err != nil {
+				return err
+			}
+			uuids, err := r.recordChangesRepository.ListRecordsBySourceJob(r.sourceJob, service, afterUuid, r.batchSize)
+			if err != nil {
+				return err
+			}
+			if len(uuids) == 0 {
+				break
+			}
+			if err := r.rollbackChunk(service, uuids); err != nil {
+				return err
+			}
+			afterUuid = uuids[len(uuids)-1]
+			r.csvWriter.Flush()
+		}
+		log.Info().Msgf("Rollback of job %s: %s records processed", r.sourceJob, service)
+	}
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	if err := r.cleanFiltersData(); err != nil {
+		return err
+	}
+	return r.csvWriter.Error()
+}
+
+func ProcessRollbackImport(ctx context.Context, job *models.ModelJobs) error {
+	var jobData utilities.RollbackImportJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return err
+	}
+	if jobData.FileS3Bucket == "" {
+		jobData.FileS3Bucket = conf.S3StorageConfig.S3Bucket
+	}
+	rollbackService, err := NewRollbackService(job.UUID, jobData)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	rolledBack := make(chan struct{})
+	go func() {
+		defer close(rolledBack)
+		writer.CloseWithError(rollbackService.Run(ctx, writer))
+	}()
+
+	s3Key := fmt.Sprintf("%s/%s_rollback.csv", conf.S3StorageConfig.S3UploadFilePath, job.UUID)
+	err = connections.S3Connection.WriteFileStream(context.Background(), jobData.FileS3Bucket, s3Key, reader)
+	if err != nil {
+		// unblock the rollback goroutine if the upload gave up early
+		reader.CloseWithError(err)
+	}
+	<-rolledBack
+
+	report := rollbackService.report
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			job.AddMessage(fmt.Sprintf(
+				"cancelled after rolling back part of job %s: deleted %d, restored %d, %d conflicts, %d missing; filter values were not cleaned",
+				jobData.SourceJob, report.Deleted, report.Restored, report.Conflicts, report.Missing,
+			))
+			return cause
+		}
+		return err
+	}
+	job.AddS3Key(s3Key)
+	job.AddMessage(fmt.Sprintf(
+		"rolled back job %s: deleted %d, restored %d, %d conflicts, %d missing; cleaned %d filter values",
+		jobData.SourceJob, report.Deleted, report.Restored, report.Conflicts, report.Missing, report.FiltersCleaned,
+	))
+	return nil
+}
@@ -0,0 +1,309 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	companyService "vivek-ray/modules/companies/service"
+	"vivek-ray/utilities"
+)
+
+func recordChange(id uint64, uuid, changeType string, changes map[string]utilities.FieldChange) *models.ModelRecordChange {
+	return &models.ModelRecordChange{ID: id, RecordUUID: uuid, ChangeType: changeType, Changes: changes}
+}
+
+func TestBuildRollbackPlans(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []*models.ModelRecordChange
+		want    map[string]*rollbackPlan
+	}{
+		{
+			name: "created record",
+			changes: []*models.ModelRecordChange{
+				recordChange(1, "a", constants.ChangeCreate, map[string]utilities.FieldChange{"title": {New: "CEO"}}),
+			},
+			want: map[string]*rollbackPlan{
+				"a": {created: true, firstChange: 1, before: map[string]any{"title": nil}, after: map[string]any{"title": "CEO"}},
+			},
+		},
+		{
+			name: "updates fold into the first old and the last new value",
+			changes: []*models.ModelRecordChange{
+				recordChange(3, "a", constants.ChangeUpdate, map[string]utilities.FieldChange{"title": {Old: "CTO", New: "VP"}}),
+				recordChange(5, "a", constants.ChangeUpdate, map[string]utilities.FieldChange{"title": {Old: "VP", New: "CEO"}, "city": {Old: "Pune", New: "Delhi"}}),
+			},
+			want: map[string]*rollbackPlan{
+				"a": {firstChange: 3, before: map[string]any{"title": "CTO", "city": "Pune"}, after: map[string]any{"title": "CEO", "city": "Delhi"}},
+			},
+		},
+		{
+			name: "last change is a delete",
+			changes: []*models.ModelRecordChange{
+				recordChange(2, "a", constants.ChangeUpdate, map[string]utilities.FieldChange{"title": {Old: "CTO", New: "VP"}}),
+				recordChange(4, "a", constants.ChangeDelete, nil),
+			},
+			want: map[string]*rollbackPlan{
+				"a": {deleted: true, firstChange: 2, before: map[string]any{"title": "CTO"}, after: map[string]any{"title": "VP"}},
+			},
+		},
+		{
+			name: "records are planned separately",
+			changes: []*models.ModelRecordChange{
+				recordChange(1, "a", constants.ChangeCreate, map[string]utilities.FieldChange{"title": {New: "CEO"}}),
+				recordChange(2, "b", constants.ChangeUpdate, map[string]utilities.FieldChange{"title": {Old: "CTO", New: "VP"}}),
+			},
+			want: map[string]*rollbackPlan{
+				"a": {created: true, firstChange: 1, before: map[string]any{"title": nil}, after: map[string]any{"title": "CEO"}},
+				"b": {firstChange: 2, before: map[string]any{"title": "CTO"}, after: map[string]any{"title": "VP"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := buildRollbackPlans(test.changes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("buildRollbackPlans() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRollbackPlanResolve(t *testing.T) {
+	plan := &rollbackPlan{after: map[string]any{"title": "CEO", "city": "Delhi", "tags": []any{"a"}}}
+	tests := []struct {
+		name            string
+		current         map[string]any
+		wantRestorable  []string
+		wantConflicting []string
+	}{
+		{
+			name:            "untouched since the job",
+			current:         map[string]any{"title": "CEO", "city": "Delhi", "tags": []any{"a"}},
+			wantRestorable:  []string{"city", "tags", "title"},
+			wantConflicting: []string{},
+		},
+		{
+			name:            "changed since the job",
+			current:         map[string]any{"title": "CTO", "city": "Delhi", "tags": []any{"a", "b"}},
+			wantRestorable:  []string{"city"},
+			wantConflicting: []string{"tags", "title"},
+		},
+		{
+			name:            "cleared since the job",
+			current:         map[string]any{},
+			wantRestorable:  []string{},
+			wantConflicting: []string{"city", "tags", "title"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restorable, conflicting := plan.resolve(test.current)
+			if !reflect.DeepEqual(restorable, test.wantRestorable) {
+				t.Errorf("restorable = %v, want %v", restorable, test.wantRestorable)
+			}
+			if !reflect.DeepEqual(conflicting, test.wantConflicting) {
+				t.Errorf("conflicting = %v, want %v", conflicting, test.wantConflicting)
+			}
+		})
+	}
+}
+
+func TestRollbackPlanChangedSince(t *testing.T) {
+	plan := &rollbackPlan{created: true, firstChange: 10, after: map[string]any{"title": "CEO"}}
+	tests := []struct {
+		name   string
+		others []*models.ModelRecordChange
+		want   []string
+	}{
+		{
+			name: "no other changes",
+			want: []string{},
+		},
+		{
+			name: "changes before the job are ignored",
+			others: []*models.ModelRecordChange{
+				recordChange(4, "a", constants.ChangeUpdate, map[string]utilities.FieldChange{"city": {New: "Pune"}}),
+			},
+			want: []string{},
+		},
+		{
+			name: "fields the job never wrote count",
+			others: []*models.ModelRecordChange{
+				recordChange(12, "a", constants.ChangeUpdate, map[string]utilities.FieldChange{"city": {New: "Pune"}}),
+				recordChange(14, "a", constants.ChangeUpdate, map[string]utilities.FieldChange{"title": {Old: "CEO", New: "CTO"}, "city": {Old: "Pune", New: "Delhi"}}),
+			},
+			want: []string{"city", "title"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := plan.changedSince(test.others); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("changedSince() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCollectFilterValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		plans []*rollbackPlan
+		want  map[string]bool
+	}{
+		{
+			name:  "scalar value",
+			plans: []*rollbackPlan{{after: map[string]any{"title": "CEO"}}},
+			want:  map[string]bool{"CEO": false},
+		},
+		{
+			name:  "array values",
+			plans: []*rollbackPlan{{after: map[string]any{"title": []any{"CEO", "CTO"}}}},
+			want:  map[string]bool{"CEO": true, "CTO": true},
+		},
+		{
+			name: "a scalar after an array keeps the array lookup",
+			plans: []*rollbackPlan{
+				{after: map[string]any{"title": []any{"CEO"}}},
+				{after: map[string]any{"title": "CEO"}},
+			},
+			want: map[string]bool{"CEO": true},
+		},
+		{
+			name: "an array after a scalar switches to the array lookup",
+			plans: []*rollbackPlan{
+				{after: map[string]any{"title": "CEO"}},
+				{after: map[string]any{"title": []any{"CEO"}}},
+			},
+			want: map[string]bool{"CEO": true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &RollbackStruct{
+				tempFilters:  []*models.ModelFilter{{Service: constants.ContactsService, Key: "title"}},
+				filterValues: make(map[string]map[string]map[string]bool),
+			}
+			for _, plan := range test.plans {
+				r.collectFilterValues(constants.ContactsService, plan)
+			}
+			if got := r.filterValues[constants.ContactsService]["title"]; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("filter values = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+type rollbackChanges struct {
+	models.RecordChangesSvcRepo
+	changes []*models.ModelRecordChange
+}
+
+func (s *rollbackChanges) ListBySourceJob(sourceJob, service string, uuids []string) ([]*models.ModelRecordChange, error) {
+	return s.changes, nil
+}
+
+func (s *rollbackChanges) ListByOtherSources(sourceJob, service string, uuids []string) ([]*models.ModelRecordChange, error) {
+	return nil, nil
+}
+
+type rollbackCompanyRows struct {
+	models.PgCompanySvcRepo
+	rows map[string]*models.PgCompany
+}
+
+func (s *rollbackCompanyRows) ListByFilters(filters models.PgCompanyFilters) ([]*models.PgCompany, error) {
+	companies := make([]*models.PgCompany, 0)
+	for _, uuid := range filters.Uuids {
+		if row, ok := s.rows[uuid]; ok {
+			stored := *row
+			companies = append(companies, &stored)
+		}
+	}
+	return companies, nil
+}
+
+func (s *rollbackCompanyRows) DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error) {
+	return 0, nil
+}
+
+type rollbackCompanyIndex struct {
+	models.ElasticCompanySvcRepo
+}
+
+func (s *rollbackCompanyIndex) BulkDelete(uuids []string) (int64, error) {
+	return 0, nil
+}
+
+// rollbackCompanyService writes to the rows; beforeLock runs between reading
+// the plan and locking the rows, like a concurrent write would.
+type rollbackCompanyService struct {
+	companyService.CompanySvcRepo
+	rows       map[string]*models.PgCompany
+	beforeLock func()
+}
+
+func (s *rollbackCompanyService) UpsertLocked(pgCompanies []*models.PgCompany, resolve func(stored, company *models.PgCompany) bool) (utilities.UpsertStats, error) {
+	if s.beforeLock != nil {
+		s.beforeLock()
+	}
+	for _, company := range pgCompanies {
+		var stored *models.PgCompany
+		if row, ok := s.rows[company.UUID]; ok {
+			locked := *row
+			stored = &locked
+		}
+		if resolve(stored, company) {
+			s.rows[company.UUID] = company
+		}
+	}
+	return utilities.UpsertStats{}, nil
+}
+
+func TestRollbackChunkRestoresLockedRows(t *testing.T) {
+	imported := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	edited := imported.Add(time.Hour)
+	companies := &rollbackCompanyRows{rows: map[string]*models.PgCompany{
+		"a": {UUID: "a", City: "Delhi", Country: "India", SourceJob: "import", UpdatedAt: &imported},
+		"b": {UUID: "b", City: "Delhi", Country: "India", SourceJob: "import", UpdatedAt: &imported},
+	}}
+	service := &rollbackCompanyService{rows: companies.rows, beforeLock: func() {
+		companies.rows["b"] = &models.PgCompany{UUID: "b", City: "Mumbai", Country: "India", UpdatedAt: &edited}
+	}}
+	cityChange := map[string]utilities.FieldChange{"city": {Old: "Pune", New: "Delhi"}}
+	report := &bytes.Buffer{}
+	r := &RollbackStruct{
+		sourceJob:  "import",
+		provenance: utilities.Provenance{SourceJob: "rollback"},
+		recordChangesRepository: &rollbackChanges{changes: []*models.ModelRecordChange{
+			recordChange(1, "a", constants.ChangeUpdate, cityChange),
+			recordChange(2, "b", constants.ChangeUpdate, cityChange),
+		}},
+		pgCompanyRepository: companies,
+		esCompanyRepository: &rollbackCompanyIndex{},
+		companyService:      service,
+		filterValues:        make(map[string]map[string]map[string]bool),
+		csvWriter:           csv.NewWriter(report),
+	}
+	if err := r.rollbackChunk(constants.CompaniesService, []string{"a", "b"}); err != nil {
+		t.Fatalf("rollbackChunk() error = %v", err)
+	}
+	r.csvWriter.Flush()
+
+	if got := companies.rows["a"]; got.City != "Pune" || got.Country != "India" || got.SourceJob != "rollback" {
+		t.Errorf("a = %+v, want Pune restored by the rollback", got)
+	}
+	if got := companies.rows["b"]; got.City != "Mumbai" {
+		t.Errorf("b = %+v, want the concurrent Mumbai kept", got)
+	}
+	if want := (RollbackReport{Restored: 1, Conflicts: 1}); r.report != want {
+		t.Errorf("report = %+v, want %+v", r.report, want)
+	}
+	want := "company,a,restored,city,\ncompany,b,changed,,city\n"
+	if report.String() != want {
+		t.Errorf("outcomes = %q, want %q", report.String(), want)
+	}
+}
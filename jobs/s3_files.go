@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 	"vivek-ray/conf"
 	"vivek-ray/connections"
 	"vivek-ray/constants"
@@ -19,14 +21,58 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func InsertCsvToDb(fileStream *io.ReadCloser) error {
+var newUuidsHeaders = []string{"service", "uuid"}
+
+func writeNewUuids(csvWriter *csv.Writer, stats utilities.ImportStats) error {
+	if csvWriter == nil {
+		return nil
+	}
+	for _, uuid := range stats.Companies.InsertedUuids {
+		if err := csvWriter.Write([]string{constants.CompaniesService, uuid}); err != nil {
+			return err
+		}
+	}
+	for _, uuid := range stats.Contacts.InsertedUuids {
+		if err := csvWriter.Write([]string{constants.ContactsService, uuid}); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// InsertCsvToDb upserts the csv in batches and reports what every batch did.
+// When newUuidsWriter is set, the uuids of created records are written to it
+// as each batch completes. It stops before the next batch once ctx is
+// cancelled.
+func InsertCsvToDb(ctx context.Context, fileStream *io.ReadCloser, options utilities.UpsertOptions, newUuidsWriter *csv.Writer) (utilities.ImportReport, error) {
+	var report utilities.ImportReport
 	csvReader, batchUpsertService := csv.NewReader(*fileStream), commonService.NewBatchUpsertService()
 	headers, err := csvReader.Read()
 	if err != nil {
-		return err
+		return report, err
 	}
 	batchSize := conf.JobConfig.BatchSize
 	batch := make([]map[string]string, 0, batchSize)
+	options.Provenance.SourceRow = 1
+
+	processBatch := func() error {
+		if err := context.Cause(ctx); err != nil {
+			return err
+		}
+		stats, err := batchUpsertService.ProcessBatchUpsert(batch, options)
+		if err != nil {
+			return err
+		}
+		report.AddBatch(options.Provenance.SourceRow, len(batch), stats)
+		log.Info().Msgf("Rows %d-%d: contacts %d new, %d updated, %d unchanged; companies %d new, %d updated, %d unchanged",
+			options.Provenance.SourceRow, options.Provenance.SourceRow+int64(len(batch))-1,
+			stats.Contacts.Inserted, stats.Contacts.Updated, stats.Contacts.Unchanged,
+			stats.Companies.Inserted, stats.Companies.Updated, stats.Companies.Unchanged)
+		options.Provenance.SourceRow += int64(len(batch))
+		batch = batch[:0]
+		return writeNewUuids(newUuidsWriter, stats)
+	}
 
 	for {
 		row, err := csvReader.Read()
@@ -34,23 +80,24 @@ func InsertCsvToDb(fileStream *io.ReadCloser) error {
 			break
 		}
 		if err != nil {
-			return err
+			return report, err
 		}
 		batch = append(batch, utilities.CsvRowToMap(headers, row))
 		if len(batch) >= batchSize {
-			if err := batchUpsertService.ProcessBatchUpsert(batch); err != nil {
-				return err
+			if err := processBatch(); err != nil {
+				return report, err
 			}
-			batch = batch[:0]
 		}
 	}
 	if len(batch) > 0 {
-		return batchUpsertService.ProcessBatchUpsert(batch)
+		return report, processBatch()
 	}
-	return nil
+	return report, nil
 }
 
-func ProcessInsertCsvFile(job *models.ModelJobs) error {
+// ProcessInsertCsvFile imports the csv. A cancelled import keeps the batches
+// it finished and records them in the job response.
+func ProcessInsertCsvFile(ctx context.Context, job *models.ModelJobs) error {
 	var jobData utilities.InsertFileJobData
 	if err := json.Unmarshal(job.Data, &jobData); err != nil {
 		return err
@@ -58,8 +105,11 @@ func ProcessInsertCsvFile(job *models.ModelJobs) error {
 	if jobData.FileS3Bucket == "" {
 		jobData.FileS3Bucket = conf.S3StorageConfig.S3Bucket
 	}
+	if err := jobData.MergePolicy.Validate(&models.PgContact{}, &models.PgCompany{}); err != nil {
+		return err
+	}
 	fileStream, err := connections.S3Connection.ReadFileStream(
-		context.Background(),
+		ctx,
 		jobData.FileS3Bucket,
 		jobData.FileS3Key,
 	)
@@ -67,19 +117,127 @@ func ProcessInsertCsvFile(job *models.ModelJobs) error {
 		return err
 	}
 	defer fileStream.Close()
-	return InsertCsvToDb(&fileStream)
+	jobData.Provenance = utilities.Provenance{SourceJob: job.UUID, SourceFile: jobData.FileS3Key}
+
+	var report utilities.ImportReport
+	if !jobData.WriteNewUuids {
+		report, err = InsertCsvToDb(ctx, &fileStream, jobData.UpsertOptions, nil)
+	} else {
+		reader, writer := io.Pipe()
+		imported := make(chan struct{})
+		go func() {
+			defer close(imported)
+			csvWriter := csv.NewWriter(writer)
+			err := csvWriter.Write(newUuidsHeaders)
+			if err == nil {
+				report, err = InsertCsvToDb(ctx, &fileStream, jobData.UpsertOptions, csvWriter)
+			}
+			writer.CloseWithError(err)
+		}()
+
+		s3Key := fmt.Sprintf("%s/%s_new_uuids.csv", conf.S3StorageConfig.S3UploadFilePath, job.UUID)
+		// the upload is stopped by closing the pipe with the cancel cause,
+		// so that it can still abort the multipart upload
+		if err = connections.S3Connection.WriteFileStream(context.Background(), jobData.FileS3Bucket, s3Key, reader); err != nil {
+			// unblock the import goroutine if the upload gave up early
+			reader.CloseWithError(err)
+		} else {
+			job.AddS3Key(s3Key)
+		}
+		<-imported
+	}
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			job.AddImportReport(report)
+			job.AddMessage(fmt.Sprintf("cancelled after importing %d rows; they were kept and can be undone with a rollback_import job", report.Rows()))
+			return cause
+		}
+		return err
+	}
+
+	job.AddImportReport(report)
+	job.AddMessage(fmt.Sprintf(
+		"contacts: %d new, %d updated, %d unchanged; companies: %d new, %d updated, %d unchanged; most changed contact fields: %s",
+		report.Contacts.Inserted, report.Contacts.Updated, report.Contacts.Unchanged,
+		report.Companies.Inserted, report.Companies.Updated, report.Companies.Unchanged,
+		utilities.InlineIf(len(report.Contacts.FieldChanges) > 0, strings.Join(report.Contacts.TopFieldChanges(3), ", "), "none").(string),
+	))
+	return nil
 }
 
-func ExportContactsCsvToStream(writer *io.PipeWriter, vql utilities.VQLQuery) error {
-	csvWriter := csv.NewWriter(writer)
-	defer csvWriter.Flush()
+// exportColumns checks the selected columns against the fields of the
+// service's records and types them. Contact exports can also select the
+// fields of the contact's company as company.<field>. Headers default to the
+// column and can be renamed through labels.
+func exportColumns(service string, selectColumns []string, labels map[string]string) ([]exportColumn, error) {
+	columns := make([]exportColumn, 0, len(selectColumns))
+	headers := make(map[string]bool, len(selectColumns))
+	for _, column := range selectColumns {
+		columnType := models.ExportColumnType(service, column)
+		if columnType == nil {
+			return nil, constants.InvalidExportColumnError(column, service)
+		}
+		header := utilities.InlineIf(labels[column] != "", labels[column], column).(string)
+		if headers[header] {
+			return nil, constants.DuplicateExportHeaderError(header)
+		}
+		headers[header] = true
+		columns = append(columns, exportColumn{Header: header, Type: columnType})
+	}
+	return columns, nil
+}
 
+func recordValues(record any, selectColumns []string) []any {
+	values := make([]any, len(selectColumns))
+	for i, column := range selectColumns {
+		values[i] = utilities.GetFieldValue(record, column)
+	}
+	return values
+}
+
+// splitCompanyColumns separates the company.<field> columns of a contact
+// export from the contact's own columns.
+func splitCompanyColumns(selectColumns []string) (contactColumns []string, companyColumns []string) {
+	for _, column := range selectColumns {
+		if name, ok := strings.CutPrefix(column, constants.CompanyColumnPrefix); ok {
+			companyColumns = append(companyColumns, name)
+		} else {
+			contactColumns = append(contactColumns, column)
+		}
+	}
+	return contactColumns, companyColumns
+}
+
+func contactValues(contact *models.PgContact, company *models.PgCompany, selectColumns []string) []any {
+	values := make([]any, len(selectColumns))
+	for i, column := range selectColumns {
+		if name, ok := strings.CutPrefix(column, constants.CompanyColumnPrefix); ok {
+			if company != nil {
+				values[i] = utilities.GetFieldValue(company, name)
+			}
+			continue
+		}
+		values[i] = utilities.GetFieldValue(contact, column)
+	}
+	return values
+}
+
+// ExportContactsToStream writes the matching contacts. When company columns
+// are selected, each batch hydrates the companies of its contacts in one
+// query, like ListByFilters does for company_config.populate.
+func ExportContactsToStream(writer exportWriter, vql utilities.VQLQuery) error {
+	selectColumns := vql.SelectColumns
+	contactColumns, companyColumns := splitCompanyColumns(selectColumns)
+	vql.SelectColumns = utilities.InlineIf(len(contactColumns) > 0, contactColumns, []string{"uuid"}).([]string)
 	vql.CompanyConfig = nil
+	if len(companyColumns) > 0 {
+		vql.CompanyConfig = &utilities.CompanyConfig{
+			Populate:      true,
+			SelectColumns: append(companyColumns, "uuid"),
+		}
+	}
 	service := contactService.NewContactService([]*models.ModelFilter{})
 
-	if err := csvWriter.Write(vql.SelectColumns); err != nil {
-		return err
-	}
 	for {
 		contacts, err := service.ListByFilters(vql)
 		if err != nil {
@@ -90,25 +248,20 @@ func ExportContactsCsvToStream(writer *io.PipeWriter, vql utilities.VQLQuery) er
 		}
 
 		for _, contact := range contacts {
-			row := utilities.StructToCsvSlice(contact.PgContact, vql.SelectColumns)
-			if err := csvWriter.Write(row); err != nil {
+			if err := writer.Write(contactValues(contact.PgContact, contact.Company, selectColumns)); err != nil {
 				return err
 			}
 		}
 
 		vql.Cursor = contacts[len(contacts)-1].Cursor
-		csvWriter.Flush()
+		if err := writer.Flush(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func ExportCompaniesCsvToStream(writer *io.PipeWriter, vql utilities.VQLQuery) error {
-	csvWriter := csv.NewWriter(writer)
-	defer csvWriter.Flush()
-	if err := csvWriter.Write(vql.SelectColumns); err != nil {
-		return err
-	}
-
+func ExportCompaniesToStream(writer exportWriter, vql utilities.VQLQuery) error {
 	service := companyService.NewCompanyService([]*models.ModelFilter{})
 	for {
 		companies, err := service.ListByFilters(vql)
@@ -119,37 +272,99 @@ func ExportCompaniesCsvToStream(writer *io.PipeWriter, vql utilities.VQLQuery) e
 			break
 		}
 		for _, company := range companies {
-			row := utilities.StructToCsvSlice(company, vql.SelectColumns)
-			if err := csvWriter.Write(row); err != nil {
+			if err := writer.Write(recordValues(company, vql.SelectColumns)); err != nil {
 				return err
 			}
 		}
 		vql.Cursor = companies[len(companies)-1].Cursor
-		csvWriter.Flush()
+		if err := writer.Flush(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func ExportCsvToStream(writer *io.PipeWriter, jobData utilities.ExportFileJobData) error {
+// exportResult is what ExportToStream wrote: the headers and the row count.
+type exportResult struct {
+	Headers []string
+	Rows    int64
+}
+
+// ExportToStream writes the records matching the job's VQL, one batch at a
+// time, to the writer newWriter returns for the export's columns. It stops
+// between batches once ctx is cancelled.
+func ExportToStream(ctx context.Context, jobData utilities.ExportFileJobData, newWriter func(columns []exportColumn) (exportWriter, error)) (exportResult, error) {
+	var result exportResult
 	vql := jobData.VQL
 	vql.OrderBy = []utilities.FilterOrder{{OrderBy: "uuid", OrderDirection: "desc"}}
 	vql.Limit = conf.JobConfig.BatchSize
 
-	if len(vql.SelectColumns) == 0 {
-		return constants.SelectColumnsRequiredError
-	}
-
+	var export exportSource
 	switch jobData.Service {
 	case constants.ContactsService:
-		return ExportContactsCsvToStream(writer, vql)
+		export = ExportContactsToStream
 	case constants.CompaniesService:
-		return ExportCompaniesCsvToStream(writer, vql)
+		export = ExportCompaniesToStream
 	default:
-		return constants.InvalidServiceError
+		return result, constants.InvalidServiceError
+	}
+
+	var template []templateColumn
+	if jobData.Template != "" {
+		if len(vql.SelectColumns) > 0 || len(jobData.ColumnLabels) > 0 {
+			return result, constants.ExportTemplateConflictError
+		}
+		var err error
+		if template, vql.SelectColumns, err = loadExportTemplate(jobData.Service, jobData.Template); err != nil {
+			return result, err
+		}
+	}
+	if len(vql.SelectColumns) == 0 {
+		return result, constants.SelectColumnsRequiredError
+	}
+
+	columns, err := exportColumns(jobData.Service, vql.SelectColumns, jobData.ColumnLabels)
+	if err != nil {
+		return result, err
+	}
+	if template != nil {
+		// the template is applied by the source, so that parallel exports
+		// compute the columns in their workers
+		columns = templateExportColumns(template, columns)
+		source := export
+		export = func(writer exportWriter, vql utilities.VQLQuery) error {
+			return source(&templateExportWriter{exportWriter: writer, columns: template}, vql)
+		}
 	}
+	source := export
+	export = func(writer exportWriter, vql utilities.VQLQuery) error {
+		return source(&cancellableExportWriter{exportWriter: writer, ctx: ctx}, vql)
+	}
+	for _, column := range columns {
+		result.Headers = append(result.Headers, column.Header)
+	}
+	columnsWriter, err := newWriter(columns)
+	if err != nil {
+		return result, err
+	}
+	writer := &countingExportWriter{exportWriter: columnsWriter}
+	if jobData.Parallel {
+		err = exportInParallel(jobData, export, writer, vql)
+	} else {
+		err = export(writer, vql)
+	}
+	result.Rows = writer.rows
+	if err != nil {
+		return result, err
+	}
+	return result, writer.Close()
 }
 
-func ProcessExportCsvFile(job *models.ModelJobs) error {
+// ProcessExportCsvFile streams the export to S3, in parts if asked, and then
+// writes its manifest. When the export fails, the upload in progress is
+// aborted and the parts already uploaded are deleted, so a completed job
+// always has complete files and a failed or cancelled one none.
+func ProcessExportCsvFile(ctx context.Context, job *models.ModelJobs) error {
 	var jobData utilities.ExportFileJobData
 	if err := json.Unmarshal(job.Data, &jobData); err != nil {
 		return err
@@ -157,20 +372,78 @@ func ProcessExportCsvFile(job *models.ModelJobs) error {
 	if jobData.FileS3Bucket == "" {
 		jobData.FileS3Bucket = conf.S3StorageConfig.S3Bucket
 	}
-	reader, writer := io.Pipe()
-	go func() {
-		defer writer.Close()
-		if err := ExportCsvToStream(writer, jobData); err != nil {
-			log.Error().Err(err).Msg("Failed to export csv to stream")
-		}
-	}()
+	extension, err := exportExtension(jobData.Format)
+	if err != nil {
+		return err
+	}
+	if jobData.Compression != "" && jobData.Compression != constants.ExportCompressionGzip {
+		return constants.InvalidExportCompressionError(jobData.Compression)
+	}
+	if jobData.Format == constants.ExportFormatParquet && jobData.MaxBytesPerPart > 0 {
+		return constants.ParquetMaxBytesPerPartError
+	}
+	if jobData.Parallel && !jobData.Ordered && jobData.Bundle {
+		return constants.ParallelExportBundleError
+	}
+	startedAt := time.Now()
+	layout := newExportLayout(job.UUID, jobData, extension)
 
-	s3Key := fmt.Sprintf("%s/%s.csv", conf.S3StorageConfig.S3UploadFilePath, job.UUID)
+	var writer *partedExportWriter
+	result, err := ExportToStream(ctx, jobData, func(columns []exportColumn) (exportWriter, error) {
+		writer = newPartedExportWriter(jobData, layout, columns)
+		return writer, nil
+	})
+	if err != nil {
+		if writer != nil {
+			writer.Abort(err)
+		}
+		if cause := context.Cause(ctx); cause != nil {
+			job.AddMessage(fmt.Sprintf("cancelled after exporting %d rows; the files written so far were removed", result.Rows))
+			return cause
+		}
+		return err
+	}
 
-	if err := connections.S3Connection.WriteFileStream(context.Background(), jobData.FileS3Bucket, s3Key, reader); err != nil {
+	finishedAt := time.Now()
+	report := utilities.ExportReport{
+		Template:    jobData.Template,
+		Parts:       writer.Parts(),
+		ManifestKey: layout.prefix + ".manifest.json",
+		Format:      extension,
+		Compression: jobData.Compression,
+		Rows:        result.Rows,
+		Columns:     result.Headers,
+		StartedAt:   startedAt,
+		FinishedAt:  finishedAt,
+		DurationMs:  finishedAt.Sub(startedAt).Milliseconds(),
+	}
+	for _, part := range report.Parts {
+		report.Bytes += part.Bytes
+	}
+	if jobData.Bundle {
+		report.BundleKey = layout.bundleKey()
+	}
+	manifest, err := json.MarshalIndent(utilities.ExportManifest{
+		JobUUID:      job.UUID,
+		Service:      jobData.Service,
+		VQL:          jobData.VQL,
+		ExportReport: report,
+	}, "", "  ")
+	if err == nil {
+		err = connections.S3Connection.WriteFile(context.Background(), jobData.FileS3Bucket, report.ManifestKey, manifest)
+	}
+	if err != nil {
+		// without its manifest the export is incomplete, like a failed one
+		writer.Abort(err)
 		return err
 	}
 
-	job.AddS3Key(s3Key)
+	job.AddS3Key(utilities.InlineIf(report.BundleKey != "", report.BundleKey, layout.location()).(string))
+	job.AddExportReport(report)
+	job.AddMessage(fmt.Sprintf("exported %d %s records in %d parts (%d bytes) in %d ms", report.Rows, jobData.Service, len(report.Parts), report.Bytes, report.DurationMs))
+	if err := scheduleExportPurge(job.UUID); err != nil {
+		// a purge_exports sweep still removes the files
+		log.Error().Err(err).Msgf("Failed to schedule the removal of export %s", job.UUID)
+	}
 	return nil
 }
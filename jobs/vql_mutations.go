@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"vivek-ray/conf"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	companyService "vivek-ray/modules/companies/service"
+	contactService "vivek-ray/modules/contacts/service"
+	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
+)
+
+// vqlTarget adapts the contact and company services to the single loop that
+// update_by_vql and delete_by_vql run. A page holds the uuids of every hit,
+// including stale documents without a live Postgres row, which patch and
+// delete skip; the loop only ends once Elasticsearch returns no hits.
+type vqlTarget struct {
+	count    func(query utilities.VQLQuery) (int64, error)
+	page     func(query utilities.VQLQuery) (uuids []string, cursor []string, err error)
+	patch    func(uuids []string, patch utilities.RecordPatch) (utilities.UpsertStats, error)
+	delete   func(uuids []string, provenance utilities.Provenance) (int64, error)
+	validate func(fields map[string]json.RawMessage) error
+}
+
+func newVQLTarget(service string, tempFilters []*models.ModelFilter) (*vqlTarget, error) {
+	switch service {
+	case constants.ContactsService:
+		contacts := contactService.NewContactService(tempFilters)
+		return &vqlTarget{
+			count:  contacts.CountByFilters,
+			page:   contacts.ListUuidsByFilters,
+			patch:  contacts.PatchByUuids,
+			delete: contacts.DeleteByUuids,
+			validate: func(fields map[string]json.RawMessage) error {
+				_, err := utilities.ApplyPatch(&models.PgContact{}, fields)
+				return err
+			},
+		}, nil
+	case constants.CompaniesService:
+		companies := companyService.NewCompanyService(tempFilters)
+		return &vqlTarget{
+			count:  companies.CountByFilters,
+			page:   companies.ListUuidsByFilters,
+			patch:  companies.PatchByUuids,
+			delete: companies.DeleteByUuids,
+			validate: func(fields map[string]json.RawMessage) error {
+				_, err := utilities.ApplyPatch(&models.PgCompany{}, fields)
+				return err
+			},
+		}, nil
+	}
+	return nil, constants.InvalidServiceError
+}
+
+// ProcessVQLMutation runs update_by_vql and delete_by_vql. The matches are
+// counted first and checked against max_records; a dry run stops there.
+// Otherwise the job pages through the matches by uuid, like the exporters,
+// and patches or soft deletes each page in Postgres and Elasticsearch. It
+// stops between pages once ctx is cancelled; finished pages are kept.
+func ProcessVQLMutation(ctx context.Context, job *models.ModelJobs) error {
+	var jobData utilities.VQLMutationJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return err
+	}
+	isUpdate := job.JobType == constants.UpdateByVQL
+	if isUpdate && len(jobData.Patch) == 0 {
+		return constants.PatchRequiredError
+	}
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		return err
+	}
+	target, err := newVQLTarget(jobData.Service, tempFilters)
+	if err != nil {
+		return err
+	}
+	if isUpdate {
+		if err := target.validate(jobData.Patch); err != nil {
+			return err
+		}
+	}
+
+	vql := jobData.VQL
+	vql.CompanyConfig = nil
+	matched, err := target.count(vql)
+	if err != nil {
+		return err
+	}
+	maxRecords := utilities.InlineIf(jobData.MaxRecords > 0, jobData.MaxRecords, constants.DefaultVQLMutationLimit).(int64)
+	if matched > maxRecords {
+		return constants.VQLMatchLimitExceededError(matched, maxRecords)
+	}
+	if jobData.DryRun {
+		job.AddMessage(fmt.Sprintf("dry run: %d %s records match, nothing was changed", matched, jobData.Service))
+		return nil
+	}
+
+	serverTime := time.Now()
+	provenance := utilities.Provenance{SourceJob: job.UUID, IngestedAt: &serverTime}
+	patch := utilities.RecordPatch{Fields: jobData.Patch, Provenance: provenance}
+	var stats utilities.UpsertStats
+	var deleted int64
+
+	vql.OrderBy = []utilities.FilterOrder{{OrderBy: "uuid", OrderDirection: "desc"}}
+	vql.Limit = conf.JobConfig.BatchSize
+	vql.SelectColumns = []string{"uuid"}
+	for {
+		if cause := context.Cause(ctx); cause != nil {
+			if isUpdate {
+				job.AddMessage(fmt.Sprintf("cancelled after matching %d %s records: %d updated, %d unchanged", matched, jobData.Service, stats.Updated, stats.Unchanged))
+			} else {
+				job.AddMessage(fmt.Sprintf("cancelled after matching %d %s records: %d deleted", matched, jobData.Service, deleted))
+			}
+			return cause
+		}
+		uuids, cursor, err := target.page(vql)
+		if err != nil {
+			return err
+		}
+		if len(uuids) == 0 {
+			break
+		}
+		if isUpdate {
+			batchStats, err := target.patch(uuids, patch)
+			if err != nil {
+				return err
+			}
+			stats.Add(batchStats)
+		} else {
+			batchDeleted, err := target.delete(uuids, provenance)
+			if err != nil {
+				return err
+			}
+			deleted += batchDeleted
+		}
+		log.Info().Msgf("%s: processed %d %s records", job.JobType, len(uuids), jobData.Service)
+		vql.Cursor = cursor
+	}
+
+	if isUpdate {
+		job.AddMessage(fmt.Sprintf("matched %d %s records: %d updated, %d unchanged", matched, jobData.Service, stats.Updated, stats.Unchanged))
+	} else {
+		job.AddMessage(fmt.Sprintf("matched %d %s records: %d deleted", matched, jobData.Service, deleted))
+	}
+	return nil
+}
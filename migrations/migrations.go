@@ -0,0 +1,175 @@
+package migrations
+
+import (
+	"context"
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      Step
+	Down    Step
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+type Migrator struct {
+	db                         *bun.DB
+	indexRepository            models.ElasticIndexSvcRepo
+	schemaMigrationsRepository models.SchemaMigrationsSvcRepo
+}
+
+func NewMigrator() *Migrator {
+	return &Migrator{
+		db:                         connections.PgDBConnection.Client,
+		indexRepository:            models.ElasticIndexRepository(connections.ElasticsearchConnection.Client),
+		schemaMigrationsRepository: models.SchemaMigrationsRepository(connections.PgDBConnection.Client),
+	}
+}
+
+// applied only reads; a database without schema_migrations has nothing
+// applied. Up creates the table before it records the first migration.
+func (m *Migrator) applied() (map[int]*models.ModelSchemaMigration, error) {
+	exists, err := m.schemaMigrationsRepository.TableExists()
+	if err != nil || !exists {
+		return map[int]*models.ModelSchemaMigration{}, err
+	}
+	records, err := m.schemaMigrationsRepository.ListApplied()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]*models.ModelSchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// CurrentVersion returns the highest applied version, or 0 on a fresh database.
+func (m *Migrator) CurrentVersion() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for version := range applied {
+		current = max(current, version)
+	}
+	return current, nil
+}
+
+// Up applies every pending migration up to and including target. A target of
+// 0 means the latest version known to this binary.
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	if target <= 0 {
+		target = LatestVersion()
+	}
+	if err := m.schemaMigrationsRepository.EnsureTable(); err != nil {
+		return err
+	}
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for _, migration := range registry {
+		if migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if err := migration.Up(ctx, tx, m.indexRepository); err != nil {
+				return err
+			}
+			return models.SchemaMigrationsRepository(tx).Insert(&models.ModelSchemaMigration{
+				Version: migration.Version,
+				Name:    migration.Name,
+			})
+		})
+		if err != nil {
+			return constants.MigrationFailedError(migration.Version, migration.Name, err)
+		}
+		log.Info().Msgf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// Down reverts the most recently applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for i := len(registry) - 1; i >= 0 && steps > 0; i-- {
+		migration := registry[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := m.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if err := migration.Down(ctx, tx, m.indexRepository); err != nil {
+				return err
+			}
+			return models.SchemaMigrationsRepository(tx).Delete(migration.Version)
+		})
+		if err != nil {
+			return constants.MigrationFailedError(migration.Version, migration.Name, err)
+		}
+		log.Info().Msgf("Reverted migration %04d_%s", migration.Version, migration.Name)
+		steps--
+	}
+	return nil
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, 0, len(registry))
+	for _, migration := range registry {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			if record.AppliedAt != nil {
+				status.AppliedAt = record.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// CheckVersion fails when the database is behind the schema this binary was
+// built against. A database that is ahead only logs a warning, so an older
+// binary can keep serving during a rolling deploy. It never writes, so a
+// database that was never migrated is at version 0.
+func CheckVersion() error {
+	return NewMigrator().CheckVersion()
+}
+
+func (m *Migrator) CheckVersion() error {
+	current, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+	latest := LatestVersion()
+	if current < latest {
+		return constants.SchemaOutdatedError(current, latest)
+	}
+	if current > latest {
+		log.Warn().Msgf("Database schema is at version %d, newer than the %d this binary knows about", current, latest)
+	}
+	return nil
+}
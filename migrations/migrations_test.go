@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"vivek-ray/models"
+
+	"github.com/uptrace/bun"
+)
+
+type schemaMigrationsStore struct {
+	models.SchemaMigrationsSvcRepo
+	exists  bool
+	applied []*models.ModelSchemaMigration
+	created bool
+}
+
+func (s *schemaMigrationsStore) EnsureTable() error {
+	s.created = true
+	return nil
+}
+
+func (s *schemaMigrationsStore) TableExists() (bool, error) {
+	return s.exists, nil
+}
+
+func (s *schemaMigrationsStore) ListApplied() ([]*models.ModelSchemaMigration, error) {
+	return s.applied, nil
+}
+
+func TestCheckVersion(t *testing.T) {
+	upToDate := make([]*models.ModelSchemaMigration, 0, len(registry))
+	for _, migration := range registry {
+		upToDate = append(upToDate, &models.ModelSchemaMigration{Version: migration.Version, Name: migration.Name})
+	}
+	tests := []struct {
+		name    string
+		store   *schemaMigrationsStore
+		wantErr string
+	}{
+		{name: "missing table is version 0", store: &schemaMigrationsStore{}, wantErr: "ERR_SCHEMA_OUTDATED: database schema is at version 0"},
+		{name: "behind", store: &schemaMigrationsStore{exists: true, applied: upToDate[:1]}, wantErr: "ERR_SCHEMA_OUTDATED: database schema is at version 1"},
+		{name: "up to date", store: &schemaMigrationsStore{exists: true, applied: upToDate}},
+		{name: "ahead", store: &schemaMigrationsStore{exists: true, applied: append(upToDate, &models.ModelSchemaMigration{Version: LatestVersion() + 1})}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := (&Migrator{schemaMigrationsRepository: test.store}).CheckVersion()
+			if test.wantErr == "" && err != nil {
+				t.Errorf("CheckVersion() error = %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), test.wantErr)) {
+				t.Errorf("CheckVersion() error = %v, want %q", err, test.wantErr)
+			}
+			if test.store.created {
+				t.Errorf("CheckVersion() created schema_migrations")
+			}
+		})
+	}
+}
+
+func TestCoreTablesAreIrreversible(t *testing.T) {
+	err := registry[0].Down(context.Background(), bun.Tx{}, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "ERR_MIGRATION_IRREVERSIBLE") {
+		t.Errorf("reverting %04d_%s: error = %v, want a refusal", registry[0].Version, registry[0].Name, err)
+	}
+}
@@ -0,0 +1,99 @@
+CREATE TABLE IF NOT EXISTS companies (
+    id                      BIGSERIAL PRIMARY KEY,
+    uuid                    TEXT NOT NULL UNIQUE,
+    name                    TEXT,
+    employees_count         BIGINT,
+    industries              TEXT[],
+    keywords                TEXT[],
+    address                 TEXT,
+    annual_revenue          BIGINT,
+    total_funding           BIGINT,
+    technologies            TEXT[],
+    city                    TEXT,
+    state                   TEXT,
+    country                 TEXT,
+    linkedin_url            TEXT,
+    website                 TEXT,
+    normalized_domain       TEXT,
+    facebook_url            TEXT,
+    twitter_url             TEXT,
+    company_name_for_emails TEXT,
+    phone_number            TEXT,
+    latest_funding          TEXT,
+    latest_funding_amount   BIGINT,
+    last_raised_at          TEXT,
+    created_at              TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at              TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    deleted_at              TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS companies_name_idx ON companies (name);
+CREATE INDEX IF NOT EXISTS companies_normalized_domain_idx ON companies (normalized_domain);
+
+CREATE TABLE IF NOT EXISTS contacts (
+    id                BIGSERIAL PRIMARY KEY,
+    uuid              TEXT UNIQUE,
+    first_name        TEXT,
+    last_name         TEXT,
+    company_id        TEXT,
+    email             TEXT,
+    title             TEXT,
+    departments       TEXT[],
+    mobile_phone      TEXT,
+    email_status      TEXT,
+    seniority         TEXT,
+    city              TEXT,
+    state             TEXT,
+    country           TEXT,
+    linkedin_url      TEXT,
+    facebook_url      TEXT,
+    twitter_url       TEXT,
+    website           TEXT,
+    work_direct_phone TEXT,
+    home_phone        TEXT,
+    other_phone       TEXT,
+    stage             TEXT,
+    created_at        TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at        TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    deleted_at        TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS contacts_company_id_idx ON contacts (company_id);
+CREATE INDEX IF NOT EXISTS contacts_email_idx ON contacts (email);
+CREATE INDEX IF NOT EXISTS contacts_mobile_phone_idx ON contacts (mobile_phone);
+
+CREATE TABLE IF NOT EXISTS filters (
+    id             BIGSERIAL PRIMARY KEY,
+    key            TEXT,
+    service        TEXT,
+    filter_type    TEXT NOT NULL,
+    display_name   TEXT NOT NULL,
+    direct_derived BOOLEAN,
+    active         BOOLEAN NOT NULL DEFAULT TRUE,
+    deleted_at     TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS filters_service_key_idx ON filters (service, key);
+
+CREATE TABLE IF NOT EXISTS filters_data (
+    id            BIGSERIAL PRIMARY KEY,
+    uuid          TEXT NOT NULL UNIQUE,
+    filter_key    TEXT NOT NULL,
+    service       TEXT NOT NULL,
+    display_value TEXT NOT NULL,
+    value         TEXT,
+    deleted_at    TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS filters_data_service_filter_key_idx ON filters_data (service, filter_key);
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id             BIGSERIAL PRIMARY KEY,
+    uuid           TEXT NOT NULL UNIQUE,
+    job_type       TEXT NOT NULL,
+    data           JSONB DEFAULT '{}',
+    status         TEXT NOT NULL DEFAULT 'open',
+    job_response   JSONB DEFAULT '{}',
+    retry_count    INTEGER NOT NULL DEFAULT 0,
+    retry_interval INTEGER NOT NULL DEFAULT 30,
+    run_after      TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    updated_at     TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status);
@@ -0,0 +1,2 @@
+ALTER TABLE contacts DROP COLUMN IF EXISTS source_date;
+ALTER TABLE companies DROP COLUMN IF EXISTS source_date;
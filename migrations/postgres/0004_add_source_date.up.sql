@@ -0,0 +1,2 @@
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS source_date TIMESTAMPTZ;
+ALTER TABLE companies ADD COLUMN IF NOT EXISTS source_date TIMESTAMPTZ;
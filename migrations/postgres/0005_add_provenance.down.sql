@@ -0,0 +1,17 @@
+DROP INDEX IF EXISTS contacts_source_job_idx;
+DROP INDEX IF EXISTS companies_source_job_idx;
+
+ALTER TABLE contacts
+    DROP COLUMN IF EXISTS source_job,
+    DROP COLUMN IF EXISTS source_api_key,
+    DROP COLUMN IF EXISTS source_file,
+    DROP COLUMN IF EXISTS source_row,
+    DROP COLUMN IF EXISTS ingested_at,
+    DROP COLUMN IF EXISTS field_sources;
+ALTER TABLE companies
+    DROP COLUMN IF EXISTS source_job,
+    DROP COLUMN IF EXISTS source_api_key,
+    DROP COLUMN IF EXISTS source_file,
+    DROP COLUMN IF EXISTS source_row,
+    DROP COLUMN IF EXISTS ingested_at,
+    DROP COLUMN IF EXISTS field_sources;
@@ -0,0 +1,17 @@
+ALTER TABLE contacts
+    ADD COLUMN IF NOT EXISTS source_job TEXT,
+    ADD COLUMN IF NOT EXISTS source_api_key TEXT,
+    ADD COLUMN IF NOT EXISTS source_file TEXT,
+    ADD COLUMN IF NOT EXISTS source_row BIGINT,
+    ADD COLUMN IF NOT EXISTS ingested_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS field_sources JSONB;
+ALTER TABLE companies
+    ADD COLUMN IF NOT EXISTS source_job TEXT,
+    ADD COLUMN IF NOT EXISTS source_api_key TEXT,
+    ADD COLUMN IF NOT EXISTS source_file TEXT,
+    ADD COLUMN IF NOT EXISTS source_row BIGINT,
+    ADD COLUMN IF NOT EXISTS ingested_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS field_sources JSONB;
+
+CREATE INDEX IF NOT EXISTS contacts_source_job_idx ON contacts (source_job);
+CREATE INDEX IF NOT EXISTS companies_source_job_idx ON companies (source_job);
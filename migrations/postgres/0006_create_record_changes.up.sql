@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS record_changes (
+    id          BIGSERIAL PRIMARY KEY,
+    service     TEXT NOT NULL,
+    record_uuid TEXT NOT NULL,
+    change_type TEXT NOT NULL,
+    changes     JSONB NOT NULL DEFAULT '{}',
+    actor       TEXT NOT NULL,
+    source_job  TEXT,
+    changed_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS record_changes_record_idx ON record_changes (service, record_uuid, changed_at);
+CREATE INDEX IF NOT EXISTS record_changes_source_job_idx ON record_changes (source_job);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS contacts_deleted_at_idx;
+DROP INDEX IF EXISTS companies_deleted_at_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS contacts_deleted_at_idx ON contacts (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS companies_deleted_at_idx ON companies (deleted_at) WHERE deleted_at IS NOT NULL;
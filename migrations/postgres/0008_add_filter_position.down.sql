@@ -0,0 +1 @@
+ALTER TABLE filters DROP COLUMN IF EXISTS position;
@@ -0,0 +1,2 @@
+ALTER TABLE filters ADD COLUMN IF NOT EXISTS position INT NOT NULL DEFAULT 0;
+UPDATE filters SET position = id WHERE position = 0;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS export_templates (
+    id         BIGSERIAL PRIMARY KEY,
+    service    TEXT NOT NULL,
+    name       TEXT NOT NULL,
+    columns    JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS export_templates_service_name_idx ON export_templates (service, name) WHERE deleted_at IS NULL;
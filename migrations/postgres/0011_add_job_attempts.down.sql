@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS jobs_status_run_after_idx;
+UPDATE jobs SET status = 'failed' WHERE status = 'dead';
+ALTER TABLE jobs DROP COLUMN IF EXISTS attempts;
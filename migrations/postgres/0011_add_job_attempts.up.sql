@@ -0,0 +1,8 @@
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+
+-- jobs that already ran count as one attempt; failed jobs without retries
+-- left are dead
+UPDATE jobs SET attempts = 1 WHERE status IN ('completed', 'failed', 'cancelled');
+UPDATE jobs SET status = 'dead' WHERE status = 'failed' AND retry_count = 0;
+
+CREATE INDEX IF NOT EXISTS jobs_status_run_after_idx ON jobs (status, run_after);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS jobs_worker_id_idx;
+ALTER TABLE jobs DROP COLUMN IF EXISTS heartbeat_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS worker_id;
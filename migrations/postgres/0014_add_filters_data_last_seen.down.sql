@@ -0,0 +1 @@
+ALTER TABLE filters_data DROP COLUMN IF EXISTS last_seen_at;
@@ -0,0 +1,3 @@
+-- every upsert of a filter value touches last_seen_at, so rebuild_filter_data
+-- can prune the values it did not see without holding them all in memory
+ALTER TABLE filters_data ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,10 @@
+-- the progress of an interrupted reindex, one row per service, so that it can
+-- be resumed from any host
+CREATE TABLE IF NOT EXISTS reindex_checkpoints (
+    service    TEXT PRIMARY KEY,
+    index_name TEXT NOT NULL,
+    last_id    BIGINT NOT NULL DEFAULT 0,
+    indexed    BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,121 @@
+package migrations
+
+import (
+	"vivek-ray/constants"
+)
+
+// registry lists every schema change in the order it must be applied.
+// Versions are never renumbered or edited once released; add a new entry
+// instead. Index mappings are frozen per migration, so a change to the live
+// mappings in examples needs a putMapping entry of its own.
+var registry = []Migration{
+	{
+		Version: 1,
+		Name:    "create_core_tables",
+		Up:      sqlFile("0001_create_core_tables.up.sql"),
+		// the core tables are adopted from existing deployments as is
+		Down: irreversible("the core tables hold data that predates the migrations"),
+	},
+	{
+		Version: 2,
+		Name:    "create_contacts_index",
+		Up:      createIndex(constants.ContactIndex, mappingFile("0002_contacts_index.json")),
+		Down:    dropIndex(constants.ContactIndex),
+	},
+	{
+		Version: 3,
+		Name:    "create_companies_index",
+		Up:      createIndex(constants.CompanyIndex, mappingFile("0003_companies_index.json")),
+		Down:    dropIndex(constants.CompanyIndex),
+	},
+	{
+		Version: 4,
+		Name:    "add_source_date",
+		Up:      sqlFile("0004_add_source_date.up.sql"),
+		Down:    sqlFile("0004_add_source_date.down.sql"),
+	},
+	{
+		Version: 5,
+		Name:    "add_provenance",
+		Up: chain(
+			sqlFile("0005_add_provenance.up.sql"),
+			putMapping(constants.ContactIndex, provenanceMapping),
+			putMapping(constants.CompanyIndex, provenanceMapping),
+		),
+		Down: sqlFile("0005_add_provenance.down.sql"),
+	},
+	{
+		Version: 6,
+		Name:    "create_record_changes",
+		Up:      sqlFile("0006_create_record_changes.up.sql"),
+		Down:    sqlFile("0006_create_record_changes.down.sql"),
+	},
+	{
+		Version: 7,
+		Name:    "index_deleted_at",
+		Up:      sqlFile("0007_index_deleted_at.up.sql"),
+		Down:    sqlFile("0007_index_deleted_at.down.sql"),
+	},
+	{
+		Version: 8,
+		Name:    "add_filter_position",
+		Up:      sqlFile("0008_add_filter_position.up.sql"),
+		Down:    sqlFile("0008_add_filter_position.down.sql"),
+	},
+	{
+		Version: 9,
+		Name:    "create_export_templates",
+		Up:      sqlFile("0009_create_export_templates.up.sql"),
+		Down:    sqlFile("0009_create_export_templates.down.sql"),
+	},
+	{
+		Version: 10,
+		Name:    "add_job_cancellation",
+		Up:      sqlFile("0010_add_job_cancellation.up.sql"),
+		Down:    sqlFile("0010_add_job_cancellation.down.sql"),
+	},
+	{
+		Version: 11,
+		Name:    "add_job_attempts",
+		Up:      sqlFile("0011_add_job_attempts.up.sql"),
+		Down:    sqlFile("0011_add_job_attempts.down.sql"),
+	},
+	{
+		Version: 12,
+		Name:    "add_job_leases",
+		Up:      sqlFile("0012_add_job_leases.up.sql"),
+		Down:    sqlFile("0012_add_job_leases.down.sql"),
+	},
+	{
+		Version: 13,
+		Name:    "map_updated_at",
+		Up: chain(
+			putMapping(constants.ContactIndex, updatedAtMapping),
+			putMapping(constants.CompanyIndex, updatedAtMapping),
+		),
+		// mapped fields cannot be dropped, see putMapping
+		Down: chain(),
+	},
+	{
+		Version: 14,
+		Name:    "add_filters_data_last_seen",
+		Up:      sqlFile("0014_add_filters_data_last_seen.up.sql"),
+		Down:    sqlFile("0014_add_filters_data_last_seen.down.sql"),
+	},
+	{
+		Version: 15,
+		Name:    "create_reindex_checkpoints",
+		Up:      sqlFile("0015_create_reindex_checkpoints.up.sql"),
+		Down:    sqlFile("0015_create_reindex_checkpoints.down.sql"),
+	},
+}
+
+var provenanceMapping = []byte(`{"properties": {"source_job": {"type": "keyword"}, "ingested_at": {"type": "date"}}}`)
+
+// updatedAtMapping maps the updated_at that reconcile compares against
+// Postgres; indices created before it relied on dynamic mapping.
+var updatedAtMapping = []byte(`{"properties": {"updated_at": {"type": "date"}}}`)
+
+func LatestVersion() int {
+	return registry[len(registry)-1].Version
+}
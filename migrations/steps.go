@@ -0,0 +1,118 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+)
+
+//go:embed postgres/*.sql
+var postgresFiles embed.FS
+
+// elasticsearchFiles holds the mappings as they were when their migration was
+// released; the live mappings in examples keep changing for reindexing.
+//
+//go:embed elasticsearch/*.json
+var elasticsearchFiles embed.FS
+
+// Step runs one direction of a migration. Postgres work goes through tx so
+// it commits atomically with the schema_migrations record; Elasticsearch work
+// cannot be rolled back and must therefore be idempotent.
+type Step func(ctx context.Context, tx bun.Tx, indexRepository models.ElasticIndexSvcRepo) error
+
+func sqlFile(name string) Step {
+	return func(ctx context.Context, tx bun.Tx, _ models.ElasticIndexSvcRepo) error {
+		query, err := postgresFiles.ReadFile("postgres/" + name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, string(query))
+		return err
+	}
+}
+
+// createIndex creates a versioned index from mapping and points alias at it.
+// An existing alias or legacy index with the same name is adopted as is.
+func createIndex(alias string, mapping []byte) Step {
+	return func(_ context.Context, _ bun.Tx, indexRepository models.ElasticIndexSvcRepo) error {
+		exists, err := indexRepository.Exists(alias)
+		if err != nil {
+			return err
+		}
+		if exists {
+			log.Info().Msgf("Index %s already exists, skipping creation", alias)
+			return nil
+		}
+
+		index := fmt.Sprintf("%s_v%s", alias, time.Now().UTC().Format("20060102150405"))
+		if err := indexRepository.Create(index, mapping); err != nil {
+			return err
+		}
+		return indexRepository.UpdateAliases([]map[string]any{
+			{"add": map[string]any{"index": index, "alias": alias}},
+		})
+	}
+}
+
+// mappingFile reads a frozen mapping. The names are fixed in the registry, so
+// a missing file is a build mistake.
+func mappingFile(name string) []byte {
+	mapping, err := elasticsearchFiles.ReadFile("elasticsearch/" + name)
+	if err != nil {
+		panic(err)
+	}
+	return mapping
+}
+
+func dropIndex(alias string) Step {
+	return func(_ context.Context, _ bun.Tx, indexRepository models.ElasticIndexSvcRepo) error {
+		indices, err := indexRepository.GetAliasIndices(alias)
+		if err != nil {
+			return err
+		}
+		if len(indices) == 0 {
+			exists, err := indexRepository.Exists(alias)
+			if err != nil || !exists {
+				return err
+			}
+			indices = []string{alias}
+		}
+		return indexRepository.Delete(indices)
+	}
+}
+
+// putMapping adds fields to the index behind alias. Elasticsearch cannot drop
+// mapped fields, so the matching down step is a no-op and the fields simply
+// stay unused.
+func putMapping(alias string, mapping []byte) Step {
+	return func(_ context.Context, _ bun.Tx, indexRepository models.ElasticIndexSvcRepo) error {
+		return indexRepository.PutMapping(alias, mapping)
+	}
+}
+
+// irreversible refuses to revert a migration whose objects may predate it,
+// such as tables adopted from an existing deployment.
+func irreversible(reason string) Step {
+	return func(context.Context, bun.Tx, models.ElasticIndexSvcRepo) error {
+		return constants.MigrationIrreversibleError(reason)
+	}
+}
+
+// chain runs steps in order, e.g. a Postgres change followed by the matching
+// Elasticsearch mapping update.
+func chain(steps ...Step) Step {
+	return func(ctx context.Context, tx bun.Tx, indexRepository models.ElasticIndexSvcRepo) error {
+		for _, step := range steps {
+			if err := step(ctx, tx, indexRepository); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
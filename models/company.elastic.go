@@ -26,12 +26,16 @@ type ElasticCompany struct {
 	Website          string   `json:"website"`           // text search
 	NormalizedDomain string   `json:"normalized_domain"` // text search
 
+	SourceJob  string     `json:"source_job,omitempty"`  // keyword search
+	IngestedAt *time.Time `json:"ingested_at,omitempty"` // date search
+
 	CreatedAt time.Time `json:"created_at"` // date search
+	UpdatedAt time.Time `json:"updated_at"` // date search
 }
 
 func ElasticCompanyFromRawData(company *PgCompany) *ElasticCompany {
 	serverTime := time.Now()
-	return &ElasticCompany{
+	esCompany := &ElasticCompany{
 		UUID:             company.UUID,
 		Name:             company.Name,
 		EmployeesCount:   company.EmployeesCount,
@@ -48,7 +52,17 @@ func ElasticCompanyFromRawData(company *PgCompany) *ElasticCompany {
 		Website:          company.Website,
 		NormalizedDomain: company.NormalizedDomain,
 		CreatedAt:        serverTime,
+		UpdatedAt:        serverTime,
+		SourceJob:        company.SourceJob,
+		IngestedAt:       company.IngestedAt,
+	}
+	if company.CreatedAt != nil {
+		esCompany.CreatedAt = *company.CreatedAt
+	}
+	if company.UpdatedAt != nil {
+		esCompany.UpdatedAt = *company.UpdatedAt
 	}
+	return esCompany
 }
 
 type ElasticCompanySearchHit struct {
@@ -24,7 +24,11 @@ func ElasticCompanyRepository(client *elasticsearch.Client) ElasticCompanySvcRep
 type ElasticCompanySvcRepo interface {
 	ListByQueryMap(query map[string]any) ([]*ElasticCompanySearchHit, error)
 	CountByQueryMap(query map[string]any) (int64, error)
+	AggregateTerms(query map[string]any) ([]utilities.TermsBucket, error)
 	BulkUpsert(companies []*ElasticCompany) (int64, error)
+	BulkUpsertToIndex(index string, companies []*ElasticCompany) (int64, error)
+	BulkDelete(uuids []string) (int64, error)
+	BulkDeleteFromIndex(index string, uuids []string) (int64, error)
 }
 
 func (t *ElasticCompanyStruct) ListByQueryMap(query map[string]any) ([]*ElasticCompanySearchHit, error) {
@@ -84,12 +88,46 @@ func (t *ElasticCompanyStruct) CountByQueryMap(query map[string]any) (int64, err
 	return countResponse.Count, nil
 }
 
+// AggregateTerms runs a search built by VQLQuery.ToTermsAggregation and
+// returns its buckets.
+func (t *ElasticCompanyStruct) AggregateTerms(query map[string]any) ([]utilities.TermsBucket, error) {
+	queryJson, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.ElasticClient.Search(
+		t.ElasticClient.Search.WithIndex(constants.CompanyIndex),
+		t.ElasticClient.Search.WithBody(bytes.NewReader(queryJson)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return nil, constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+
+	var aggregationResponse utilities.ElasticTermsAggregation
+	if err := json.NewDecoder(response.Body).Decode(&aggregationResponse); err != nil {
+		return nil, err
+	}
+
+	return aggregationResponse.Aggregations.Values.Buckets, nil
+}
+
 func (t *ElasticCompanyStruct) BulkUpsert(companies []*ElasticCompany) (int64, error) {
+	return t.BulkUpsertToIndex(constants.CompanyIndex, companies)
+}
+
+func (t *ElasticCompanyStruct) BulkUpsertToIndex(index string, companies []*ElasticCompany) (int64, error) {
 	var buf bytes.Buffer
 	for _, company := range companies {
 		meta := map[string]any{
 			"index": map[string]any{
-				"_index": constants.CompanyIndex,
+				"_index": index,
 				"_id":    company.UUID,
 			},
 		}
@@ -110,5 +148,57 @@ func (t *ElasticCompanyStruct) BulkUpsert(companies []*ElasticCompany) (int64, e
 		return 0, constants.ElasticsearchBulkError(response.StatusCode, string(bodyBytes))
 	}
 
+	var bulkResponse utilities.ElasticBulkResponse
+	if err := json.NewDecoder(response.Body).Decode(&bulkResponse); err != nil {
+		return 0, err
+	}
+	if bulkResponse.Errors {
+		return 0, constants.ElasticsearchBulkError(response.StatusCode, bulkResponse.FirstError())
+	}
+
 	return int64(len(companies)), nil
 }
+
+func (t *ElasticCompanyStruct) BulkDelete(uuids []string) (int64, error) {
+	return t.BulkDeleteFromIndex(constants.CompanyIndex, uuids)
+}
+
+func (t *ElasticCompanyStruct) BulkDeleteFromIndex(index string, uuids []string) (int64, error) {
+	if len(uuids) == 0 {
+		return 0, nil
+	}
+	var buf bytes.Buffer
+	for _, uuid := range uuids {
+		meta := map[string]any{
+			"delete": map[string]any{
+				"_index": index,
+				"_id":    uuid,
+			},
+		}
+		if utilities.AddToBuffer(&buf, meta) != nil {
+			log.Error().Msgf("Failed to add company delete to buffer: %v", uuid)
+			continue
+		}
+	}
+
+	response, err := t.ElasticClient.Bulk(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return 0, constants.ElasticsearchBulkError(response.StatusCode, string(bodyBytes))
+	}
+
+	var bulkResponse utilities.ElasticBulkResponse
+	if err := json.NewDecoder(response.Body).Decode(&bulkResponse); err != nil {
+		return 0, err
+	}
+	if bulkResponse.Errors {
+		return 0, constants.ElasticsearchBulkError(response.StatusCode, bulkResponse.FirstError())
+	}
+
+	return int64(len(uuids)), nil
+}
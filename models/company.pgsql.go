@@ -39,6 +39,15 @@ type PgCompany struct {
 	LatestFundingAmount  int64  `bun:"latest_funding_amount" json:"latest_funding_amount,omitempty"`
 	LastRaisedAt         string `bun:"last_raised_at" json:"last_raised_at,omitempty"`
 
+	SourceDate *time.Time `bun:"source_date,nullzero" json:"source_date,omitempty"`
+
+	SourceJob    string                          `bun:"source_job,nullzero" json:"source_job,omitempty"`
+	SourceAPIKey string                          `bun:"source_api_key,nullzero" json:"source_api_key,omitempty"`
+	SourceFile   string                          `bun:"source_file,nullzero" json:"source_file,omitempty"`
+	SourceRow    int64                           `bun:"source_row,nullzero" json:"source_row,omitempty"`
+	IngestedAt   *time.Time                      `bun:"ingested_at,nullzero" json:"ingested_at,omitempty"`
+	FieldSources map[string]utilities.Provenance `bun:"field_sources,type:jsonb,nullzero" json:"field_sources,omitempty"`
+
 	CreatedAt *time.Time `bun:"created_at,nullzero,default:current_timestamp" json:"created_at,omitempty"`
 	UpdatedAt *time.Time `bun:"updated_at,nullzero,default:current_timestamp" json:"updated_at,omitempty"`
 	DeletedAt *time.Time `bun:"deleted_at,nullzero" json:"deleted_at,omitempty"`
@@ -79,8 +88,9 @@ func PgCompanyFromRawData(row map[string]string) *PgCompany {
 		LatestFundingAmount:  utilities.StringToInt64(row["latest_funding_amount"]),
 		LastRaisedAt:         row["last_raised_at"],
 
-		CreatedAt: &serverTime,
-		UpdatedAt: &serverTime,
+		SourceDate: utilities.ParseSourceDate(row["source_date"]),
+		CreatedAt:  &serverTime,
+		UpdatedAt:  &serverTime,
 	}
 }
 
@@ -88,3 +98,37 @@ func (c *PgCompany) SetDB(db *bun.DB) *PgCompany {
 	c.db = db
 	return c
 }
+
+func (c *PgCompany) Provenance() utilities.Provenance {
+	return utilities.Provenance{
+		SourceJob:    c.SourceJob,
+		SourceAPIKey: c.SourceAPIKey,
+		SourceFile:   c.SourceFile,
+		SourceRow:    c.SourceRow,
+		IngestedAt:   c.IngestedAt,
+	}
+}
+
+func (c *PgCompany) SetProvenance(provenance utilities.Provenance) *PgCompany {
+	c.SourceJob = provenance.SourceJob
+	c.SourceAPIKey = provenance.SourceAPIKey
+	c.SourceFile = provenance.SourceFile
+	c.SourceRow = provenance.SourceRow
+	c.IngestedAt = provenance.IngestedAt
+	return c
+}
+
+// Lineage resolves the provenance of every populated field: fields listed in
+// FieldSources were last written by an earlier source, all others by the
+// record level one.
+func (c *PgCompany) Lineage() map[string]utilities.Provenance {
+	lineage := make(map[string]utilities.Provenance)
+	for _, field := range utilities.PopulatedMergeFields(c) {
+		if source, ok := c.FieldSources[field]; ok {
+			lineage[field] = source
+		} else {
+			lineage[field] = c.Provenance()
+		}
+	}
+	return lineage
+}
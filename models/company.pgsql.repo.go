@@ -2,6 +2,9 @@ package models
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"time"
 	"vivek-ray/constants"
 	"vivek-ray/utilities"
 
@@ -62,17 +65,26 @@ func (f *PgCompanyFilters) ToQuery(query *bun.SelectQuery) *bun.SelectQuery {
 type PgCompanySvcRepo interface {
 	GetFiltersByQuery(query FiltersDataQuery) ([]*PgCompany, error)
 	ListByFilters(filters PgCompanyFilters) ([]*PgCompany, error)
-	BulkUpsert(companies []*PgCompany) (int64, error)
+	BulkUpsert(companies []*PgCompany, merge func(stored, incoming *PgCompany) bool) (utilities.UpsertStats, error)
+	ListAfterId(afterId uint64, updatedAfter *time.Time, limit int) ([]*PgCompany, error)
+	Count() (int64, error)
+	DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error)
+	PurgeDeleted(before time.Time, limit int) (int64, error)
+	CarriedValues(column string, values []string, isArray bool) ([]string, error)
+	Update(uuid string, expectedUpdatedAt *time.Time, patch func(stored *PgCompany) (*PgCompany, []string, error)) (*PgCompany, error)
 }
 
 func (t *PgCompanyStruct) GetFiltersByQuery(query FiltersDataQuery) ([]*PgCompany, error) {
 	var companies []*PgCompany
 
 	// fetch only filter column
-	queryBuilder := t.PgDbClient.NewSelect().Model(&companies)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&companies).Where("deleted_at IS NULL")
 	if query.SearchText != "" {
 		queryBuilder = queryBuilder.Where("? ILIKE ?", bun.Ident(query.FilterKey), "%"+query.SearchText+"%")
 	}
+	if query.Prefix != "" {
+		queryBuilder = queryBuilder.Where("? ILIKE ?", bun.Ident(query.FilterKey), utilities.EscapeLike(query.Prefix)+"%")
+	}
 
 	query.Limit = utilities.InlineIf(query.Limit > 0, query.Limit, constants.DefaultPageSize).(int)
 	if query.Page > 0 {
@@ -88,7 +100,7 @@ func (t *PgCompanyStruct) ListByFilters(filters PgCompanyFilters) ([]*PgCompany,
 		return companies, nil
 	}
 
-	queryBuilder := t.PgDbClient.NewSelect().Model(&companies)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&companies).Where("deleted_at IS NULL")
 	filters.ToQuery(queryBuilder)
 
 	if filters.Page > 0 && filters.Limit > 0 {
@@ -98,33 +110,249 @@ func (t *PgCompanyStruct) ListByFilters(filters PgCompanyFilters) ([]*PgCompany,
 	return companies, err
 }
 
-func (t *PgCompanyStruct) BulkUpsert(companies []*PgCompany) (int64, error) {
-	_, err := t.PgDbClient.NewInsert().
-		Model(&companies).
-		On("CONFLICT(uuid) DO UPDATE").
-		Set("name = EXCLUDED.name").
-		Set("normalized_domain = EXCLUDED.normalized_domain").
-		Set("employees_count = EXCLUDED.employees_count").
-		Set("industries = EXCLUDED.industries").
-		Set("keywords = EXCLUDED.keywords").
-		Set("address = EXCLUDED.address").
-		Set("annual_revenue = EXCLUDED.annual_revenue").
-		Set("total_funding = EXCLUDED.total_funding").
-		Set("technologies = EXCLUDED.technologies").
-		Set("city = EXCLUDED.city").
-		Set("state = EXCLUDED.state").
-		Set("country = EXCLUDED.country").
-		Set("linkedin_url = EXCLUDED.linkedin_url").
-		Set("website = EXCLUDED.website").
-		Set("facebook_url = EXCLUDED.facebook_url").
-		Set("twitter_url = EXCLUDED.twitter_url").
-		Set("company_name_for_emails = EXCLUDED.company_name_for_emails").
-		Set("phone_number = EXCLUDED.phone_number").
-		Set("latest_funding = EXCLUDED.latest_funding").
-		Set("latest_funding_amount = EXCLUDED.latest_funding_amount").
-		Set("last_raised_at = EXCLUDED.last_raised_at").
-		Set("updated_at = EXCLUDED.updated_at").
+// BulkUpsert writes the records and, in the same transaction, appends a
+// field-level diff against the stored rows to record_changes. The returned
+// stats tell inserts, updates and no-op writes apart. Upserting a soft
+// deleted record revives it and counts as an insert. When merge is not nil it
+// is called for every incoming record with its stored row, locked for the rest
+// of the transaction, or nil when there is no live row, before anything is
+// written; the records it returns false for are left out of the write.
+func (t *PgCompanyStruct) BulkUpsert(companies []*PgCompany, merge func(stored, incoming *PgCompany) bool) (utilities.UpsertStats, error) {
+	stats := utilities.UpsertStats{FieldChanges: make(map[string]int64), UpdatedFields: make(map[string][]string)}
+	if len(companies) == 0 {
+		return stats, nil
+	}
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		uuids := make([]string, 0, len(companies))
+		for _, company := range companies {
+			uuids = append(uuids, company.UUID)
+		}
+		existing := make([]*PgCompany, 0)
+		if err := tx.NewSelect().Model(&existing).
+			Where("uuid IN (?)", bun.In(uuids)).
+			Order("uuid ASC").
+			For("UPDATE").
+			Scan(ctx); err != nil {
+			return err
+		}
+		existingMap := make(map[string]*PgCompany, len(existing))
+		for _, company := range existing {
+			existingMap[company.UUID] = company
+		}
+		if merge != nil {
+			merged := make([]*PgCompany, 0, len(companies))
+			for _, company := range companies {
+				stored, ok := existingMap[company.UUID]
+				if !ok || stored.DeletedAt != nil {
+					stored = nil
+				}
+				if merge(stored, company) {
+					merged = append(merged, company)
+				}
+			}
+			if companies = merged; len(companies) == 0 {
+				return nil
+			}
+		}
+
+		_, err := tx.NewInsert().
+			Model(&companies).
+			On("CONFLICT(uuid) DO UPDATE").
+			Set("name = EXCLUDED.name").
+			Set("normalized_domain = EXCLUDED.normalized_domain").
+			Set("employees_count = EXCLUDED.employees_count").
+			Set("industries = EXCLUDED.industries").
+			Set("keywords = EXCLUDED.keywords").
+			Set("address = EXCLUDED.address").
+			Set("annual_revenue = EXCLUDED.annual_revenue").
+			Set("total_funding = EXCLUDED.total_funding").
+			Set("technologies = EXCLUDED.technologies").
+			Set("city = EXCLUDED.city").
+			Set("state = EXCLUDED.state").
+			Set("country = EXCLUDED.country").
+			Set("linkedin_url = EXCLUDED.linkedin_url").
+			Set("website = EXCLUDED.website").
+			Set("facebook_url = EXCLUDED.facebook_url").
+			Set("twitter_url = EXCLUDED.twitter_url").
+			Set("company_name_for_emails = EXCLUDED.company_name_for_emails").
+			Set("phone_number = EXCLUDED.phone_number").
+			Set("latest_funding = EXCLUDED.latest_funding").
+			Set("latest_funding_amount = EXCLUDED.latest_funding_amount").
+			Set("last_raised_at = EXCLUDED.last_raised_at").
+			Set("source_date = EXCLUDED.source_date").
+			Set("source_job = EXCLUDED.source_job").
+			Set("source_api_key = EXCLUDED.source_api_key").
+			Set("source_file = EXCLUDED.source_file").
+			Set("source_row = EXCLUDED.source_row").
+			Set("ingested_at = EXCLUDED.ingested_at").
+			Set("field_sources = EXCLUDED.field_sources").
+			Set("updated_at = EXCLUDED.updated_at").
+			Set("deleted_at = NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		changes := make([]*ModelRecordChange, 0, len(companies))
+		for _, company := range companies {
+			stored, ok := existingMap[company.UUID]
+			if !ok || stored.DeletedAt != nil {
+				stats.Inserted++
+				stats.InsertedUuids = append(stats.InsertedUuids, company.UUID)
+				if change := NewRecordChange(constants.CompaniesService, company.UUID, nil, company, company.Provenance()); change != nil {
+					changes = append(changes, change)
+				}
+				continue
+			}
+			change := NewRecordChange(constants.CompaniesService, company.UUID, stored, company, company.Provenance())
+			if change == nil {
+				stats.Unchanged++
+				continue
+			}
+			stats.Updated++
+			for field := range change.Changes {
+				stats.FieldChanges[field]++
+				stats.UpdatedFields[company.UUID] = append(stats.UpdatedFields[company.UUID], field)
+			}
+			changes = append(changes, change)
+		}
+		return RecordChangesRepository(tx).Insert(changes)
+	})
+
+	return stats, err
+}
+
+// ListAfterId pages through live rows ordered by id (keyset pagination), so
+// full-table scans stay cheap regardless of how deep they go.
+func (t *PgCompanyStruct) ListAfterId(afterId uint64, updatedAfter *time.Time, limit int) ([]*PgCompany, error) {
+	companies := make([]*PgCompany, 0)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&companies).
+		Where("id > ?", afterId).
+		Where("deleted_at IS NULL")
+	if updatedAfter != nil {
+		queryBuilder = queryBuilder.Where("updated_at >= ?", *updatedAfter)
+	}
+	err := queryBuilder.Order("id ASC").Limit(limit).Scan(context.Background())
+	return companies, err
+}
+
+func (t *PgCompanyStruct) Count() (int64, error) {
+	count, err := t.PgDbClient.NewSelect().Model((*PgCompany)(nil)).
+		Where("deleted_at IS NULL").
+		Count(context.Background())
+	return int64(count), err
+}
+
+// DeleteByUuids soft deletes the live rows and, in the same transaction, logs
+// each one as a delete in record_changes with its last values. The rows are
+// removed for good by PurgeDeleted once the retention period has passed.
+func (t *PgCompanyStruct) DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error) {
+	if len(uuids) == 0 {
+		return 0, nil
+	}
+	var deleted int64
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		existing := make([]*PgCompany, 0)
+		if err := tx.NewSelect().Model(&existing).
+			Where("uuid IN (?)", bun.In(uuids)).
+			Where("deleted_at IS NULL").
+			Order("uuid ASC").
+			For("UPDATE").
+			Scan(ctx); err != nil || len(existing) == 0 {
+			return err
+		}
+		if _, err := tx.NewUpdate().Model((*PgCompany)(nil)).
+			Set("deleted_at = current_timestamp").
+			Set("updated_at = current_timestamp").
+			Where("uuid IN (?)", bun.In(uuids)).
+			Where("deleted_at IS NULL").
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		changes := make([]*ModelRecordChange, 0, len(existing))
+		for _, company := range existing {
+			if change := NewRecordChange(constants.CompaniesService, company.UUID, company, &PgCompany{}, provenance); change != nil {
+				change.ChangeType = constants.ChangeDelete
+				changes = append(changes, change)
+			}
+		}
+		deleted = int64(len(existing))
+		return RecordChangesRepository(tx).Insert(changes)
+	})
+	return deleted, err
+}
+
+// PurgeDeleted hard deletes up to limit rows soft deleted before the given
+// time and returns how many were removed.
+func (t *PgCompanyStruct) PurgeDeleted(before time.Time, limit int) (int64, error) {
+	ids := t.PgDbClient.NewSelect().Model((*PgCompany)(nil)).
+		Column("id").
+		Where("deleted_at < ?", before).
+		Order("id ASC").
+		Limit(limit)
+	result, err := t.PgDbClient.NewDelete().Model((*PgCompany)(nil)).
+		Where("id IN (?)", ids).
 		Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Update locks the stored row with the given uuid, hands it to patch and
+// writes the columns patch returns, logging the diff in record_changes, all in
+// one transaction. When expectedUpdatedAt is set the row must not have
+// changed since then. The company is returned with the full updated row.
+func (t *PgCompanyStruct) Update(uuid string, expectedUpdatedAt *time.Time, patch func(stored *PgCompany) (*PgCompany, []string, error)) (*PgCompany, error) {
+	var company *PgCompany
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		stored := new(PgCompany)
+		err := tx.NewSelect().Model(stored).
+			Where("uuid = ?", uuid).
+			Where("deleted_at IS NULL").
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return constants.CompanyNotFoundError
+		}
+		if err != nil {
+			return err
+		}
+		if expectedUpdatedAt != nil && (stored.UpdatedAt == nil || !stored.UpdatedAt.Equal(expectedUpdatedAt.Truncate(time.Microsecond))) {
+			return constants.UpdateConflictError
+		}
+		patched, columns, err := patch(stored)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NewUpdate().Model(patched).
+			Column(columns...).
+			Where("uuid = ?", uuid).
+			Returning("*").
+			Exec(ctx); err != nil {
+			return err
+		}
+		company = patched
+		change := NewRecordChange(constants.CompaniesService, uuid, stored, patched, patched.Provenance())
+		if change == nil {
+			return nil
+		}
+		return RecordChangesRepository(tx).Insert([]*ModelRecordChange{change})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return company, nil
+}
 
-	return int64(len(companies)), err
+// CarriedValues returns the values among values that at least one live row
+// carries in column, which is matched by element when it is an array.
+func (t *PgCompanyStruct) CarriedValues(column string, values []string, isArray bool) ([]string, error) {
+	carried := make([]string, 0)
+	if len(values) == 0 {
+		return carried, nil
+	}
+	err := carriedValuesQuery(t.PgDbClient, (*PgCompany)(nil), column, values, isArray).Scan(context.Background(), &carried)
+	return carried, err
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
@@ -42,7 +44,11 @@ type ElasticContact struct {
 	CompanyWebsite          string   `json:"company_website"`           // text search
 	CompanyNormalizedDomain string   `json:"company_normalized_domain"` // text search
 
+	SourceJob  string     `json:"source_job,omitempty"`  // keyword search
+	IngestedAt *time.Time `json:"ingested_at,omitempty"` // date search
+
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 func ElasticContactFromRawData(contact *PgContact, company *PgCompany) *ElasticContact {
@@ -79,10 +85,38 @@ func ElasticContactFromRawData(contact *PgContact, company *PgCompany) *ElasticC
 		CompanyWebsite:          company.Website,
 		CompanyNormalizedDomain: company.NormalizedDomain,
 		CreatedAt:               serverTime,
+		UpdatedAt:               serverTime,
+		SourceJob:               contact.SourceJob,
+		IngestedAt:              contact.IngestedAt,
+	}
+	if contact.CreatedAt != nil {
+		esContact.CreatedAt = *contact.CreatedAt
+	}
+	if contact.UpdatedAt != nil {
+		esContact.UpdatedAt = *contact.UpdatedAt
 	}
 	return esContact
 }
 
+// ElasticContactCompanyFields returns the company_* fields a contact document
+// carries for company, keyed by their document name.
+func ElasticContactCompanyFields(company *PgCompany) (map[string]any, error) {
+	document, err := json.Marshal(ElasticContactFromRawData(&PgContact{}, company))
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]any)
+	if err := json.Unmarshal(document, &fields); err != nil {
+		return nil, err
+	}
+	for key := range fields {
+		if !strings.HasPrefix(key, "company_") || key == "company_id" {
+			delete(fields, key)
+		}
+	}
+	return fields, nil
+}
+
 type ElasticContactSearchHit struct {
 	Contact ElasticContact `json:"_source"`
 	Cursor  []string       `json:"sort,omitempty"`
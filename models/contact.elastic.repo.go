@@ -24,7 +24,12 @@ func ElasticContactRepository(client *elasticsearch.Client) ElasticContactSvcRep
 type ElasticContactSvcRepo interface {
 	ListByQueryMap(query map[string]any) ([]*ElasticContactSearchHit, error)
 	CountByQueryMap(query map[string]any) (int64, error)
+	AggregateTerms(query map[string]any) ([]utilities.TermsBucket, error)
 	BulkUpsert(contacts []*ElasticContact) (int64, error)
+	BulkUpsertToIndex(index string, contacts []*ElasticContact) (int64, error)
+	BulkDelete(uuids []string) (int64, error)
+	BulkDeleteFromIndex(index string, uuids []string) (int64, error)
+	UpdateCompanyFields(companyFields map[string]map[string]any) (string, error)
 }
 
 func (t *ElasticContactStruct) ListByQueryMap(query map[string]any) ([]*ElasticContactSearchHit, error) {
@@ -85,12 +90,46 @@ func (t *ElasticContactStruct) CountByQueryMap(query map[string]any) (int64, err
 	return countResponse.Count, nil
 }
 
+// AggregateTerms runs a search built by VQLQuery.ToTermsAggregation and
+// returns its buckets.
+func (t *ElasticContactStruct) AggregateTerms(query map[string]any) ([]utilities.TermsBucket, error) {
+	queryJson, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.ElasticClient.Search(
+		t.ElasticClient.Search.WithIndex(constants.ContactIndex),
+		t.ElasticClient.Search.WithBody(bytes.NewReader(queryJson)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return nil, constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+
+	var aggregationResponse utilities.ElasticTermsAggregation
+	if err := json.NewDecoder(response.Body).Decode(&aggregationResponse); err != nil {
+		return nil, err
+	}
+
+	return aggregationResponse.Aggregations.Values.Buckets, nil
+}
+
 func (t *ElasticContactStruct) BulkUpsert(contacts []*ElasticContact) (int64, error) {
+	return t.BulkUpsertToIndex(constants.ContactIndex, contacts)
+}
+
+func (t *ElasticContactStruct) BulkUpsertToIndex(index string, contacts []*ElasticContact) (int64, error) {
 	var buf bytes.Buffer
 	for _, contact := range contacts {
 		meta := map[string]any{
 			"index": map[string]any{
-				"_index": constants.ContactIndex,
+				"_index": index,
 				"_id":    contact.UUID,
 			},
 		}
@@ -111,5 +150,115 @@ func (t *ElasticContactStruct) BulkUpsert(contacts []*ElasticContact) (int64, er
 		return 0, constants.ElasticsearchBulkError(response.StatusCode, string(bodyBytes))
 	}
 
+	var bulkResponse utilities.ElasticBulkResponse
+	if err := json.NewDecoder(response.Body).Decode(&bulkResponse); err != nil {
+		return 0, err
+	}
+	if bulkResponse.Errors {
+		return 0, constants.ElasticsearchBulkError(response.StatusCode, bulkResponse.FirstError())
+	}
+
 	return int64(len(contacts)), nil
 }
+
+func (t *ElasticContactStruct) BulkDelete(uuids []string) (int64, error) {
+	return t.BulkDeleteFromIndex(constants.ContactIndex, uuids)
+}
+
+func (t *ElasticContactStruct) BulkDeleteFromIndex(index string, uuids []string) (int64, error) {
+	if len(uuids) == 0 {
+		return 0, nil
+	}
+	var buf bytes.Buffer
+	for _, uuid := range uuids {
+		meta := map[string]any{
+			"delete": map[string]any{
+				"_index": index,
+				"_id":    uuid,
+			},
+		}
+		if utilities.AddToBuffer(&buf, meta) != nil {
+			log.Error().Msgf("Failed to add contact delete to buffer: %v", uuid)
+			continue
+		}
+	}
+
+	response, err := t.ElasticClient.Bulk(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return 0, constants.ElasticsearchBulkError(response.StatusCode, string(bodyBytes))
+	}
+
+	var bulkResponse utilities.ElasticBulkResponse
+	if err := json.NewDecoder(response.Body).Decode(&bulkResponse); err != nil {
+		return 0, err
+	}
+	if bulkResponse.Errors {
+		return 0, constants.ElasticsearchBulkError(response.StatusCode, bulkResponse.FirstError())
+	}
+
+	return int64(len(uuids)), nil
+}
+
+// refreshes the company_* fields of every contact whose company_id is a key of
+// params.companies
+const updateCompanyFieldsScript = `def fields = params.companies[ctx._source.company_id];
+if (fields == null) { ctx.op = 'noop'; return; }
+for (entry in fields.entrySet()) { ctx._source[entry.getKey()] = entry.getValue(); }`
+
+// UpdateCompanyFields rewrites the denormalised company fields of the contacts
+// of each company in companyFields, keyed by company uuid. It starts an
+// update-by-query task and returns its id without waiting, so companies with
+// many contacts do not hold up the caller.
+func (t *ElasticContactStruct) UpdateCompanyFields(companyFields map[string]map[string]any) (string, error) {
+	if len(companyFields) == 0 {
+		return "", nil
+	}
+	companyIds := make([]string, 0, len(companyFields))
+	for companyId := range companyFields {
+		companyIds = append(companyIds, companyId)
+	}
+	query := map[string]any{
+		"query": map[string]any{
+			"terms": map[string]any{"company_id": companyIds},
+		},
+		"script": map[string]any{
+			"source": updateCompanyFieldsScript,
+			"lang":   "painless",
+			"params": map[string]any{"companies": companyFields},
+		},
+	}
+	queryJson, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := t.ElasticClient.UpdateByQuery(
+		[]string{constants.ContactIndex},
+		t.ElasticClient.UpdateByQuery.WithBody(bytes.NewReader(queryJson)),
+		t.ElasticClient.UpdateByQuery.WithConflicts("proceed"),
+		t.ElasticClient.UpdateByQuery.WithWaitForCompletion(false),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return "", constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+
+	var taskResponse struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&taskResponse); err != nil {
+		return "", err
+	}
+	return taskResponse.Task, nil
+}
@@ -2,6 +2,9 @@ package models
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"time"
 	"vivek-ray/constants"
 	"vivek-ray/utilities"
 
@@ -70,17 +73,26 @@ func (f *PgContactFilters) ToQuery(query *bun.SelectQuery) *bun.SelectQuery {
 type PgContactSvcRepo interface {
 	GetFiltersByQuery(query FiltersDataQuery) ([]*PgContact, error)
 	ListByFilters(filters PgContactFilters) ([]*PgContact, error)
-	BulkUpsert(contacts []*PgContact) (int64, error)
+	BulkUpsert(contacts []*PgContact, merge func(stored, incoming *PgContact) bool) (utilities.UpsertStats, error)
+	ListAfterId(afterId uint64, updatedAfter *time.Time, limit int) ([]*PgContact, error)
+	Count() (int64, error)
+	DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error)
+	PurgeDeleted(before time.Time, limit int) (int64, error)
+	CarriedValues(column string, values []string, isArray bool) ([]string, error)
+	Update(uuid string, expectedUpdatedAt *time.Time, patch func(stored *PgContact) (*PgContact, []string, error)) (*PgContact, error)
 }
 
 func (t *PgContactStruct) GetFiltersByQuery(query FiltersDataQuery) ([]*PgContact, error) {
 	var contacts []*PgContact
 
-	queryBuilder := t.PgDbClient.NewSelect().Model(&contacts)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&contacts).Where("deleted_at IS NULL")
 
 	if query.SearchText != "" {
 		queryBuilder = queryBuilder.Where("? ILIKE ?", bun.Ident(query.FilterKey), "%"+query.SearchText+"%")
 	}
+	if query.Prefix != "" {
+		queryBuilder = queryBuilder.Where("? ILIKE ?", bun.Ident(query.FilterKey), utilities.EscapeLike(query.Prefix)+"%")
+	}
 	query.Limit = utilities.InlineIf(query.Limit > 0, query.Limit, constants.DefaultPageSize).(int)
 	if query.Page > 0 {
 		queryBuilder = queryBuilder.Offset((query.Page - 1) * query.Limit)
@@ -95,7 +107,7 @@ func (t *PgContactStruct) ListByFilters(filters PgContactFilters) ([]*PgContact,
 		return contacts, nil
 	}
 
-	queryBuilder := t.PgDbClient.NewSelect().Model(&contacts)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&contacts).Where("deleted_at IS NULL")
 	filters.ToQuery(queryBuilder)
 
 	if filters.Page > 0 && filters.Limit > 0 {
@@ -105,32 +117,248 @@ func (t *PgContactStruct) ListByFilters(filters PgContactFilters) ([]*PgContact,
 	return contacts, err
 }
 
-func (t *PgContactStruct) BulkUpsert(contacts []*PgContact) (int64, error) {
-	_, err := t.PgDbClient.NewInsert().
-		Model(&contacts).
-		On("CONFLICT(uuid) DO UPDATE").
-		Set("first_name = EXCLUDED.first_name").
-		Set("last_name = EXCLUDED.last_name").
-		Set("company_id = EXCLUDED.company_id").
-		Set("email = EXCLUDED.email").
-		Set("title = EXCLUDED.title").
-		Set("departments = EXCLUDED.departments").
-		Set("mobile_phone = EXCLUDED.mobile_phone").
-		Set("email_status = EXCLUDED.email_status").
-		Set("seniority = EXCLUDED.seniority").
-		Set("city = EXCLUDED.city").
-		Set("state = EXCLUDED.state").
-		Set("country = EXCLUDED.country").
-		Set("linkedin_url = EXCLUDED.linkedin_url").
-		Set("facebook_url = EXCLUDED.facebook_url").
-		Set("twitter_url = EXCLUDED.twitter_url").
-		Set("website = EXCLUDED.website").
-		Set("work_direct_phone = EXCLUDED.work_direct_phone").
-		Set("home_phone = EXCLUDED.home_phone").
-		Set("other_phone = EXCLUDED.other_phone").
-		Set("stage = EXCLUDED.stage").
-		Set("updated_at = EXCLUDED.updated_at").
+// BulkUpsert writes the records and, in the same transaction, appends a
+// field-level diff against the stored rows to record_changes. The returned
+// stats tell inserts, updates and no-op writes apart. Upserting a soft
+// deleted record revives it and counts as an insert. When merge is not nil it
+// is called for every incoming record with its stored row, locked for the rest
+// of the transaction, or nil when there is no live row, before anything is
+// written; the records it returns false for are left out of the write.
+func (t *PgContactStruct) BulkUpsert(contacts []*PgContact, merge func(stored, incoming *PgContact) bool) (utilities.UpsertStats, error) {
+	stats := utilities.UpsertStats{FieldChanges: make(map[string]int64), UpdatedFields: make(map[string][]string)}
+	if len(contacts) == 0 {
+		return stats, nil
+	}
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		uuids := make([]string, 0, len(contacts))
+		for _, contact := range contacts {
+			uuids = append(uuids, contact.UUID)
+		}
+		existing := make([]*PgContact, 0)
+		if err := tx.NewSelect().Model(&existing).
+			Where("uuid IN (?)", bun.In(uuids)).
+			Order("uuid ASC").
+			For("UPDATE").
+			Scan(ctx); err != nil {
+			return err
+		}
+		existingMap := make(map[string]*PgContact, len(existing))
+		for _, contact := range existing {
+			existingMap[contact.UUID] = contact
+		}
+		if merge != nil {
+			merged := make([]*PgContact, 0, len(contacts))
+			for _, contact := range contacts {
+				stored, ok := existingMap[contact.UUID]
+				if !ok || stored.DeletedAt != nil {
+					stored = nil
+				}
+				if merge(stored, contact) {
+					merged = append(merged, contact)
+				}
+			}
+			if contacts = merged; len(contacts) == 0 {
+				return nil
+			}
+		}
+
+		_, err := tx.NewInsert().
+			Model(&contacts).
+			On("CONFLICT(uuid) DO UPDATE").
+			Set("first_name = EXCLUDED.first_name").
+			Set("last_name = EXCLUDED.last_name").
+			Set("company_id = EXCLUDED.company_id").
+			Set("email = EXCLUDED.email").
+			Set("title = EXCLUDED.title").
+			Set("departments = EXCLUDED.departments").
+			Set("mobile_phone = EXCLUDED.mobile_phone").
+			Set("email_status = EXCLUDED.email_status").
+			Set("seniority = EXCLUDED.seniority").
+			Set("city = EXCLUDED.city").
+			Set("state = EXCLUDED.state").
+			Set("country = EXCLUDED.country").
+			Set("linkedin_url = EXCLUDED.linkedin_url").
+			Set("facebook_url = EXCLUDED.facebook_url").
+			Set("twitter_url = EXCLUDED.twitter_url").
+			Set("website = EXCLUDED.website").
+			Set("work_direct_phone = EXCLUDED.work_direct_phone").
+			Set("home_phone = EXCLUDED.home_phone").
+			Set("other_phone = EXCLUDED.other_phone").
+			Set("stage = EXCLUDED.stage").
+			Set("source_date = EXCLUDED.source_date").
+			Set("source_job = EXCLUDED.source_job").
+			Set("source_api_key = EXCLUDED.source_api_key").
+			Set("source_file = EXCLUDED.source_file").
+			Set("source_row = EXCLUDED.source_row").
+			Set("ingested_at = EXCLUDED.ingested_at").
+			Set("field_sources = EXCLUDED.field_sources").
+			Set("updated_at = EXCLUDED.updated_at").
+			Set("deleted_at = NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		changes := make([]*ModelRecordChange, 0, len(contacts))
+		for _, contact := range contacts {
+			stored, ok := existingMap[contact.UUID]
+			if !ok || stored.DeletedAt != nil {
+				stats.Inserted++
+				stats.InsertedUuids = append(stats.InsertedUuids, contact.UUID)
+				if change := NewRecordChange(constants.ContactsService, contact.UUID, nil, contact, contact.Provenance()); change != nil {
+					changes = append(changes, change)
+				}
+				continue
+			}
+			change := NewRecordChange(constants.ContactsService, contact.UUID, stored, contact, contact.Provenance())
+			if change == nil {
+				stats.Unchanged++
+				continue
+			}
+			stats.Updated++
+			for field := range change.Changes {
+				stats.FieldChanges[field]++
+				stats.UpdatedFields[contact.UUID] = append(stats.UpdatedFields[contact.UUID], field)
+			}
+			changes = append(changes, change)
+		}
+		return RecordChangesRepository(tx).Insert(changes)
+	})
+
+	return stats, err
+}
+
+// ListAfterId pages through live rows ordered by id (keyset pagination), so
+// full-table scans stay cheap regardless of how deep they go.
+func (t *PgContactStruct) ListAfterId(afterId uint64, updatedAfter *time.Time, limit int) ([]*PgContact, error) {
+	contacts := make([]*PgContact, 0)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&contacts).
+		Where("id > ?", afterId).
+		Where("deleted_at IS NULL")
+	if updatedAfter != nil {
+		queryBuilder = queryBuilder.Where("updated_at >= ?", *updatedAfter)
+	}
+	err := queryBuilder.Order("id ASC").Limit(limit).Scan(context.Background())
+	return contacts, err
+}
+
+func (t *PgContactStruct) Count() (int64, error) {
+	count, err := t.PgDbClient.NewSelect().Model((*PgContact)(nil)).
+		Where("deleted_at IS NULL").
+		Count(context.Background())
+	return int64(count), err
+}
+
+// DeleteByUuids soft deletes the live rows and, in the same transaction, logs
+// each one as a delete in record_changes with its last values. The rows are
+// removed for good by PurgeDeleted once the retention period has passed.
+func (t *PgContactStruct) DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error) {
+	if len(uuids) == 0 {
+		return 0, nil
+	}
+	var deleted int64
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		existing := make([]*PgContact, 0)
+		if err := tx.NewSelect().Model(&existing).
+			Where("uuid IN (?)", bun.In(uuids)).
+			Where("deleted_at IS NULL").
+			Order("uuid ASC").
+			For("UPDATE").
+			Scan(ctx); err != nil || len(existing) == 0 {
+			return err
+		}
+		if _, err := tx.NewUpdate().Model((*PgContact)(nil)).
+			Set("deleted_at = current_timestamp").
+			Set("updated_at = current_timestamp").
+			Where("uuid IN (?)", bun.In(uuids)).
+			Where("deleted_at IS NULL").
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		changes := make([]*ModelRecordChange, 0, len(existing))
+		for _, contact := range existing {
+			if change := NewRecordChange(constants.ContactsService, contact.UUID, contact, &PgContact{}, provenance); change != nil {
+				change.ChangeType = constants.ChangeDelete
+				changes = append(changes, change)
+			}
+		}
+		deleted = int64(len(existing))
+		return RecordChangesRepository(tx).Insert(changes)
+	})
+	return deleted, err
+}
+
+// PurgeDeleted hard deletes up to limit rows soft deleted before the given
+// time and returns how many were removed.
+func (t *PgContactStruct) PurgeDeleted(before time.Time, limit int) (int64, error) {
+	ids := t.PgDbClient.NewSelect().Model((*PgContact)(nil)).
+		Column("id").
+		Where("deleted_at < ?", before).
+		Order("id ASC").
+		Limit(limit)
+	result, err := t.PgDbClient.NewDelete().Model((*PgContact)(nil)).
+		Where("id IN (?)", ids).
 		Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Update locks the stored row with the given uuid, hands it to patch and
+// writes the columns patch returns, logging the diff in record_changes, all in
+// one transaction. When expectedUpdatedAt is set the row must not have
+// changed since then. The contact is returned with the full updated row.
+func (t *PgContactStruct) Update(uuid string, expectedUpdatedAt *time.Time, patch func(stored *PgContact) (*PgContact, []string, error)) (*PgContact, error) {
+	var contact *PgContact
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		stored := new(PgContact)
+		err := tx.NewSelect().Model(stored).
+			Where("uuid = ?", uuid).
+			Where("deleted_at IS NULL").
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return constants.ContactNotFoundError
+		}
+		if err != nil {
+			return err
+		}
+		if expectedUpdatedAt != nil && (stored.UpdatedAt == nil || !stored.UpdatedAt.Equal(expectedUpdatedAt.Truncate(time.Microsecond))) {
+			return constants.UpdateConflictError
+		}
+		patched, columns, err := patch(stored)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.NewUpdate().Model(patched).
+			Column(columns...).
+			Where("uuid = ?", uuid).
+			Returning("*").
+			Exec(ctx); err != nil {
+			return err
+		}
+		contact = patched
+		change := NewRecordChange(constants.ContactsService, uuid, stored, patched, patched.Provenance())
+		if change == nil {
+			return nil
+		}
+		return RecordChangesRepository(tx).Insert([]*ModelRecordChange{change})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
 
-	return int64(len(contacts)), err
+// CarriedValues returns the values among values that at least one live row
+// carries in column, which is matched by element when it is an array.
+func (t *PgContactStruct) CarriedValues(column string, values []string, isArray bool) ([]string, error) {
+	carried := make([]string, 0)
+	if len(values) == 0 {
+		return carried, nil
+	}
+	err := carriedValuesQuery(t.PgDbClient, (*PgContact)(nil), column, values, isArray).Scan(context.Background(), &carried)
+	return carried, err
 }
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/utilities"
+
+	"github.com/uptrace/bun"
+)
+
+// ModelExportTemplate is a stored set of output columns that export jobs of
+// its service can reference by name instead of listing select_columns.
+type ModelExportTemplate struct {
+	db            *bun.DB
+	bun.BaseModel `bun:"table:export_templates,alias:et"`
+
+	Id      uint64                           `bun:"id,pk,autoincrement" json:"id"`
+	Service string                           `bun:"service,notnull" json:"service"`
+	Name    string                           `bun:"name,notnull" json:"name"`
+	Columns []utilities.ExportTemplateColumn `bun:"columns,type:jsonb" json:"columns"`
+
+	CreatedAt *time.Time   `bun:"created_at,nullzero,default:current_timestamp" json:"created_at"`
+	UpdatedAt *time.Time   `bun:"updated_at,nullzero,default:current_timestamp" json:"updated_at"`
+	DeletedAt bun.NullTime `bun:"deleted_at,nullzero" json:"deleted_at,omitempty"`
+}
+
+func (m *ModelExportTemplate) SetDB(db *bun.DB) *ModelExportTemplate {
+	m.db = db
+	return m
+}
+
+// ExportColumnType returns the type of a column that exports of the service
+// can read, or nil when there is no such column. Contact exports can also
+// read the fields of the contact's company as company.<field>.
+func ExportColumnType(service, column string) reflect.Type {
+	if name, ok := strings.CutPrefix(column, constants.CompanyColumnPrefix); ok && service == constants.ContactsService {
+		return utilities.FieldType(&PgCompany{}, name)
+	}
+	if service == constants.ContactsService {
+		return utilities.FieldType(&PgContact{}, column)
+	}
+	return utilities.FieldType(&PgCompany{}, column)
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+type ExportTemplatesStruct struct {
+	PgDbClient *bun.DB
+}
+
+func ExportTemplatesRepository(db *bun.DB) ExportTemplatesSvcRepo {
+	return &ExportTemplatesStruct{
+		PgDbClient: db,
+	}
+}
+
+type ExportTemplatesSvcRepo interface {
+	ListByService(service string) ([]*ModelExportTemplate, error)
+	GetByName(service, name string) (*ModelExportTemplate, error)
+	Create(template *ModelExportTemplate) (int64, error)
+	Update(template *ModelExportTemplate) error
+	SoftDelete(service, name string) (int64, error)
+}
+
+func (t *ExportTemplatesStruct) ListByService(service string) ([]*ModelExportTemplate, error) {
+	templates := make([]*ModelExportTemplate, 0)
+	err := t.PgDbClient.NewSelect().Model(&templates).Where("deleted_at IS NULL").
+		Where("service = ?", service).Order("name ASC").Scan(context.Background())
+	return templates, err
+}
+
+// GetByName returns the template, or nil when the service has no template
+// with that name.
+func (t *ExportTemplatesStruct) GetByName(service, name string) (*ModelExportTemplate, error) {
+	templates := make([]*ModelExportTemplate, 0)
+	err := t.PgDbClient.NewSelect().Model(&templates).Where("deleted_at IS NULL").
+		Where("service = ? AND name = ?", service, name).Limit(1).Scan(context.Background())
+	if err != nil || len(templates) == 0 {
+		return nil, err
+	}
+	return templates[0], nil
+}
+
+// Create inserts the template unless the service already has a live one with
+// the same name, in which case nothing is written and 0 is returned.
+func (t *ExportTemplatesStruct) Create(template *ModelExportTemplate) (int64, error) {
+	result, err := t.PgDbClient.NewInsert().Model(template).
+		On("CONFLICT (service, name) WHERE deleted_at IS NULL DO NOTHING").
+		Returning("*").
+		Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (t *ExportTemplatesStruct) Update(template *ModelExportTemplate) error {
+	_, err := t.PgDbClient.NewUpdate().Model(template).
+		Column("columns").
+		Set("updated_at = current_timestamp").
+		WherePK().
+		Returning("*").
+		Exec(context.Background())
+	return err
+}
+
+func (t *ExportTemplatesStruct) SoftDelete(service, name string) (int64, error) {
+	result, err := t.PgDbClient.NewUpdate().Model((*ModelExportTemplate)(nil)).
+		Set("deleted_at = current_timestamp").
+		Where("service = ? AND name = ?", service, name).
+		Where("deleted_at IS NULL").
+		Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
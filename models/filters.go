@@ -15,8 +15,13 @@ type ModelFilter struct {
 	DisplayName  string `bun:"display_name,notnull" json:"display_name"`
 	DirectDerived bool   `bun:"direct_derived,nullzero" json:"direct_derived"`
 	Active       bool   `bun:"active,notnull,default:true" json:"active"`
+	Position     int    `bun:"position,notnull,default:0" json:"position"`
 
 	DeletedAt bun.NullTime `bun:"deleted_at,nullzero" json:"deleted_at,omitempty"`
+
+	// RebuildJob is the uuid of the rebuild_filter_data job queued when the
+	// filter was created or switched to stored values.
+	RebuildJob string `bun:"-" json:"rebuild_job,omitempty"`
 }
 
 func (m *ModelFilter) SetDB(db *bun.DB) *ModelFilter {
@@ -21,18 +21,25 @@ type FiltersSvcRepo interface {
 	GetFiltersByService(service string) ([]*ModelFilter, error)
 	GetFilterByKeyAndService(service, key string) (ModelFilter, error)
 	UpdateActiveStatus(key, service string, status bool) error
+	ListByService(service string) ([]*ModelFilter, error)
+	GetByKey(service, key string) (*ModelFilter, error)
+	Create(filter *ModelFilter) error
+	Update(filter *ModelFilter) error
+	SoftDelete(service, key string) (int64, error)
+	Reorder(service string, keys []string) error
 }
 
 func (t *FiltersStruct) GetTempFilters() ([]*ModelFilter, error) {
 	var filters []*ModelFilter
-	err := t.PgDbClient.NewSelect().Model(&filters).Where("direct_derived = false").Scan(context.Background())
+	err := t.PgDbClient.NewSelect().Model(&filters).Where("direct_derived IS NOT TRUE").
+		Where("deleted_at IS NULL").Scan(context.Background())
 	return filters, err
 }
 
 func (t *FiltersStruct) GetFiltersByService(service string) ([]*ModelFilter, error) {
 	var filters []*ModelFilter
 	err := t.PgDbClient.NewSelect().Model(&filters).Where("active = true AND deleted_at IS NULL").
-		Where("service = ?", service).Scan(context.Background())
+		Where("service = ?", service).Order("position ASC", "id ASC").Scan(context.Background())
 	return filters, err
 }
 
@@ -48,7 +55,92 @@ func (t *FiltersStruct) UpdateActiveStatus(key, service string, status bool) err
 	_, err := t.PgDbClient.NewUpdate().Model(&ModelFilter{}).
 		Set("active = ?", status).
 		Where("key = ? AND service = ?", key, service).
+		Where("deleted_at IS NULL").
 		Exec(context.Background())
 	return err
 }
 
+// ListByService returns every filter of a service, inactive ones included,
+// in display order.
+func (t *FiltersStruct) ListByService(service string) ([]*ModelFilter, error) {
+	filters := make([]*ModelFilter, 0)
+	err := t.PgDbClient.NewSelect().Model(&filters).Where("deleted_at IS NULL").
+		Where("service = ?", service).Order("position ASC", "id ASC").Scan(context.Background())
+	return filters, err
+}
+
+// GetByKey returns the filter whether it is active or not, or nil when the
+// service has no such filter.
+func (t *FiltersStruct) GetByKey(service, key string) (*ModelFilter, error) {
+	filters := make([]*ModelFilter, 0)
+	err := t.PgDbClient.NewSelect().Model(&filters).Where("deleted_at IS NULL").
+		Where("service = ? AND key = ?", service, key).Limit(1).Scan(context.Background())
+	if err != nil || len(filters) == 0 {
+		return nil, err
+	}
+	return filters[0], nil
+}
+
+// Create appends the filter after the existing ones of its service. The
+// position is taken from the highest one in use, as deletes and reorders leave
+// gaps that a count would land in.
+func (t *FiltersStruct) Create(filter *ModelFilter) error {
+	// direct_derived is nullzero, so false has to be written explicitly
+	_, err := t.PgDbClient.NewInsert().Model(filter).
+		Value("direct_derived", "?", filter.DirectDerived).
+		Value("position", "(SELECT COALESCE(MAX(position), 0) + 1 FROM filters WHERE service = ? AND deleted_at IS NULL)", filter.Service).
+		Returning("id, position").
+		Exec(context.Background())
+	return err
+}
+
+func (t *FiltersStruct) Update(filter *ModelFilter) error {
+	_, err := t.PgDbClient.NewUpdate().Model(filter).
+		Column("display_name", "filter_type").
+		Set("direct_derived = ?", filter.DirectDerived).
+		WherePK().
+		Exec(context.Background())
+	return err
+}
+
+// SoftDelete deletes the filter and, in the same transaction, the values
+// stored for it in filters_data.
+func (t *FiltersStruct) SoftDelete(service, key string) (int64, error) {
+	var deleted int64
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		result, err := tx.NewUpdate().Model((*ModelFilter)(nil)).
+			Set("deleted_at = current_timestamp").
+			Where("key = ? AND service = ?", key, service).
+			Where("deleted_at IS NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if deleted, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		_, err = tx.NewUpdate().Model((*ModelFilterData)(nil)).
+			Set("deleted_at = current_timestamp").
+			Where("filter_key = ? AND service = ?", key, service).
+			Where("deleted_at IS NULL").
+			Exec(ctx)
+		return err
+	})
+	return deleted, err
+}
+
+// Reorder numbers the filters of a service in the order of keys.
+func (t *FiltersStruct) Reorder(service string, keys []string) error {
+	return t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		for i, key := range keys {
+			if _, err := tx.NewUpdate().Model((*ModelFilter)(nil)).
+				Set("position = ?", i+1).
+				Where("key = ? AND service = ?", key, service).
+				Where("deleted_at IS NULL").
+				Exec(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
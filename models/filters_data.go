@@ -18,6 +18,9 @@ type ModelFilterData struct {
 	DisplayValue string     `bun:"display_value,notnull" json:"display_value"`
 	Value        string     `bun:"value,nullzero" json:"value"`
 	DeletedAt    *time.Time `bun:"deleted_at,nullzero" json:"deleted_at"`
+	// LastSeenAt is when the value was last upserted, by an import or a
+	// rebuild of the filter.
+	LastSeenAt *time.Time `bun:"last_seen_at,nullzero,default:current_timestamp" json:"last_seen_at"`
 }
 
 func (m *ModelFilterData) SetDB(db *bun.DB) *ModelFilterData {
@@ -2,18 +2,26 @@ package models
 
 import (
 	"context"
+	"time"
 	"vivek-ray/constants"
 	"vivek-ray/utilities"
 
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
 )
 
 type FiltersDataQuery struct {
 	Service    string `json:"service,omitempty"`
 	FilterKey  string `json:"filter_key"`
 	SearchText string `json:"search_text,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
 	Page       int    `json:"page"`
 	Limit      int    `json:"limit"`
+
+	// Where scopes the options to the records matching the current VQL
+	// selection; they are then counted in Elasticsearch and ordered by SortBy.
+	Where  *utilities.WhereStruct `json:"where,omitempty"`
+	SortBy string                 `json:"sort_by,omitempty"`
 }
 
 type FiltersDataStruct struct {
@@ -29,15 +37,22 @@ func FiltersDataRepository(db *bun.DB) FiltersDataSvcRepo {
 type FiltersDataSvcRepo interface {
 	GetFiltersByQuery(query FiltersDataQuery) ([]*ModelFilterData, error)
 	BulkUpsert(filtersData []*ModelFilterData) error
+	SoftDelete(uuids []string) (int64, error)
+	PruneUnseen(service, filterKey string, before time.Time) (int64, error)
+	ServerTime() (time.Time, error)
 }
 
 func (t *FiltersDataStruct) GetFiltersByQuery(query FiltersDataQuery) ([]*ModelFilterData, error) {
 	var filtersData []*ModelFilterData
 
-	queryBuilder := t.PgDbClient.NewSelect().Model(&filtersData).Where("service = ?", query.Service).Where("filter_key = ?", query.FilterKey)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&filtersData).Where("service = ?", query.Service).Where("filter_key = ?", query.FilterKey).
+		Where("deleted_at IS NULL")
 	if query.SearchText != "" {
 		queryBuilder = queryBuilder.Where("display_value ILIKE ?", "%"+query.SearchText+"%")
 	}
+	if query.Prefix != "" {
+		queryBuilder = queryBuilder.Where("display_value ILIKE ?", utilities.EscapeLike(query.Prefix)+"%")
+	}
 	query.Limit = utilities.InlineIf(query.Limit > 0, query.Limit, constants.DefaultPageSize).(int)
 	if query.Page > 0 {
 		queryBuilder = queryBuilder.Offset((query.Page - 1) * query.Limit)
@@ -47,10 +62,68 @@ func (t *FiltersDataStruct) GetFiltersByQuery(query FiltersDataQuery) ([]*ModelF
 }
 
 func (t *FiltersDataStruct) BulkUpsert(filtersData []*ModelFilterData) error {
+	if len(filtersData) == 0 {
+		return nil
+	}
 	_, err := t.PgDbClient.NewInsert().
 		Model(&filtersData).
-		On("CONFLICT(uuid) DO NOTHING").
+		On("CONFLICT(uuid) DO UPDATE").
+		Set("deleted_at = NULL").
+		Set("last_seen_at = CURRENT_TIMESTAMP").
 		Exec(context.Background())
 	return err
 }
 
+// SoftDelete hides filter values that no live record carries anymore. A later
+// upsert of the same value revives the row.
+func (t *FiltersDataStruct) SoftDelete(uuids []string) (int64, error) {
+	if len(uuids) == 0 {
+		return 0, nil
+	}
+	result, err := t.PgDbClient.NewUpdate().Model((*ModelFilterData)(nil)).
+		Set("deleted_at = current_timestamp").
+		Where("uuid IN (?)", bun.In(uuids)).
+		Where("deleted_at IS NULL").
+		Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted, err
+}
+
+// PruneUnseen soft deletes the live values of one filter that were not
+// upserted since before.
+func (t *FiltersDataStruct) PruneUnseen(service, filterKey string, before time.Time) (int64, error) {
+	result, err := t.PgDbClient.NewUpdate().Model((*ModelFilterData)(nil)).
+		Set("deleted_at = current_timestamp").
+		Where("service = ? AND filter_key = ?", service, filterKey).
+		Where("deleted_at IS NULL").
+		Where("last_seen_at < ?", before).
+		Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ServerTime returns the database clock, which last_seen_at is written with.
+func (t *FiltersDataStruct) ServerTime() (time.Time, error) {
+	var now time.Time
+	err := t.PgDbClient.QueryRowContext(context.Background(), "SELECT CURRENT_TIMESTAMP").Scan(&now)
+	return now, err
+}
+
+// carriedValuesQuery selects, in one scan of the table of model, the values
+// among values that a live row carries in column.
+func carriedValuesQuery(db bun.IDB, model any, column string, values []string, isArray bool) *bun.SelectQuery {
+	query := db.NewSelect().Model(model).Where("deleted_at IS NULL")
+	if isArray {
+		return query.TableExpr("unnest(?) AS value", bun.Ident(column)).
+			ColumnExpr("DISTINCT value").
+			Where("? && ?", bun.Ident(column), pgdialect.Array(values)).
+			Where("value IN (?)", bun.In(values))
+	}
+	return query.ColumnExpr("DISTINCT ?::text", bun.Ident(column)).
+		Where("? IN (?)", bun.Ident(column), bun.In(values))
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestCarriedValuesQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		column  string
+		isArray bool
+		want    string
+	}{
+		{
+			name:   "scalar column",
+			column: "title",
+			want:   `SELECT DISTINCT "title"::text FROM "contacts" AS "c" WHERE (deleted_at IS NULL) AND ("title" IN ('CEO', 'CTO'))`,
+		},
+		{
+			name:    "array column",
+			column:  "departments",
+			isArray: true,
+			want: `SELECT DISTINCT value FROM "contacts" AS "c", unnest("departments") AS value ` +
+				`WHERE (deleted_at IS NULL) AND ("departments" && '{"CEO","CTO"}') AND (value IN ('CEO', 'CTO'))`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := carriedValuesQuery(testDB(), (*PgContact)(nil), test.column, []string{"CEO", "CTO"}, test.isArray).String()
+			if got != test.want {
+				t.Errorf("query =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"vivek-ray/constants"
+	"vivek-ray/utilities"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+type ElasticIndexStruct struct {
+	ElasticClient *elasticsearch.Client
+}
+
+func ElasticIndexRepository(client *elasticsearch.Client) ElasticIndexSvcRepo {
+	return &ElasticIndexStruct{
+		ElasticClient: client,
+	}
+}
+
+type ElasticIndexSvcRepo interface {
+	Create(index string, body []byte) error
+	Exists(index string) (bool, error)
+	Delete(indices []string) error
+	Refresh(index string) error
+	GetAliasIndices(alias string) ([]string, error)
+	UpdateAliases(actions []map[string]any) error
+	PutMapping(index string, body []byte) error
+}
+
+func (t *ElasticIndexStruct) Create(index string, body []byte) error {
+	response, err := t.ElasticClient.Indices.Create(
+		index,
+		t.ElasticClient.Indices.Create.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+func (t *ElasticIndexStruct) Exists(index string) (bool, error) {
+	response, err := t.ElasticClient.Indices.Exists([]string{index})
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return false, constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+}
+
+func (t *ElasticIndexStruct) Delete(indices []string) error {
+	if len(indices) == 0 {
+		return nil
+	}
+	response, err := t.ElasticClient.Indices.Delete(indices)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+func (t *ElasticIndexStruct) Refresh(index string) error {
+	response, err := t.ElasticClient.Indices.Refresh(
+		t.ElasticClient.Indices.Refresh.WithIndex(index),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// GetAliasIndices returns the concrete indices the alias points to, or an
+// empty slice when the alias does not exist.
+func (t *ElasticIndexStruct) GetAliasIndices(alias string) ([]string, error) {
+	response, err := t.ElasticClient.Indices.GetAlias(
+		t.ElasticClient.Indices.GetAlias.WithName(alias),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	indices := make([]string, 0)
+	if response.StatusCode == http.StatusNotFound {
+		return indices, nil
+	}
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return nil, constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+
+	var aliasResponse map[string]any
+	if err := json.NewDecoder(response.Body).Decode(&aliasResponse); err != nil {
+		return nil, err
+	}
+	for index := range aliasResponse {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
+
+// UpdateAliases applies all alias actions in a single atomic request.
+func (t *ElasticIndexStruct) UpdateAliases(actions []map[string]any) error {
+	var buf bytes.Buffer
+	if err := utilities.AddToBuffer(&buf, map[string]any{"actions": actions}); err != nil {
+		return err
+	}
+
+	response, err := t.ElasticClient.Indices.UpdateAliases(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// PutMapping adds fields to the mapping of index, which may be an alias.
+func (t *ElasticIndexStruct) PutMapping(index string, body []byte) error {
+	response, err := t.ElasticClient.Indices.PutMapping([]string{index}, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		bodyBytes, _ := io.ReadAll(response.Body)
+		return constants.ElasticsearchError(response.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
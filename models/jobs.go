@@ -3,14 +3,21 @@ package models
 import (
 	"encoding/json"
 	"time"
+	"vivek-ray/utilities"
 
 	"github.com/uptrace/bun"
 )
 
 type JobResponseData struct {
-	RuntimeErrors []string `json:"runtime_errors,omitempty"`
-	Messages      string   `json:"messages,omitempty"`
-	S3Key         string   `json:"s3_key,omitempty"`
+	RuntimeErrors []string                `json:"runtime_errors,omitempty"`
+	Messages      string                  `json:"messages,omitempty"`
+	S3Key         string                  `json:"s3_key,omitempty"`
+	ImportReport  *utilities.ImportReport `json:"import_report,omitempty"`
+	ExportReport  *utilities.ExportReport `json:"export_report,omitempty"`
+	// ExpiredAt is when the files of an export were removed.
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+	// CancelledAt is when the job stopped after being cancelled.
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
 }
 
 type ModelJobs struct {
@@ -24,10 +31,24 @@ type ModelJobs struct {
 	Status      string          `bun:"status,notnull,default:'open'" json:"status"`
 	JobResponse json.RawMessage `bun:"job_response,type:jsonb,default:'{}'" json:"job_response"`
 
+	// RetryCount is how many times a failed job is retried; Attempts counts
+	// its runs, so it dies once Attempts exceeds RetryCount.
 	RetryCount    int        `bun:"retry_count,notnull,default:0" json:"retry_count"`
 	RetryInterval int        `bun:"retry_interval,notnull,default:30" json:"retry_interval"`
+	Attempts      int        `bun:"attempts,notnull,default:0" json:"attempts"`
 	RunAfter      *time.Time `bun:"run_after,nullzero" json:"run_after"`
 
+	// WorkerID is the worker holding the job while it is queued or running;
+	// the worker renews LeaseExpiresAt with every heartbeat, and the reaper
+	// returns jobs whose lease ran out to the queue.
+	WorkerID       string     `bun:"worker_id,nullzero" json:"worker_id"`
+	LeaseExpiresAt *time.Time `bun:"lease_expires_at,nullzero" json:"lease_expires_at"`
+	HeartbeatAt    *time.Time `bun:"heartbeat_at,nullzero" json:"heartbeat_at"`
+
+	// CancelRequestedAt is set by the cancel endpoint; workers stop the job
+	// at their next check.
+	CancelRequestedAt *time.Time `bun:"cancel_requested_at,nullzero" json:"cancel_requested_at"`
+
 	CreatedAt *time.Time `bun:"created_at,nullzero,default:current_timestamp" json:"created_at"`
 	UpdatedAt *time.Time `bun:"updated_at,nullzero,default:current_timestamp" json:"updated_at"`
 }
@@ -37,6 +58,26 @@ func (m *ModelJobs) SetDB(db *bun.DB) *ModelJobs {
 	return m
 }
 
+func (m *ModelJobs) Response() JobResponseData {
+	var resp JobResponseData
+	if len(m.JobResponse) > 0 {
+		json.Unmarshal(m.JobResponse, &resp)
+	}
+	return resp
+}
+
+func (m *ModelJobs) MarkExpired(at time.Time) {
+	resp := m.Response()
+	resp.ExpiredAt = &at
+	m.JobResponse, _ = json.Marshal(resp)
+}
+
+func (m *ModelJobs) MarkCancelled(at time.Time) {
+	resp := m.Response()
+	resp.CancelledAt = &at
+	m.JobResponse, _ = json.Marshal(resp)
+}
+
 func (m *ModelJobs) AddRuntimeError(errMsg string) {
 	var resp JobResponseData
 	if len(m.JobResponse) > 0 {
@@ -63,3 +104,21 @@ func (m *ModelJobs) AddS3Key(s3Key string) {
 	resp.S3Key = s3Key
 	m.JobResponse, _ = json.Marshal(resp)
 }
+
+func (m *ModelJobs) AddImportReport(report utilities.ImportReport) {
+	var resp JobResponseData
+	if len(m.JobResponse) > 0 {
+		json.Unmarshal(m.JobResponse, &resp)
+	}
+	resp.ImportReport = &report
+	m.JobResponse, _ = json.Marshal(resp)
+}
+
+func (m *ModelJobs) AddExportReport(report utilities.ExportReport) {
+	var resp JobResponseData
+	if len(m.JobResponse) > 0 {
+		json.Unmarshal(m.JobResponse, &resp)
+	}
+	resp.ExportReport = &report
+	m.JobResponse, _ = json.Marshal(resp)
+}
@@ -2,6 +2,10 @@ package models
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 	"vivek-ray/constants"
 	"vivek-ray/utilities"
 
@@ -20,13 +24,20 @@ func JobsRepository(db *bun.DB) JobsSvcRepo {
 }
 
 type JobsFilters struct {
-	JobType  string
-	Status   []string
-	Limit    int
+	Uuids   []string
+	JobType string
+	Status  []string
+	Limit   int
+	// Retrying keeps jobs that have retries left.
 	Retrying bool `default:"false"`
+	// Due skips jobs scheduled to run later.
+	Due bool `default:"false"`
 }
 
 func (f *JobsFilters) ToWhereQuery(query *bun.SelectQuery) *bun.SelectQuery {
+	if len(f.Uuids) > 0 {
+		query.Where("uuid IN (?)", bun.In(f.Uuids))
+	}
 	if f.JobType != "" {
 		query.Where("job_type = ?", f.JobType)
 	}
@@ -34,7 +45,10 @@ func (f *JobsFilters) ToWhereQuery(query *bun.SelectQuery) *bun.SelectQuery {
 		query.Where("status IN (?)", bun.In(f.Status))
 	}
 	if f.Retrying {
-		query.Where("retry_count > 0")
+		query.Where("attempts <= retry_count")
+	}
+	if f.Due {
+		query.Where("run_after IS NULL OR run_after <= CURRENT_TIMESTAMP")
 	}
 
 	limit := utilities.InlineIf(f.Limit > 0, f.Limit, constants.DefaultPageSize).(int)
@@ -45,6 +59,14 @@ type JobsSvcRepo interface {
 	Create(job *ModelJobs) (string, error)
 	BulkUpsert(jobs []*ModelJobs) error
 	ListByFilters(filters JobsFilters) ([]*ModelJobs, error)
+	ListExportsToExpire(before time.Time, limit int) ([]*ModelJobs, error)
+	Cancel(uuid string) (*ModelJobs, error)
+	CancelRequested(uuid string) (bool, error)
+	Requeue(uuid string) (*ModelJobs, error)
+	Claim(filters JobsFilters, status, workerID string, lease time.Duration) ([]*ModelJobs, error)
+	SaveLeased(job *ModelJobs, workerID string, lease time.Duration) (bool, error)
+	Heartbeat(workerID string, lease time.Duration) (int64, error)
+	ReapExpired(backoff func(retryInterval, attempts int) time.Duration) (int64, error)
 }
 
 func (t *JobsStruct) Create(job *ModelJobs) (string, error) {
@@ -78,6 +100,7 @@ func (t *JobsStruct) BulkUpsert(jobs []*ModelJobs) error {
 		Set("status = EXCLUDED.status").
 		Set("job_response = EXCLUDED.job_response").
 		Set("retry_count = EXCLUDED.retry_count").
+		Set("attempts = EXCLUDED.attempts").
 		Set("run_after = EXCLUDED.run_after").
 		Set("updated_at = CURRENT_TIMESTAMP").
 		Exec(context.Background())
@@ -92,3 +115,197 @@ func (t *JobsStruct) ListByFilters(filters JobsFilters) ([]*ModelJobs, error) {
 	err := query.Scan(context.Background())
 	return jobs, err
 }
+
+// ListExportsToExpire returns exports completed before the cutoff whose files
+// were not removed yet, oldest first.
+func (t *JobsStruct) ListExportsToExpire(before time.Time, limit int) ([]*ModelJobs, error) {
+	var jobs []*ModelJobs
+	err := t.PgDbClient.NewSelect().
+		Model(&jobs).
+		Where("job_type = ?", constants.ExportCsvFile).
+		Where("status = ?", constants.CompletedJobStatus).
+		Where("updated_at < ?", before).
+		Where("job_response->>'s3_key' IS NOT NULL").
+		Where("job_response->>'expired_at' IS NULL").
+		Order("id ASC").
+		Limit(limit).
+		Scan(context.Background())
+	return jobs, err
+}
+
+// Cancel asks the job to stop. Jobs no worker holds, open or failed, are
+// cancelled at once; the others keep their status until their worker sees
+// the request. It returns nil when the job does not exist or has finished.
+func (t *JobsStruct) Cancel(uuid string) (*ModelJobs, error) {
+	job := new(ModelJobs)
+	err := t.PgDbClient.NewUpdate().
+		Model(job).
+		Set("cancel_requested_at = COALESCE(cancel_requested_at, CURRENT_TIMESTAMP)").
+		Set("status = CASE WHEN status IN (?) THEN ? ELSE status END",
+			bun.In([]string{constants.OpenJobStatus, constants.FailedJobStatus}), constants.CancelledJobStatus).
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("uuid = ?", uuid).
+		Where("status NOT IN (?)", bun.In([]string{constants.CompletedJobStatus, constants.CancelledJobStatus, constants.DeadJobStatus})).
+		Returning("*").
+		Scan(context.Background())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// CancelRequested reports whether the job was asked to stop.
+func (t *JobsStruct) CancelRequested(uuid string) (bool, error) {
+	return t.PgDbClient.NewSelect().
+		Model((*ModelJobs)(nil)).
+		Where("uuid = ?", uuid).
+		Where("cancel_requested_at IS NOT NULL").
+		Exists(context.Background())
+}
+
+// Requeue opens a dead job again with a fresh set of attempts. It returns nil
+// when the job does not exist or is not dead.
+func (t *JobsStruct) Requeue(uuid string) (*ModelJobs, error) {
+	job := new(ModelJobs)
+	err := t.PgDbClient.NewUpdate().
+		Model(job).
+		Set("status = ?", constants.OpenJobStatus).
+		Set("attempts = 0").
+		Set("run_after = NULL").
+		Set("cancel_requested_at = NULL").
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("uuid = ?", uuid).
+		Where("status = ?", constants.DeadJobStatus).
+		Returning("*").
+		Scan(context.Background())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Claim moves the jobs matching the filters to status and leases them to the
+// worker in one statement. Rows another worker is claiming are skipped, so
+// concurrent workers never claim the same job.
+func (t *JobsStruct) Claim(filters JobsFilters, status, workerID string, lease time.Duration) ([]*ModelJobs, error) {
+	var jobs []*ModelJobs
+	claimable := t.PgDbClient.NewSelect().
+		Model((*ModelJobs)(nil)).
+		Column("id").
+		Order("id ASC").
+		For("UPDATE SKIP LOCKED")
+	filters.ToWhereQuery(claimable)
+
+	err := t.PgDbClient.NewUpdate().
+		Model((*ModelJobs)(nil)).
+		Set("status = ?", status).
+		Set("worker_id = ?", workerID).
+		Set("heartbeat_at = CURRENT_TIMESTAMP").
+		Set("lease_expires_at = CURRENT_TIMESTAMP + make_interval(secs => ?)", lease.Seconds()).
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("id IN (?)", claimable).
+		Returning("*").
+		Scan(context.Background(), &jobs)
+	return jobs, err
+}
+
+// SaveLeased writes the job's status, response, attempts and schedule if the
+// worker still holds it. It reports false when the lease was lost, after the
+// reaper returned the job to the queue. The lease is renewed from the database
+// clock, not taken from the job, whose copy is as old as the claim; a job
+// released by clearing its WorkerID gives the lease up.
+func (t *JobsStruct) SaveLeased(job *ModelJobs, workerID string, lease time.Duration) (bool, error) {
+	result, err := saveLeasedQuery(t.PgDbClient, job, workerID, lease).Exec(context.Background())
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+func saveLeasedQuery(db bun.IDB, job *ModelJobs, workerID string, lease time.Duration) *bun.UpdateQuery {
+	query := db.NewUpdate().
+		Model(job).
+		Column("status", "job_response", "attempts", "run_after", "worker_id").
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("uuid = ?", job.UUID).
+		Where("worker_id = ?", workerID).
+		Returning("lease_expires_at")
+	if job.WorkerID == "" {
+		return query.Set("lease_expires_at = NULL")
+	}
+	return query.Set("lease_expires_at = CURRENT_TIMESTAMP + make_interval(secs => ?)", lease.Seconds())
+}
+
+// Heartbeat renews the leases of every job the worker holds.
+func (t *JobsStruct) Heartbeat(workerID string, lease time.Duration) (int64, error) {
+	result, err := t.PgDbClient.NewUpdate().
+		Model((*ModelJobs)(nil)).
+		Set("heartbeat_at = CURRENT_TIMESTAMP").
+		Set("lease_expires_at = CURRENT_TIMESTAMP + make_interval(secs => ?)", lease.Seconds()).
+		Where("worker_id = ?", workerID).
+		Where("status IN (?)", bun.In(constants.LeasedJobStatuses)).
+		Exec(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ReapExpired returns the jobs whose lease ran out to the queue. Queued jobs
+// never started, so they go back as they were claimed. A running job counts
+// its attempt as failed: it is retried after backoff if it has retries left
+// and dead otherwise, so that a job crashing its workers does not run
+// forever. Jobs held from before leases existed have no lease to expire, and
+// are only reaped once they were not updated for LegacyJobReapAfter.
+func (t *JobsStruct) ReapExpired(backoff func(retryInterval, attempts int) time.Duration) (int64, error) {
+	var reaped int64
+	err := t.PgDbClient.RunInTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		var jobs []*ModelJobs
+		err := tx.NewSelect().
+			Model(&jobs).
+			Where("status IN (?)", bun.In(constants.LeasedJobStatuses)).
+			Where("lease_expires_at < CURRENT_TIMESTAMP OR (lease_expires_at IS NULL AND updated_at < CURRENT_TIMESTAMP - make_interval(secs => ?))",
+				constants.LegacyJobReapAfter.Seconds()).
+			Order("id ASC").
+			For("UPDATE SKIP LOCKED").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, job := range jobs {
+			switch job.Status {
+			case constants.InQueueJobStatus:
+				job.Status = constants.OpenJobStatus
+			case constants.RetryInQueuedJobStatus:
+				job.Status = constants.FailedJobStatus
+			default:
+				job.AddRuntimeError(fmt.Sprintf("attempt %d: the lease of worker %s expired while the job was running",
+					job.Attempts, utilities.InlineIf(job.WorkerID != "", job.WorkerID, "unknown").(string)))
+				if job.Attempts > job.RetryCount {
+					job.Status = constants.DeadJobStatus
+				} else {
+					retryAfter := time.Now().Add(backoff(job.RetryInterval, job.Attempts))
+					job.Status = constants.FailedJobStatus
+					job.RunAfter = &retryAfter
+				}
+			}
+			job.WorkerID = ""
+			job.LeaseExpiresAt = nil
+
+			_, err := tx.NewUpdate().
+				Model(job).
+				Column("status", "job_response", "run_after", "worker_id", "lease_expires_at").
+				Set("updated_at = CURRENT_TIMESTAMP").
+				Where("id = ?", job.Id).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		reaped = int64(len(jobs))
+		return nil
+	})
+	return reaped, err
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// testDB renders queries only; it has no connection to run them on.
+func testDB() *bun.DB {
+	return bun.NewDB(&sql.DB{}, pgdialect.New())
+}
+
+func TestSaveLeasedQuery(t *testing.T) {
+	claimedUntil := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		job     *ModelJobs
+		want    string
+		wantNot string
+	}{
+		{
+			name:    "a held job renews its lease from the database clock",
+			job:     &ModelJobs{UUID: "a", WorkerID: "worker-1", LeaseExpiresAt: &claimedUntil},
+			want:    "lease_expires_at = CURRENT_TIMESTAMP + make_interval(secs => 30)",
+			wantNot: claimedUntil.UTC().Format("2006-01-02 15:04:05"),
+		},
+		{
+			name: "a released job gives its lease up",
+			job:  &ModelJobs{UUID: "a", LeaseExpiresAt: &claimedUntil},
+			want: "lease_expires_at = NULL",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := saveLeasedQuery(testDB(), test.job, "worker-1", 30*time.Second).String()
+			if !strings.Contains(query, test.want) {
+				t.Errorf("query %q does not contain %q", query, test.want)
+			}
+			if test.wantNot != "" && strings.Contains(query, test.wantNot) {
+				t.Errorf("query %q writes the lease of the claim", query)
+			}
+			if !strings.Contains(query, "WHERE (uuid = 'a') AND (worker_id = 'worker-1')") {
+				t.Errorf("query %q is not limited to the worker holding the job", query)
+			}
+		})
+	}
+}
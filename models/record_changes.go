@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+	"vivek-ray/constants"
+	"vivek-ray/utilities"
+
+	"github.com/uptrace/bun"
+)
+
+// ModelRecordChange is one append-only entry of the change history of a
+// contact or company. Rows are only ever inserted, never updated.
+type ModelRecordChange struct {
+	db            *bun.DB
+	bun.BaseModel `bun:"table:record_changes,alias:rc"`
+
+	ID         uint64                           `bun:"id,pk,autoincrement" json:"id"`
+	Service    string                           `bun:"service,notnull" json:"service"`
+	RecordUUID string                           `bun:"record_uuid,notnull" json:"record_uuid"`
+	ChangeType string                           `bun:"change_type,notnull" json:"change_type"`
+	Changes    map[string]utilities.FieldChange `bun:"changes,type:jsonb" json:"changes"`
+	Actor      string                           `bun:"actor,notnull" json:"actor"`
+	SourceJob  string                           `bun:"source_job,nullzero" json:"source_job,omitempty"`
+	ChangedAt  *time.Time                       `bun:"changed_at,nullzero,default:current_timestamp" json:"changed_at"`
+}
+
+func (m *ModelRecordChange) SetDB(db *bun.DB) *ModelRecordChange {
+	m.db = db
+	return m
+}
+
+// NewRecordChange diffs incoming against the stored row (nil for a new
+// record) and returns nil when nothing changed.
+func NewRecordChange(service, uuid string, existing, incoming any, provenance utilities.Provenance) *ModelRecordChange {
+	changes := utilities.DiffStruct(existing, incoming)
+	if len(changes) == 0 {
+		return nil
+	}
+	return &ModelRecordChange{
+		Service:    service,
+		RecordUUID: uuid,
+		ChangeType: utilities.InlineIf(existing == nil, constants.ChangeCreate, constants.ChangeUpdate).(string),
+		Changes:    changes,
+		Actor:      provenance.Actor(),
+		SourceJob:  provenance.SourceJob,
+		ChangedAt:  provenance.IngestedAt,
+	}
+}
+
+// RecordState returns the json representation of a record as a generic map,
+// the same shape the values in Changes decode to.
+func RecordState(record any) (map[string]any, error) {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string]any)
+	if err := json.Unmarshal(encoded, &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// RewindState reconstructs a record as it was before the given changes by
+// undoing them on its current state. changes must be ordered newest first.
+// It returns false when one of them created the record, i.e. the record did
+// not exist yet.
+func RewindState(current any, changes []*ModelRecordChange) (map[string]any, bool, error) {
+	state, err := RecordState(current)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, change := range changes {
+		if change.ChangeType == constants.ChangeCreate {
+			return nil, false, nil
+		}
+		for field, fieldChange := range change.Changes {
+			if fieldChange.Old == nil {
+				delete(state, field)
+			} else {
+				state[field] = fieldChange.Old
+			}
+		}
+	}
+	return state, true, nil
+}
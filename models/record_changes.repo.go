@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"time"
+	"vivek-ray/constants"
+
+	"github.com/uptrace/bun"
+)
+
+type RecordChangesStruct struct {
+	PgDbClient bun.IDB
+}
+
+// RecordChangesRepository accepts a bun.IDB so history is written in the same
+// transaction as the upsert it describes.
+func RecordChangesRepository(db bun.IDB) RecordChangesSvcRepo {
+	return &RecordChangesStruct{
+		PgDbClient: db,
+	}
+}
+
+type RecordChangesSvcRepo interface {
+	Insert(changes []*ModelRecordChange) error
+	ListByRecord(service, uuid string, after *time.Time) ([]*ModelRecordChange, error)
+	ListRecordsBySourceJob(sourceJob, service, afterUuid string, limit int) ([]string, error)
+	ListBySourceJob(sourceJob, service string, uuids []string) ([]*ModelRecordChange, error)
+	ListByOtherSources(sourceJob, service string, uuids []string) ([]*ModelRecordChange, error)
+	ListDeletesSince(service string, since time.Time, afterId uint64, limit int) ([]*ModelRecordChange, error)
+}
+
+func (t *RecordChangesStruct) Insert(changes []*ModelRecordChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err := t.PgDbClient.NewInsert().Model(&changes).Exec(context.Background())
+	return err
+}
+
+// ListByRecord returns the history of one record newest first, optionally
+// only the changes made after the given time.
+func (t *RecordChangesStruct) ListByRecord(service, uuid string, after *time.Time) ([]*ModelRecordChange, error) {
+	changes := make([]*ModelRecordChange, 0)
+	queryBuilder := t.PgDbClient.NewSelect().Model(&changes).
+		Where("service = ?", service).
+		Where("record_uuid = ?", uuid)
+	if after != nil {
+		queryBuilder = queryBuilder.Where("changed_at > ?", *after)
+	}
+	err := queryBuilder.Order("changed_at DESC", "id DESC").Scan(context.Background())
+	return changes, err
+}
+
+// ListRecordsBySourceJob pages, in uuid order, through the distinct records
+// of a service that the given job changed.
+func (t *RecordChangesStruct) ListRecordsBySourceJob(sourceJob, service, afterUuid string, limit int) ([]string, error) {
+	uuids := make([]string, 0)
+	err := t.PgDbClient.NewSelect().Model((*ModelRecordChange)(nil)).
+		ColumnExpr("DISTINCT record_uuid").
+		Where("source_job = ?", sourceJob).
+		Where("service = ?", service).
+		Where("record_uuid > ?", afterUuid).
+		Order("record_uuid ASC").
+		Limit(limit).
+		Scan(context.Background(), &uuids)
+	return uuids, err
+}
+
+// ListBySourceJob returns the changes the given job made to the records,
+// oldest first.
+func (t *RecordChangesStruct) ListBySourceJob(sourceJob, service string, uuids []string) ([]*ModelRecordChange, error) {
+	changes := make([]*ModelRecordChange, 0)
+	if len(uuids) == 0 {
+		return changes, nil
+	}
+	err := t.PgDbClient.NewSelect().Model(&changes).
+		Where("source_job = ?", sourceJob).
+		Where("service = ?", service).
+		Where("record_uuid IN (?)", bun.In(uuids)).
+		Order("changed_at ASC", "id ASC").
+		Scan(context.Background())
+	return changes, err
+}
+
+// ListByOtherSources returns the changes to the records made by anything but
+// the given job, including API edits without a source job, oldest first.
+func (t *RecordChangesStruct) ListByOtherSources(sourceJob, service string, uuids []string) ([]*ModelRecordChange, error) {
+	changes := make([]*ModelRecordChange, 0)
+	if len(uuids) == 0 {
+		return changes, nil
+	}
+	err := t.PgDbClient.NewSelect().Model(&changes).
+		Where("source_job IS DISTINCT FROM ?", sourceJob).
+		Where("service = ?", service).
+		Where("record_uuid IN (?)", bun.In(uuids)).
+		Order("changed_at ASC", "id ASC").
+		Scan(context.Background())
+	return changes, err
+}
+
+// ListDeletesSince pages, in id order, through the deletes of a service logged
+// at or after since. Hard deleted rows are only ever purged after a soft
+// delete, so these cover every record removed in that window.
+func (t *RecordChangesStruct) ListDeletesSince(service string, since time.Time, afterId uint64, limit int) ([]*ModelRecordChange, error) {
+	changes := make([]*ModelRecordChange, 0)
+	err := t.PgDbClient.NewSelect().Model(&changes).
+		Column("id", "record_uuid").
+		Where("service = ?", service).
+		Where("change_type = ?", constants.ChangeDelete).
+		Where("changed_at >= ?", since).
+		Where("id > ?", afterId).
+		Order("id ASC").
+		Limit(limit).
+		Scan(context.Background())
+	return changes, err
+}
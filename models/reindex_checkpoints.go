@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// ModelReindexCheckpoint is saved after every batch so an interrupted reindex
+// can continue into the same index instead of starting over.
+type ModelReindexCheckpoint struct {
+	db            *bun.DB
+	bun.BaseModel `bun:"table:reindex_checkpoints,alias:rc"`
+
+	Service   string    `bun:"service,pk" json:"service"`
+	Index     string    `bun:"index_name,notnull" json:"index"`
+	LastId    uint64    `bun:"last_id,notnull" json:"last_id"`
+	Indexed   int64     `bun:"indexed,notnull" json:"indexed"`
+	StartedAt time.Time `bun:"started_at,notnull" json:"started_at"`
+
+	UpdatedAt *time.Time `bun:"updated_at,nullzero,default:current_timestamp" json:"updated_at"`
+}
+
+func (m *ModelReindexCheckpoint) SetDB(db *bun.DB) *ModelReindexCheckpoint {
+	m.db = db
+	return m
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+type ReindexCheckpointsStruct struct {
+	PgDbClient *bun.DB
+}
+
+func ReindexCheckpointsRepository(db *bun.DB) ReindexCheckpointsSvcRepo {
+	return &ReindexCheckpointsStruct{
+		PgDbClient: db,
+	}
+}
+
+type ReindexCheckpointsSvcRepo interface {
+	Get(service string) (*ModelReindexCheckpoint, error)
+	Save(checkpoint *ModelReindexCheckpoint) error
+	Delete(service string) error
+}
+
+// Get returns the checkpoint of the service, or nil when no reindex of it is
+// in progress.
+func (t *ReindexCheckpointsStruct) Get(service string) (*ModelReindexCheckpoint, error) {
+	checkpoints := make([]*ModelReindexCheckpoint, 0)
+	err := t.PgDbClient.NewSelect().Model(&checkpoints).
+		Where("service = ?", service).Limit(1).Scan(context.Background())
+	if err != nil || len(checkpoints) == 0 {
+		return nil, err
+	}
+	return checkpoints[0], nil
+}
+
+// Save replaces the checkpoint of its service.
+func (t *ReindexCheckpointsStruct) Save(checkpoint *ModelReindexCheckpoint) error {
+	_, err := t.PgDbClient.NewInsert().Model(checkpoint).
+		On("CONFLICT (service) DO UPDATE").
+		Set("index_name = EXCLUDED.index_name").
+		Set("last_id = EXCLUDED.last_id").
+		Set("indexed = EXCLUDED.indexed").
+		Set("started_at = EXCLUDED.started_at").
+		Set("updated_at = current_timestamp").
+		Exec(context.Background())
+	return err
+}
+
+func (t *ReindexCheckpointsStruct) Delete(service string) error {
+	_, err := t.PgDbClient.NewDelete().
+		Model((*ModelReindexCheckpoint)(nil)).
+		Where("service = ?", service).
+		Exec(context.Background())
+	return err
+}
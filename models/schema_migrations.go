@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type ModelSchemaMigration struct {
+	db            *bun.DB
+	bun.BaseModel `bun:"table:schema_migrations,alias:sm"`
+
+	Version   int        `bun:"version,pk" json:"version"`
+	Name      string     `bun:"name,notnull" json:"name"`
+	AppliedAt *time.Time `bun:"applied_at,nullzero,default:current_timestamp" json:"applied_at"`
+}
+
+func (m *ModelSchemaMigration) SetDB(db *bun.DB) *ModelSchemaMigration {
+	m.db = db
+	return m
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+type SchemaMigrationsStruct struct {
+	PgDbClient bun.IDB
+}
+
+// SchemaMigrationsRepository accepts a bun.IDB so the record can be written
+// inside the same transaction as the migration itself.
+func SchemaMigrationsRepository(db bun.IDB) SchemaMigrationsSvcRepo {
+	return &SchemaMigrationsStruct{
+		PgDbClient: db,
+	}
+}
+
+type SchemaMigrationsSvcRepo interface {
+	EnsureTable() error
+	TableExists() (bool, error)
+	ListApplied() ([]*ModelSchemaMigration, error)
+	Insert(migration *ModelSchemaMigration) error
+	Delete(version int) error
+}
+
+func (t *SchemaMigrationsStruct) EnsureTable() error {
+	_, err := t.PgDbClient.NewCreateTable().
+		Model((*ModelSchemaMigration)(nil)).
+		IfNotExists().
+		Exec(context.Background())
+	return err
+}
+
+// TableExists reports whether schema_migrations has been created, without
+// creating it, so that read-only callers leave the database as they found it.
+func (t *SchemaMigrationsStruct) TableExists() (bool, error) {
+	var exists bool
+	err := t.PgDbClient.NewSelect().
+		ColumnExpr("to_regclass('schema_migrations') IS NOT NULL").
+		Scan(context.Background(), &exists)
+	return exists, err
+}
+
+func (t *SchemaMigrationsStruct) ListApplied() ([]*ModelSchemaMigration, error) {
+	migrations := make([]*ModelSchemaMigration, 0)
+	err := t.PgDbClient.NewSelect().Model(&migrations).Order("version ASC").Scan(context.Background())
+	return migrations, err
+}
+
+func (t *SchemaMigrationsStruct) Insert(migration *ModelSchemaMigration) error {
+	_, err := t.PgDbClient.NewInsert().Model(migration).Exec(context.Background())
+	return err
+}
+
+func (t *SchemaMigrationsStruct) Delete(version int) error {
+	_, err := t.PgDbClient.NewDelete().
+		Model((*ModelSchemaMigration)(nil)).
+		Where("version = ?", version).
+		Exec(context.Background())
+	return err
+}
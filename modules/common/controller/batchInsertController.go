@@ -20,13 +20,15 @@ func BatchUpsert(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to initialize batch service", "success": false})
 		return
 	}
-	if err := batchService.ProcessBatchUpsert(request.Data); err != nil {
+	stats, err := batchService.ProcessBatchUpsert(request.Data, request.UpsertOptions)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Batch upsert successful",
+		"data":    stats,
 		"success": true,
 	})
 }
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"vivek-ray/constants"
+	"vivek-ray/modules/common/helper"
+	"vivek-ray/modules/common/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportTemplateErrorStatus maps the errors of the export template endpoints
+// to an HTTP status.
+func exportTemplateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, constants.ExportTemplateNotFoundError):
+		return http.StatusNotFound
+	case errors.Is(err, constants.ExportTemplateExistsError):
+		return http.StatusConflict
+	case errors.Is(err, constants.InvalidServiceTypeError):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func ListExportTemplates(c *gin.Context) {
+	templates, err := service.NewExportTemplateService().ListExportTemplates(c.Param("service"))
+	if err != nil {
+		c.JSON(exportTemplateErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": templates, "success": true})
+}
+
+func GetExportTemplate(c *gin.Context) {
+	template, err := service.NewExportTemplateService().GetExportTemplate(c.Param("service"), c.Param("name"))
+	if err != nil {
+		c.JSON(exportTemplateErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": template, "success": true})
+}
+
+func CreateExportTemplate(c *gin.Context) {
+	request, err := helper.BindExportTemplate(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	template, err := service.NewExportTemplateService().CreateExportTemplate(c.Param("service"), request)
+	if err != nil {
+		c.JSON(exportTemplateErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": template, "success": true})
+}
+
+func UpdateExportTemplate(c *gin.Context) {
+	request, err := helper.BindExportTemplate(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	template, err := service.NewExportTemplateService().UpdateExportTemplate(c.Param("service"), request)
+	if err != nil {
+		c.JSON(exportTemplateErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": template, "success": true})
+}
+
+func DeleteExportTemplate(c *gin.Context) {
+	if err := service.NewExportTemplateService().DeleteExportTemplate(c.Param("service"), c.Param("name")); err != nil {
+		c.JSON(exportTemplateErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
+	"vivek-ray/constants"
 	"vivek-ray/modules/common/helper"
 	"vivek-ray/modules/common/service"
 
@@ -31,10 +33,94 @@ func GetFilterData(c *gin.Context) {
 
 	result, err := service.NewFilterService().GetFilterData(serviceType, query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		c.JSON(filterErrorStatus(err), gin.H{"error": err.Error(), "success": false})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": result, "success": true})
 }
 
+// filterErrorStatus maps the errors of the filter endpoints to an HTTP status.
+func filterErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, constants.FilterNotFoundError):
+		return http.StatusNotFound
+	case errors.Is(err, constants.FilterExistsError):
+		return http.StatusConflict
+	case errors.Is(err, constants.InvalidServiceTypeError), errors.Is(err, constants.FilterNotAggregatableError):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func ListAllFilters(c *gin.Context) {
+	result, err := service.NewFilterService().ListAllFilters(c.Param("service"))
+	if err != nil {
+		c.JSON(filterErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": result, "success": true})
+}
+
+func CreateFilter(c *gin.Context) {
+	request, err := helper.BindCreateFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	filter, err := service.NewFilterService().CreateFilter(c.Param("service"), request)
+	if err != nil {
+		c.JSON(filterErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": filter, "success": true})
+}
+
+func UpdateFilter(c *gin.Context) {
+	request, err := helper.BindUpdateFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	filter, err := service.NewFilterService().UpdateFilter(c.Param("service"), request)
+	if err != nil {
+		c.JSON(filterErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": filter, "success": true})
+}
+
+func UpdateFilterStatus(c *gin.Context) {
+	request, err := helper.BindFilterUpdateStatus(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err := service.NewFilterService().UpdateFilterStatus(c.Param("service"), request); err != nil {
+		c.JSON(filterErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func ReorderFilters(c *gin.Context) {
+	request, err := helper.BindFilterOrder(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	filters, err := service.NewFilterService().ReorderFilters(c.Param("service"), request.Keys)
+	if err != nil {
+		c.JSON(filterErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": filters, "success": true})
+}
+
+func DeleteFilter(c *gin.Context) {
+	if err := service.NewFilterService().DeleteFilter(c.Param("service"), c.Param("key")); err != nil {
+		c.JSON(filterErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
+	"vivek-ray/constants"
 	"vivek-ray/modules/common/helper"
 	"vivek-ray/modules/common/service"
 
@@ -45,3 +47,86 @@ func ListJobs(c *gin.Context) {
 		"success": true,
 	})
 }
+
+func exportDownloadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, constants.JobNotFoundError):
+		return http.StatusNotFound
+	case errors.Is(err, constants.JobNotExportError):
+		return http.StatusBadRequest
+	case errors.Is(err, constants.ExportNotReadyError):
+		return http.StatusConflict
+	case errors.Is(err, constants.ExportExpiredError):
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+func DownloadExport(c *gin.Context) {
+	ttl, err := helper.BindDownloadTTL(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	download, err := service.NewJobService().DownloadExport(c.Param("uuid"), ttl)
+	if err != nil {
+		c.JSON(exportDownloadErrorStatus(err), gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    download,
+		"success": true,
+	})
+}
+
+func CancelJob(c *gin.Context) {
+	job, err := service.NewJobService().CancelJob(c.Param("uuid"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, constants.JobNotFoundError):
+			status = http.StatusNotFound
+		case errors.Is(err, constants.JobNotCancellableError):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	if job.Status != constants.CancelledJobStatus {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "Cancellation requested; the job stops after its current batch",
+			"data":    job,
+			"success": true,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Job cancelled successfully",
+		"data":    job,
+		"success": true,
+	})
+}
+
+func RequeueJob(c *gin.Context) {
+	job, err := service.NewJobService().RequeueJob(c.Param("uuid"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, constants.JobNotFoundError):
+			status = http.StatusNotFound
+		case errors.Is(err, constants.JobNotDeadError):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Job requeued successfully",
+		"data":    job,
+		"success": true,
+	})
+}
@@ -2,6 +2,9 @@ package helper
 
 import (
 	"encoding/json"
+	"strconv"
+	"time"
+	"vivek-ray/conf"
 	"vivek-ray/constants"
 	"vivek-ray/models"
 	"vivek-ray/utilities"
@@ -11,6 +14,7 @@ import (
 
 type BatchInsertRequest struct {
 	Data []map[string]string `json:"data" binding:"required"`
+	utilities.UpsertOptions
 }
 
 func BindAndValidateBatchInsert(c *gin.Context) (BatchInsertRequest, error) {
@@ -27,9 +31,66 @@ func BindAndValidateBatchInsert(c *gin.Context) (BatchInsertRequest, error) {
 		return request, constants.BatchSizeExceededError
 	}
 
+	if err := request.MergePolicy.Validate(&models.PgContact{}, &models.PgCompany{}); err != nil {
+		return request, err
+	}
+	request.Provenance = APIProvenance(c)
+
 	return request, nil
 }
 
+// APIProvenance attributes records written by a direct API call to the
+// caller's API key fingerprint; rows are numbered from 1 in request order.
+func APIProvenance(c *gin.Context) utilities.Provenance {
+	return utilities.Provenance{
+		SourceAPIKey: utilities.APIKeyFingerprint(c.GetHeader("X-API-Key")),
+		SourceRow:    1,
+	}
+}
+
+// BindUpsertOptions reads the merge policy of the entity batch-upsert
+// endpoints, whose body is a bare array, from the query string.
+func BindUpsertOptions(c *gin.Context) (utilities.UpsertOptions, error) {
+	options := utilities.UpsertOptions{
+		MergePolicy: utilities.MergePolicy{Default: c.Query("merge_policy")},
+		SourceDate:  utilities.ParseSourceDate(c.Query("source_date")),
+		Provenance:  APIProvenance(c),
+	}
+	if err := options.MergePolicy.Validate(); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+func BindAsOfQuery(c *gin.Context) (time.Time, error) {
+	asOf, err := time.Parse(time.RFC3339, c.Query("as_of"))
+	if err != nil {
+		return asOf, constants.InvalidAsOfError
+	}
+	return asOf, nil
+}
+
+// BindRecordPatch reads a PATCH body: every key except updated_at, the
+// optimistic concurrency token, is a field to update.
+func BindRecordPatch(c *gin.Context) (utilities.RecordPatch, error) {
+	patch := utilities.RecordPatch{Fields: make(map[string]json.RawMessage)}
+	if err := c.ShouldBindJSON(&patch.Fields); err != nil {
+		return patch, err
+	}
+	if raw, ok := patch.Fields["updated_at"]; ok {
+		delete(patch.Fields, "updated_at")
+		if err := json.Unmarshal(raw, &patch.UpdatedAt); err != nil {
+			return patch, err
+		}
+	}
+	if len(patch.Fields) == 0 {
+		return patch, constants.EmptyPatchError
+	}
+	serverTime := time.Now()
+	patch.Provenance = APIProvenance(c).AtRow(0, &serverTime)
+	return patch, nil
+}
+
 func BindAndValidateFiltersDataQuery(c *gin.Context) (models.FiltersDataQuery, error) {
 	var query models.FiltersDataQuery
 	if err := c.ShouldBindJSON(&query); err != nil {
@@ -38,13 +99,112 @@ func BindAndValidateFiltersDataQuery(c *gin.Context) (models.FiltersDataQuery, e
 	if err := utilities.ValidatePageSize(query.Limit); err != nil {
 		return query, err
 	}
+	if query.SortBy != "" && query.SortBy != constants.FilterSortCount && query.SortBy != constants.FilterSortAlpha {
+		return query, constants.InvalidFilterSortError(query.SortBy)
+	}
 	return query, nil
 }
 
+type FilterStatusUpdate struct {
+	Active    bool   `json:"active"`
+	FilterKey string `json:"filter_key"`
+}
+
+func BindFilterUpdateStatus(c *gin.Context) (FilterStatusUpdate, error) {
+	var statusUpdate FilterStatusUpdate
+	if err := c.ShouldBindJSON(&statusUpdate); err != nil {
+		return statusUpdate, err
+	}
+	if statusUpdate.FilterKey == "" {
+		return statusUpdate, constants.FilterKeyRequiredError
+	}
+	return statusUpdate, nil
+}
+
+// FilterRequest creates a filter, or edits one when sent to the PATCH
+// endpoint, in which case absent fields are left unchanged and the key comes
+// from the path.
+type FilterRequest struct {
+	Key           string  `json:"key"`
+	DisplayName   *string `json:"display_name"`
+	FilterType    *string `json:"filter_type"`
+	DirectDerived *bool   `json:"direct_derived"`
+}
+
+// isServiceField reports whether key is a data column of the service's
+// records, the only columns a filter can read values from. Unknown services
+// are rejected later by the filter service.
+func isServiceField(service, key string) bool {
+	switch service {
+	case constants.ContactsService:
+		return utilities.IsDataField(&models.PgContact{}, key)
+	case constants.CompaniesService:
+		return utilities.IsDataField(&models.PgCompany{}, key)
+	}
+	return true
+}
+
+func validateFilterType(filterType *string) error {
+	if filterType == nil {
+		return nil
+	}
+	switch *filterType {
+	case constants.FilterTypeKeyword, constants.FilterTypeText, constants.FilterTypeRange:
+		return nil
+	}
+	return constants.InvalidFilterTypeError(*filterType)
+}
+
+func BindCreateFilter(c *gin.Context) (FilterRequest, error) {
+	var request FilterRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return request, err
+	}
+	if request.Key == "" {
+		return request, constants.FilterKeyRequiredError
+	}
+	if !isServiceField(c.Param("service"), request.Key) {
+		return request, constants.InvalidFilterKeyError(request.Key, c.Param("service"))
+	}
+	if request.DisplayName == nil || *request.DisplayName == "" {
+		return request, constants.DisplayNameRequiredError
+	}
+	if request.FilterType == nil {
+		filterType := constants.FilterTypeKeyword
+		request.FilterType = &filterType
+	}
+	return request, validateFilterType(request.FilterType)
+}
+
+func BindUpdateFilter(c *gin.Context) (FilterRequest, error) {
+	var request FilterRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return request, err
+	}
+	request.Key = c.Param("key")
+	if request.DisplayName != nil && *request.DisplayName == "" {
+		return request, constants.DisplayNameRequiredError
+	}
+	return request, validateFilterType(request.FilterType)
+}
+
+type FilterOrderRequest struct {
+	Keys []string `json:"keys" binding:"required"`
+}
+
+func BindFilterOrder(c *gin.Context) (FilterOrderRequest, error) {
+	var request FilterOrderRequest
+	err := c.ShouldBindJSON(&request)
+	return request, err
+}
+
 type CreateJobRequest struct {
 	JobType    string          `json:"job_type" binding:"required"`
 	JobData    json.RawMessage `json:"job_data" binding:"required"`
 	RetryCount int             `json:"retry_count"`
+	// RetryInterval is the wait in seconds before the first retry, doubled
+	// for every retry after it; 0 keeps the default of 30.
+	RetryInterval int `json:"retry_interval"`
 }
 
 func BindAndValidateCreateJob(c *gin.Context) (CreateJobRequest, error) {
@@ -59,6 +219,9 @@ func BindAndValidateCreateJob(c *gin.Context) (CreateJobRequest, error) {
 	if request.RetryCount < 0 {
 		return request, constants.RetryCountNegativeError
 	}
+	if request.RetryInterval < 0 {
+		return request, constants.RetryIntervalNegativeError
+	}
 	if len(request.JobData) == 0 {
 		return request, constants.JobDataRequiredError
 	}
@@ -87,3 +250,53 @@ func BindAndValidateListJobs(c *gin.Context) (ListJobsRequest, error) {
 
 	return request, nil
 }
+
+// BindDownloadTTL reads how long export download links stay valid from the
+// optional ttl_minutes query, falling back to S3_DOWNLOAD_URL_TTL_MINUTES.
+func BindDownloadTTL(c *gin.Context) (time.Duration, error) {
+	minutes := utilities.InlineIf(conf.S3StorageConfig.S3DownloadURLTTL > 0, conf.S3StorageConfig.S3DownloadURLTTL, constants.DefaultDownloadURLTTLMinutes).(int)
+	if raw := c.Query("ttl_minutes"); raw != "" {
+		var err error
+		if minutes, err = strconv.Atoi(raw); err != nil {
+			return 0, constants.InvalidDownloadTTLError
+		}
+	}
+	if minutes <= 0 || minutes > constants.MaxDownloadURLTTLMinutes {
+		return 0, constants.InvalidDownloadTTLError
+	}
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// ExportTemplateRequest creates an export template, or replaces the columns
+// of one when sent to the PUT endpoint, in which case the name comes from the
+// path.
+type ExportTemplateRequest struct {
+	Name    string                           `json:"name"`
+	Columns []utilities.ExportTemplateColumn `json:"columns"`
+}
+
+func BindExportTemplate(c *gin.Context) (ExportTemplateRequest, error) {
+	var request ExportTemplateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return request, err
+	}
+	if name := c.Param("name"); name != "" {
+		request.Name = name
+	}
+	if request.Name == "" {
+		return request, constants.ExportTemplateNameRequiredError
+	}
+	if err := utilities.ValidateExportTemplate(request.Columns); err != nil {
+		return request, err
+	}
+	service := c.Param("service")
+	if service != constants.ContactsService && service != constants.CompaniesService {
+		return request, nil
+	}
+	for _, source := range utilities.ExportTemplateSources(request.Columns) {
+		if models.ExportColumnType(service, source) == nil {
+			return request, constants.InvalidExportColumnError(source, service)
+		}
+	}
+	return request, nil
+}
@@ -1,10 +1,15 @@
 package helper
 
-import "vivek-ray/models"
+import (
+	"time"
+	"vivek-ray/models"
+)
 
 type FilterDataResponse struct {
 	Value        string `json:"value"`
 	DisplayValue string `json:"display_value"`
+	// Count is set when the options are scoped to a 'where' query.
+	Count *int64 `json:"count,omitempty"`
 }
 
 func ToFilterDataResponses(data []*models.ModelFilterData) []FilterDataResponse {
@@ -18,3 +23,23 @@ func ToFilterDataResponses(data []*models.ModelFilterData) []FilterDataResponse
 	return responses
 }
 
+// ExportDownloadFile is one file of an export with a presigned link to it.
+type ExportDownloadFile struct {
+	S3Key    string `json:"s3_key"`
+	URL      string `json:"url"`
+	Rows     int64  `json:"rows,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	Checksum string `json:"sha256,omitempty"`
+}
+
+// ExportDownloadResponse links to the files of a completed export. URL is the
+// bundle, or the file of an export that was not split.
+type ExportDownloadResponse struct {
+	JobUUID   string               `json:"job_uuid"`
+	URL       string               `json:"url,omitempty"`
+	Parts     []ExportDownloadFile `json:"parts"`
+	Bundle    *ExportDownloadFile  `json:"bundle,omitempty"`
+	Manifest  *ExportDownloadFile  `json:"manifest,omitempty"`
+	ExpiresIn string               `json:"expires_in"`
+	ExpiresAt time.Time            `json:"expires_at"`
+}
@@ -15,8 +15,26 @@ func Routes(router *gin.RouterGroup) {
 	// Jobs
 	router.POST("/jobs", controller.ListJobs)
 	router.POST("/jobs/create", controller.CreateJob)
+	router.GET("/jobs/:uuid/download", controller.DownloadExport)
+	router.POST("/jobs/:uuid/cancel", controller.CancelJob)
+	router.POST("/jobs/:uuid/requeue", controller.RequeueJob)
 
 	// Filters
 	router.GET("/:service/filters", controller.GetFilters)
 	router.POST("/:service/filters/data", controller.GetFilterData)
+
+	// Filters administration
+	router.GET("/:service/filters/admin", controller.ListAllFilters)
+	router.POST("/:service/filters/admin", controller.CreateFilter)
+	router.PUT("/:service/filters/admin/status", controller.UpdateFilterStatus)
+	router.PUT("/:service/filters/admin/order", controller.ReorderFilters)
+	router.PATCH("/:service/filters/admin/:key", controller.UpdateFilter)
+	router.DELETE("/:service/filters/admin/:key", controller.DeleteFilter)
+
+	// Export templates
+	router.GET("/:service/export-templates", controller.ListExportTemplates)
+	router.POST("/:service/export-templates", controller.CreateExportTemplate)
+	router.GET("/:service/export-templates/:name", controller.GetExportTemplate)
+	router.PUT("/:service/export-templates/:name", controller.UpdateExportTemplate)
+	router.DELETE("/:service/export-templates/:name", controller.DeleteExportTemplate)
 }
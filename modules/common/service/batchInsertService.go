@@ -1,8 +1,7 @@
 package service
 
 import (
-	"errors"
-	"sync"
+	"time"
 	"vivek-ray/connections"
 	"vivek-ray/models"
 	companyService "vivek-ray/modules/companies/service"
@@ -13,7 +12,7 @@ import (
 )
 
 type BatchUpsertSvc interface {
-	ProcessBatchUpsert(batch []map[string]string) error
+	ProcessBatchUpsert(batch []map[string]string, options utilities.UpsertOptions) (utilities.ImportStats, error)
 }
 
 type batchUpsertService struct {
@@ -33,37 +32,7 @@ func NewBatchUpsertService() BatchUpsertSvc {
 	}
 }
 
-func (s *batchUpsertService) UpsertBatch(pgCompanies []*models.PgCompany, pgContacts []*models.PgContact,
-	esCompanies []*models.ElasticCompany, esContacts []*models.ElasticContact) error {
-
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var insertionError error
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		if err := s.companyService.BulkUpsert(pgCompanies, esCompanies); err != nil {
-			mu.Lock()
-			insertionError = errors.Join(insertionError, err)
-			mu.Unlock()
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		if err := s.contactService.BulkUpsert(pgContacts, esContacts); err != nil {
-			mu.Lock()
-			insertionError = errors.Join(insertionError, err)
-			mu.Unlock()
-		}
-	}()
-
-	wg.Wait()
-	return insertionError
-}
-
-func (s *batchUpsertService) ProcessBatchUpsert(batch []map[string]string) error {
+func (s *batchUpsertService) ProcessBatchUpsert(batch []map[string]string, options utilities.UpsertOptions) (utilities.ImportStats, error) {
 	cleanedBatch := make([]map[string]string, 0, len(batch))
 	for _, row := range batch {
 		cleanedRow := make(map[string]string)
@@ -75,27 +44,42 @@ func (s *batchUpsertService) ProcessBatchUpsert(batch []map[string]string) error
 
 	pgCompanies := make([]*models.PgCompany, 0)
 	pgContacts := make([]*models.PgContact, 0)
-	esCompanies := make([]*models.ElasticCompany, 0)
-	esContacts := make([]*models.ElasticContact, 0)
 
-	insertedCompanies, insertedContacts := make(map[string]struct{}), make(map[string]struct{})
-	for _, row := range cleanedBatch {
-		company := models.PgCompanyFromRawData(row)
-		contact := models.PgContactFromRowData(row, company)
-		elasticCompany := models.ElasticCompanyFromRawData(company)
-		elasticContact := models.ElasticContactFromRawData(contact, company)
+	serverTime := time.Now()
+	insertedCompanies, insertedContacts := make(map[string]*models.PgCompany), make(map[string]struct{})
+	for i, row := range cleanedBatch {
+		provenance := options.Provenance.AtRow(i, &serverTime)
+		company := models.PgCompanyFromRawData(row).SetProvenance(provenance)
+		contact := models.PgContactFromRowData(row, company).SetProvenance(provenance)
 
 		if _, ok := insertedCompanies[company.UUID]; !ok {
-			insertedCompanies[company.UUID] = struct{}{}
+			insertedCompanies[company.UUID] = company
 			pgCompanies = append(pgCompanies, company)
-			esCompanies = append(esCompanies, elasticCompany)
 		}
 
 		if _, ok := insertedContacts[contact.UUID]; !ok {
 			insertedContacts[contact.UUID] = struct{}{}
 			pgContacts = append(pgContacts, contact)
-			esContacts = append(esContacts, elasticContact)
 		}
 	}
-	return s.UpsertBatch(pgCompanies, pgContacts, esCompanies, esContacts)
+
+	// Companies go first: their merge settles the values the contact documents
+	// copy from the companies of this batch.
+	var stats utilities.ImportStats
+	var err error
+	if stats.Companies, err = s.companyService.UpsertMerged(pgCompanies, options); err != nil {
+		return stats, err
+	}
+	stats.Contacts, err = s.contactService.UpsertMerged(pgContacts, options, func(pgContacts []*models.PgContact) ([]*models.ElasticContact, error) {
+		esContacts := make([]*models.ElasticContact, 0, len(pgContacts))
+		for _, contact := range pgContacts {
+			company, ok := insertedCompanies[contact.CompanyID]
+			if !ok {
+				company = &models.PgCompany{}
+			}
+			esContacts = append(esContacts, models.ElasticContactFromRawData(contact, company))
+		}
+		return esContacts, nil
+	})
+	return stats, err
 }
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"vivek-ray/connections"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	"vivek-ray/modules/common/helper"
+)
+
+type ExportTemplateSvc interface {
+	ListExportTemplates(serviceType string) ([]*models.ModelExportTemplate, error)
+	GetExportTemplate(serviceType, name string) (*models.ModelExportTemplate, error)
+	CreateExportTemplate(serviceType string, request helper.ExportTemplateRequest) (*models.ModelExportTemplate, error)
+	UpdateExportTemplate(serviceType string, request helper.ExportTemplateRequest) (*models.ModelExportTemplate, error)
+	DeleteExportTemplate(serviceType, name string) error
+}
+
+type exportTemplateService struct {
+	exportTemplatesRepository models.ExportTemplatesSvcRepo
+}
+
+func NewExportTemplateService() ExportTemplateSvc {
+	return &exportTemplateService{
+		exportTemplatesRepository: models.ExportTemplatesRepository(connections.PgDBConnection.Client),
+	}
+}
+
+func (s *exportTemplateService) ListExportTemplates(serviceType string) ([]*models.ModelExportTemplate, error) {
+	if !isValidService(serviceType) {
+		return nil, constants.InvalidServiceTypeError
+	}
+	return s.exportTemplatesRepository.ListByService(serviceType)
+}
+
+func (s *exportTemplateService) GetExportTemplate(serviceType, name string) (*models.ModelExportTemplate, error) {
+	if !isValidService(serviceType) {
+		return nil, constants.InvalidServiceTypeError
+	}
+	template, err := s.exportTemplatesRepository.GetByName(serviceType, name)
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return nil, constants.ExportTemplateNotFoundError
+	}
+	return template, nil
+}
+
+func (s *exportTemplateService) CreateExportTemplate(serviceType string, request helper.ExportTemplateRequest) (*models.ModelExportTemplate, error) {
+	if !isValidService(serviceType) {
+		return nil, constants.InvalidServiceTypeError
+	}
+	template := &models.ModelExportTemplate{
+		Service: serviceType,
+		Name:    request.Name,
+		Columns: request.Columns,
+	}
+	created, err := s.exportTemplatesRepository.Create(template)
+	if err != nil {
+		return nil, err
+	}
+	if created == 0 {
+		return nil, constants.ExportTemplateExistsError
+	}
+	return template, nil
+}
+
+func (s *exportTemplateService) UpdateExportTemplate(serviceType string, request helper.ExportTemplateRequest) (*models.ModelExportTemplate, error) {
+	template, err := s.GetExportTemplate(serviceType, request.Name)
+	if err != nil {
+		return nil, err
+	}
+	template.Columns = request.Columns
+	if err := s.exportTemplatesRepository.Update(template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (s *exportTemplateService) DeleteExportTemplate(serviceType, name string) error {
+	if !isValidService(serviceType) {
+		return constants.InvalidServiceTypeError
+	}
+	deleted, err := s.exportTemplatesRepository.SoftDelete(serviceType, name)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return constants.ExportTemplateNotFoundError
+	}
+	return nil
+}
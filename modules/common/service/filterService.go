@@ -1,16 +1,27 @@
 package service
 
 import (
+	"encoding/json"
+	"fmt"
+	"slices"
 	"vivek-ray/connections"
 	"vivek-ray/constants"
 	"vivek-ray/models"
 	"vivek-ray/modules/common/helper"
 	"vivek-ray/utilities"
+
+	"github.com/google/uuid"
 )
 
 type FilterSvc interface {
 	GetFilters(serviceType string) ([]*models.ModelFilter, error)
 	GetFilterData(serviceType string, query models.FiltersDataQuery) ([]helper.FilterDataResponse, error)
+	ListAllFilters(serviceType string) ([]*models.ModelFilter, error)
+	CreateFilter(serviceType string, request helper.FilterRequest) (*models.ModelFilter, error)
+	UpdateFilter(serviceType string, request helper.FilterRequest) (*models.ModelFilter, error)
+	UpdateFilterStatus(serviceType string, request helper.FilterStatusUpdate) error
+	ReorderFilters(serviceType string, keys []string) ([]*models.ModelFilter, error)
+	DeleteFilter(serviceType, key string) error
 }
 
 type filterService struct {
@@ -18,6 +29,10 @@ type filterService struct {
 	filtersDataRepository models.FiltersDataSvcRepo
 	pgCompanyRepository   models.PgCompanySvcRepo
 	pgContactRepository   models.PgContactSvcRepo
+	jobsRepository        models.JobsSvcRepo
+
+	elasticCompanyRepository models.ElasticCompanySvcRepo
+	elasticContactRepository models.ElasticContactSvcRepo
 }
 
 func NewFilterService() FilterSvc {
@@ -26,6 +41,10 @@ func NewFilterService() FilterSvc {
 		filtersDataRepository: models.FiltersDataRepository(connections.PgDBConnection.Client),
 		pgCompanyRepository:   models.PgCompanyRepository(connections.PgDBConnection.Client),
 		pgContactRepository:   models.PgContactRepository(connections.PgDBConnection.Client),
+		jobsRepository:        models.JobsRepository(connections.PgDBConnection.Client),
+
+		elasticCompanyRepository: models.ElasticCompanyRepository(connections.ElasticsearchConnection.Client),
+		elasticContactRepository: models.ElasticContactRepository(connections.ElasticsearchConnection.Client),
 	}
 }
 
@@ -48,6 +67,13 @@ func (s *filterService) GetFilterData(serviceType string, query models.FiltersDa
 		return nil, err
 	}
 
+	if query.Where != nil {
+		if filterData.FilterType != constants.FilterTypeKeyword {
+			return nil, constants.FilterNotAggregatableError
+		}
+		return s.getScopedFilterData(serviceType, query)
+	}
+
 	if !filterData.DirectDerived {
 		data, err := s.filtersDataRepository.GetFiltersByQuery(query)
 		if err != nil {
@@ -59,6 +85,41 @@ func (s *filterService) GetFilterData(serviceType string, query models.FiltersDa
 	return s.getDirectDerivedFilterData(serviceType, query)
 }
 
+// getScopedFilterData counts the values of the filter over the records that
+// match the query's where clause, minus the conditions on the filter itself,
+// so that the options cascade from the other selections.
+func (s *filterService) getScopedFilterData(serviceType string, query models.FiltersDataQuery) ([]helper.FilterDataResponse, error) {
+	vql := utilities.VQLQuery{Where: query.Where.WithoutFilterKey(query.FilterKey)}
+	aggregation := vql.ToTermsAggregation(
+		query.FilterKey,
+		query.Prefix,
+		query.SortBy,
+		utilities.InlineIf(query.Limit > 0, query.Limit, constants.DefaultPageSize).(int),
+	)
+
+	var buckets []utilities.TermsBucket
+	var err error
+	if serviceType == constants.CompaniesService {
+		buckets, err = s.elasticCompanyRepository.AggregateTerms(aggregation)
+	} else {
+		buckets, err = s.elasticContactRepository.AggregateTerms(aggregation)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]helper.FilterDataResponse, 0, len(buckets))
+	for _, bucket := range buckets {
+		value := fmt.Sprint(bucket.Key)
+		result = append(result, helper.FilterDataResponse{
+			Value:        value,
+			DisplayValue: value,
+			Count:        &bucket.DocCount,
+		})
+	}
+	return result, nil
+}
+
 func (s *filterService) getDirectDerivedFilterData(serviceType string, query models.FiltersDataQuery) ([]helper.FilterDataResponse, error) {
 	result := make([]helper.FilterDataResponse, 0)
 
@@ -95,6 +156,148 @@ func (s *filterService) getDirectDerivedFilterData(serviceType string, query mod
 	return result, nil
 }
 
+func (s *filterService) ListAllFilters(serviceType string) ([]*models.ModelFilter, error) {
+	if !isValidService(serviceType) {
+		return nil, constants.InvalidServiceTypeError
+	}
+	return s.filtersRepository.ListByService(serviceType)
+}
+
+func (s *filterService) getFilter(serviceType, key string) (*models.ModelFilter, error) {
+	if !isValidService(serviceType) {
+		return nil, constants.InvalidServiceTypeError
+	}
+	filter, err := s.filtersRepository.GetByKey(serviceType, key)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, constants.FilterNotFoundError
+	}
+	return filter, nil
+}
+
+func (s *filterService) CreateFilter(serviceType string, request helper.FilterRequest) (*models.ModelFilter, error) {
+	if !isValidService(serviceType) {
+		return nil, constants.InvalidServiceTypeError
+	}
+	existing, err := s.filtersRepository.GetByKey(serviceType, request.Key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, constants.FilterExistsError
+	}
+	filter := &models.ModelFilter{
+		Key:         request.Key,
+		Service:     serviceType,
+		FilterType:  *request.FilterType,
+		DisplayName: *request.DisplayName,
+		Active:      true,
+	}
+	if request.DirectDerived != nil {
+		filter.DirectDerived = *request.DirectDerived
+	}
+	if err := s.filtersRepository.Create(filter); err != nil {
+		return nil, err
+	}
+	if !filter.DirectDerived {
+		if err := s.queueRebuild(filter); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+func (s *filterService) UpdateFilter(serviceType string, request helper.FilterRequest) (*models.ModelFilter, error) {
+	filter, err := s.getFilter(serviceType, request.Key)
+	if err != nil {
+		return nil, err
+	}
+	if request.DisplayName != nil {
+		filter.DisplayName = *request.DisplayName
+	}
+	if request.FilterType != nil {
+		filter.FilterType = *request.FilterType
+	}
+	wasDirectDerived := filter.DirectDerived
+	if request.DirectDerived != nil {
+		filter.DirectDerived = *request.DirectDerived
+	}
+	if err := s.filtersRepository.Update(filter); err != nil {
+		return nil, err
+	}
+	if wasDirectDerived && !filter.DirectDerived {
+		if err := s.queueRebuild(filter); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// queueRebuild queues a rebuild_filter_data job so that a filter whose values
+// are read from filters_data gets the values already present in Postgres.
+func (s *filterService) queueRebuild(filter *models.ModelFilter) error {
+	data, err := json.Marshal(utilities.RebuildFilterDataJobData{Service: filter.Service, FilterKey: filter.Key})
+	if err != nil {
+		return err
+	}
+	job := &models.ModelJobs{
+		UUID:    uuid.New().String(),
+		JobType: constants.RebuildFilterData,
+		Data:    data,
+	}
+	if err := s.jobsRepository.BulkUpsert([]*models.ModelJobs{job}); err != nil {
+		return err
+	}
+	filter.RebuildJob = job.UUID
+	return nil
+}
+
+func (s *filterService) UpdateFilterStatus(serviceType string, request helper.FilterStatusUpdate) error {
+	if _, err := s.getFilter(serviceType, request.FilterKey); err != nil {
+		return err
+	}
+	return s.filtersRepository.UpdateActiveStatus(request.FilterKey, serviceType, request.Active)
+}
+
+// ReorderFilters moves the given filters to the front, in the given order;
+// the filters not listed keep their relative order after them.
+func (s *filterService) ReorderFilters(serviceType string, keys []string) ([]*models.ModelFilter, error) {
+	filters, err := s.ListAllFilters(serviceType)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(filters))
+	for _, filter := range filters {
+		known[filter.Key] = true
+	}
+	ordered := make([]string, 0, len(filters))
+	for _, key := range utilities.UniqueStringSlice(keys) {
+		if !known[key] {
+			return nil, constants.FilterNotFoundError
+		}
+		ordered = append(ordered, key)
+	}
+	for _, filter := range filters {
+		if !slices.Contains(ordered, filter.Key) {
+			ordered = append(ordered, filter.Key)
+		}
+	}
+	if err := s.filtersRepository.Reorder(serviceType, ordered); err != nil {
+		return nil, err
+	}
+	return s.filtersRepository.ListByService(serviceType)
+}
+
+func (s *filterService) DeleteFilter(serviceType, key string) error {
+	if _, err := s.getFilter(serviceType, key); err != nil {
+		return err
+	}
+	_, err := s.filtersRepository.SoftDelete(serviceType, key)
+	return err
+}
+
 func isValidService(serviceType string) bool {
 	return serviceType == constants.CompaniesService || serviceType == constants.ContactsService
 }
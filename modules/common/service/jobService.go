@@ -1,9 +1,15 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"time"
+	"vivek-ray/conf"
 	"vivek-ray/connections"
+	"vivek-ray/constants"
 	"vivek-ray/models"
 	"vivek-ray/modules/common/helper"
+	"vivek-ray/utilities"
 
 	"github.com/google/uuid"
 )
@@ -11,6 +17,9 @@ import (
 type JobSvc interface {
 	CreateJob(request helper.CreateJobRequest) error
 	ListJobs(request helper.ListJobsRequest) ([]*models.ModelJobs, error)
+	DownloadExport(jobUUID string, ttl time.Duration) (helper.ExportDownloadResponse, error)
+	CancelJob(jobUUID string) (*models.ModelJobs, error)
+	RequeueJob(jobUUID string) (*models.ModelJobs, error)
 }
 
 type jobService struct {
@@ -25,10 +34,11 @@ func NewJobService() JobSvc {
 
 func (s *jobService) CreateJob(request helper.CreateJobRequest) error {
 	job := &models.ModelJobs{
-		UUID:       uuid.New().String(),
-		JobType:    request.JobType,
-		Data:       request.JobData,
-		RetryCount: request.RetryCount,
+		UUID:          uuid.New().String(),
+		JobType:       request.JobType,
+		Data:          request.JobData,
+		RetryCount:    request.RetryCount,
+		RetryInterval: request.RetryInterval,
 	}
 	return s.jobsRepository.BulkUpsert([]*models.ModelJobs{job})
 }
@@ -40,3 +50,106 @@ func (s *jobService) ListJobs(request helper.ListJobsRequest) ([]*models.ModelJo
 		Limit:   request.Limit,
 	})
 }
+
+// DownloadExport presigns fresh links to the files of a completed export, so
+// that they can be fetched without bucket credentials.
+func (s *jobService) DownloadExport(jobUUID string, ttl time.Duration) (helper.ExportDownloadResponse, error) {
+	response := helper.ExportDownloadResponse{JobUUID: jobUUID, ExpiresIn: ttl.String()}
+	jobs, err := s.jobsRepository.ListByFilters(models.JobsFilters{Uuids: []string{jobUUID}})
+	if err != nil {
+		return response, err
+	}
+	if len(jobs) == 0 {
+		return response, constants.JobNotFoundError
+	}
+	job := jobs[0]
+	if job.JobType != constants.ExportCsvFile {
+		return response, constants.JobNotExportError
+	}
+	if job.Status != constants.CompletedJobStatus {
+		return response, constants.ExportNotReadyError
+	}
+	result := job.Response()
+	if result.ExpiredAt != nil {
+		return response, constants.ExportExpiredError
+	}
+
+	var jobData utilities.ExportFileJobData
+	if err := json.Unmarshal(job.Data, &jobData); err != nil {
+		return response, err
+	}
+	bucket := utilities.InlineIf(jobData.FileS3Bucket != "", jobData.FileS3Bucket, conf.S3StorageConfig.S3Bucket).(string)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	presign := func(file helper.ExportDownloadFile) (helper.ExportDownloadFile, error) {
+		url, err := connections.S3Connection.GetPresignedURL(ctx, bucket, file.S3Key, ttl)
+		file.URL = url
+		return file, err
+	}
+	response.ExpiresAt = time.Now().Add(ttl)
+
+	// exports from before export reports only recorded their s3_key
+	report := result.ExportReport
+	if report == nil {
+		report = &utilities.ExportReport{Parts: []utilities.ExportPart{{Number: 1, S3Key: result.S3Key}}}
+	}
+	for _, part := range report.Parts {
+		file, err := presign(helper.ExportDownloadFile{S3Key: part.S3Key, Rows: part.Rows, Bytes: part.Bytes, Checksum: part.Checksum})
+		if err != nil {
+			return response, err
+		}
+		response.Parts = append(response.Parts, file)
+	}
+	if report.BundleKey != "" {
+		bundle, err := presign(helper.ExportDownloadFile{S3Key: report.BundleKey})
+		if err != nil {
+			return response, err
+		}
+		response.Bundle = &bundle
+		response.URL = bundle.URL
+	} else if len(response.Parts) == 1 {
+		response.URL = response.Parts[0].URL
+	}
+	if report.ManifestKey != "" {
+		manifest, err := presign(helper.ExportDownloadFile{S3Key: report.ManifestKey})
+		if err != nil {
+			return response, err
+		}
+		response.Manifest = &manifest
+	}
+	return response, nil
+}
+
+// CancelJob stops a job that has not finished. Jobs waiting to run are
+// cancelled at once; a running job keeps its status until its worker stops
+// it between batches.
+func (s *jobService) CancelJob(jobUUID string) (*models.ModelJobs, error) {
+	job, err := s.jobsRepository.Cancel(jobUUID)
+	if err != nil || job != nil {
+		return job, err
+	}
+	jobs, err := s.jobsRepository.ListByFilters(models.JobsFilters{Uuids: []string{jobUUID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, constants.JobNotFoundError
+	}
+	return nil, constants.JobNotCancellableError
+}
+
+// RequeueJob opens a dead job again, with its attempts reset.
+func (s *jobService) RequeueJob(jobUUID string) (*models.ModelJobs, error) {
+	job, err := s.jobsRepository.Requeue(jobUUID)
+	if err != nil || job != nil {
+		return job, err
+	}
+	jobs, err := s.jobsRepository.ListByFilters(models.JobsFilters{Uuids: []string{jobUUID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, constants.JobNotFoundError
+	}
+	return nil, constants.JobNotDeadError
+}
@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"vivek-ray/connections"
+	"vivek-ray/constants"
 	"vivek-ray/models"
+	commonHelper "vivek-ray/modules/common/helper"
 	"vivek-ray/modules/companies/helper"
 	"vivek-ray/modules/companies/service"
 
@@ -41,7 +44,7 @@ func GetCompaniesCountByFilter(c *gin.Context) {
 }
 
 func BatchUpsert(c *gin.Context) {
-	pgCompanies, esCompanies, err := helper.BindBatchUpsertRequest(c)
+	pgCompanies, options, err := helper.BindBatchUpsertRequest(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
 		return
@@ -51,6 +54,135 @@ func BatchUpsert(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
 		return
 	}
-	service.NewCompanyService(tempFilters).BulkUpsert(pgCompanies, esCompanies)
+	stats, err := service.NewCompanyService(tempFilters).UpsertMerged(pgCompanies, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": stats, "success": true})
+}
+
+func GetCompanyProvenance(c *gin.Context) {
+	provenance, err := service.NewCompanyService([]*models.ModelFilter{}).GetProvenance(c.Param("uuid"))
+	if errors.Is(err, constants.CompanyNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": provenance, "success": true})
+}
+
+func GetCompanyHistory(c *gin.Context) {
+	history, err := service.NewCompanyService([]*models.ModelFilter{}).GetHistory(c.Param("uuid"))
+	if errors.Is(err, constants.CompanyNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": history, "success": true})
+}
+
+func GetCompanySnapshot(c *gin.Context) {
+	asOf, err := commonHelper.BindAsOfQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	snapshot, err := service.NewCompanyService([]*models.ModelFilter{}).GetSnapshot(c.Param("uuid"), asOf)
+	if errors.Is(err, constants.CompanyNotFoundError) || errors.Is(err, constants.RecordNotFoundAtError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": snapshot, "as_of": asOf, "success": true})
+}
+
+func GetCompany(c *gin.Context) {
+	company, err := service.NewCompanyService([]*models.ModelFilter{}).GetByUuid(c.Param("uuid"))
+	if errors.Is(err, constants.CompanyNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": company, "success": true})
+}
+
+func UpdateCompany(c *gin.Context) {
+	patch, err := helper.BindCompanyPatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	company, err := service.NewCompanyService(tempFilters).Update(c.Param("uuid"), patch)
+	if errors.Is(err, constants.CompanyNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if errors.Is(err, constants.UpdateConflictError) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": company, "success": true})
+}
+
+func DeleteCompany(c *gin.Context) {
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	err = service.NewCompanyService(tempFilters).Delete(c.Param("uuid"), commonHelper.APIProvenance(c))
+	if errors.Is(err, constants.CompanyNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
+
+func DeleteCompaniesByFilter(c *gin.Context) {
+	query, err := helper.BindAndValidateVQLQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	deleted, err := service.NewCompanyService(tempFilters).DeleteByFilters(query, commonHelper.APIProvenance(c))
+	if errors.Is(err, constants.DeleteMatchLimitExceededError) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted, "success": true})
+}
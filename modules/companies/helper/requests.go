@@ -1,18 +1,15 @@
 package helper
 
 import (
+	"time"
 	"vivek-ray/constants"
 	"vivek-ray/models"
+	commonHelper "vivek-ray/modules/common/helper"
 	"vivek-ray/utilities"
 
 	"github.com/gin-gonic/gin"
 )
 
-type FilterStatusUpdate struct {
-	Active    bool   `json:"active"`
-	FilterKey string `json:"filter_key"`
-}
-
 func BindAndValidateVQLQuery(c *gin.Context) (utilities.VQLQuery, error) {
 	var query utilities.VQLQuery
 	if err := c.ShouldBindJSON(&query); err != nil {
@@ -37,25 +34,42 @@ func BindAndValidateFiltersDataQuery(c *gin.Context) (models.FiltersDataQuery, e
 	return query, nil
 }
 
-func BindFilterUpdateStatus(c *gin.Context) (FilterStatusUpdate, error) {
-	var statusUpdate FilterStatusUpdate
-	err := c.ShouldBindJSON(&statusUpdate)
-
-	return statusUpdate, err
-}
-
-func BindBatchUpsertRequest(c *gin.Context) ([]*models.PgCompany, []*models.ElasticCompany, error) {
+func BindBatchUpsertRequest(c *gin.Context) ([]*models.PgCompany, utilities.UpsertOptions, error) {
 	pgCompanies := make([]*models.PgCompany, 0)
-	esCompanies := make([]*models.ElasticCompany, 0)
-	err := c.ShouldBindJSON(&pgCompanies)
+	options, err := commonHelper.BindUpsertOptions(c)
 	if err != nil {
-		return nil, nil, err
+		return nil, options, err
+	}
+	if err := c.ShouldBindJSON(&pgCompanies); err != nil {
+		return nil, options, err
 	}
 	if len(pgCompanies) > constants.MaxPageSize {
-		return nil, nil, constants.PageSizeExceededError
+		return nil, options, constants.PageSizeExceededError
+	}
+	serverTime := time.Now()
+	for i, company := range pgCompanies {
+		company.SetProvenance(options.Provenance.AtRow(i, &serverTime))
 	}
+	return pgCompanies, options, nil
+}
+
+func BuildElasticCompanies(pgCompanies []*models.PgCompany) []*models.ElasticCompany {
+	esCompanies := make([]*models.ElasticCompany, 0, len(pgCompanies))
 	for _, pgCompany := range pgCompanies {
 		esCompanies = append(esCompanies, models.ElasticCompanyFromRawData(pgCompany))
 	}
-	return pgCompanies, esCompanies, nil
+	return esCompanies
+}
+
+// BindCompanyPatch reads a PATCH body and checks that every field exists on a
+// company and holds a value of the right type.
+func BindCompanyPatch(c *gin.Context) (utilities.RecordPatch, error) {
+	patch, err := commonHelper.BindRecordPatch(c)
+	if err != nil {
+		return patch, err
+	}
+	if _, err := utilities.ApplyPatch(&models.PgCompany{}, patch.Fields); err != nil {
+		return patch, err
+	}
+	return patch, nil
 }
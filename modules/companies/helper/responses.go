@@ -1,6 +1,9 @@
 package helper
 
-import "vivek-ray/models"
+import (
+	"vivek-ray/models"
+	"vivek-ray/utilities"
+)
 
 type FilterDataResponse struct {
 	Value        string `json:"value"`
@@ -39,3 +42,19 @@ func ToCompanyResponses(companies []*models.PgCompany, orderedUuids []string, cu
 	}
 	return responses
 }
+
+// ProvenanceResponse shows where a company came from as a whole (Source, the last
+// upsert that touched it) and where each populated field came from.
+type ProvenanceResponse struct {
+	UUID   string                          `json:"uuid"`
+	Source utilities.Provenance            `json:"source"`
+	Fields map[string]utilities.Provenance `json:"fields"`
+}
+
+func ToProvenanceResponse(company *models.PgCompany) ProvenanceResponse {
+	return ProvenanceResponse{
+		UUID:   company.UUID,
+		Source: company.Provenance(),
+		Fields: company.Lineage(),
+	}
+}
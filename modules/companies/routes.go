@@ -10,4 +10,11 @@ func Routes(router *gin.RouterGroup) {
 	router.POST("/", controller.GetCompaniesByFilter)
 	router.POST("/count", controller.GetCompaniesCountByFilter)
 	router.POST("/batch-upsert", controller.BatchUpsert)
+	router.POST("/delete", controller.DeleteCompaniesByFilter)
+	router.GET("/:uuid", controller.GetCompany)
+	router.PATCH("/:uuid", controller.UpdateCompany)
+	router.DELETE("/:uuid", controller.DeleteCompany)
+	router.GET("/:uuid/provenance", controller.GetCompanyProvenance)
+	router.GET("/:uuid/history", controller.GetCompanyHistory)
+	router.GET("/:uuid/snapshot", controller.GetCompanySnapshot)
 }
@@ -2,26 +2,34 @@ package service
 
 import (
 	"errors"
+	"slices"
 	"sync"
+	"time"
 	"vivek-ray/connections"
 	"vivek-ray/constants"
 	"vivek-ray/models"
 	"vivek-ray/modules/companies/helper"
 	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
 )
 
 type CompanyService struct {
 	companyElasticRepository models.ElasticCompanySvcRepo
+	contactElasticRepository models.ElasticContactSvcRepo
 	companyPgRepository      models.PgCompanySvcRepo
 	filtersDataRepository    models.FiltersDataSvcRepo
+	recordChangesRepository  models.RecordChangesSvcRepo
 	tempFilters              []*models.ModelFilter
 }
 
 func NewCompanyService(tempFilters []*models.ModelFilter) CompanySvcRepo {
 	return &CompanyService{
 		companyElasticRepository: models.ElasticCompanyRepository(connections.ElasticsearchConnection.Client),
+		contactElasticRepository: models.ElasticContactRepository(connections.ElasticsearchConnection.Client),
 		companyPgRepository:      models.PgCompanyRepository(connections.PgDBConnection.Client),
 		filtersDataRepository:    models.FiltersDataRepository(connections.PgDBConnection.Client),
+		recordChangesRepository:  models.RecordChangesRepository(connections.PgDBConnection.Client),
 		tempFilters:              tempFilters,
 	}
 }
@@ -29,9 +37,21 @@ func NewCompanyService(tempFilters []*models.ModelFilter) CompanySvcRepo {
 type CompanySvcRepo interface {
 	ListByFilters(query utilities.VQLQuery) ([]helper.CompanyResponse, error)
 	CountByFilters(query utilities.VQLQuery) (int64, error)
-	BulkUpsert(pgCompanies []*models.PgCompany, esCompanies []*models.ElasticCompany) error
-	BulkUpsertToDb(pgCompanies []*models.PgCompany, esCompanies []*models.ElasticCompany, filtersData []*models.ModelFilterData) error
+	BulkUpsert(pgCompanies []*models.PgCompany, esCompanies []*models.ElasticCompany) (utilities.UpsertStats, error)
+	BulkUpsertToDb(pgCompanies []*models.PgCompany, esCompanies []*models.ElasticCompany, filtersData []*models.ModelFilterData) (utilities.UpsertStats, error)
 	GetCompanyByUuids(uuids []string, selectColumns []string) ([]*models.PgCompany, error)
+	GetProvenance(uuid string) (helper.ProvenanceResponse, error)
+	GetHistory(uuid string) ([]*models.ModelRecordChange, error)
+	GetSnapshot(uuid string, asOf time.Time) (map[string]any, error)
+	UpsertMerged(pgCompanies []*models.PgCompany, options utilities.UpsertOptions) (utilities.UpsertStats, error)
+	GetByUuid(uuid string) (*models.PgCompany, error)
+	Update(uuid string, patch utilities.RecordPatch) (*models.PgCompany, error)
+	Delete(uuid string, provenance utilities.Provenance) error
+	DeleteByFilters(query utilities.VQLQuery, provenance utilities.Provenance) (int64, error)
+	ListUuidsByFilters(query utilities.VQLQuery) ([]string, []string, error)
+	DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error)
+	PatchByUuids(uuids []string, patch utilities.RecordPatch) (utilities.UpsertStats, error)
+	UpsertLocked(pgCompanies []*models.PgCompany, resolve func(stored, company *models.PgCompany) bool) (utilities.UpsertStats, error)
 }
 
 func (s *CompanyService) GetCompanyByUuids(uuids []string, selectColumns []string) ([]*models.PgCompany, error) {
@@ -54,7 +74,11 @@ func (s *CompanyService) ListByFilters(query utilities.VQLQuery) ([]helper.Compa
 	if err != nil {
 		return nil, err
 	}
-	return helper.ToCompanyResponses(companies, companyUuids, cursors), nil
+	responses := helper.ToCompanyResponses(companies, companyUuids, cursors)
+	if dropped := len(companyUuids) - len(responses); dropped > 0 {
+		log.Warn().Msgf("%d company hits have no postgres row, run a reconcile job to repair the index", dropped)
+	}
+	return responses, nil
 }
 
 func (s *CompanyService) CountByFilters(query utilities.VQLQuery) (int64, error) {
@@ -63,15 +87,17 @@ func (s *CompanyService) CountByFilters(query utilities.VQLQuery) (int64, error)
 }
 
 func (s *CompanyService) BulkUpsertToDb(pgCompanies []*models.PgCompany,
-	esCompanies []*models.ElasticCompany, filtersData []*models.ModelFilterData) error {
+	esCompanies []*models.ElasticCompany, filtersData []*models.ModelFilterData) (utilities.UpsertStats, error) {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var insertionError error
+	var stats utilities.UpsertStats
 
 	wg.Add(3)
 	go func() {
 		defer wg.Done()
-		if _, err := s.companyPgRepository.BulkUpsert(pgCompanies); err != nil {
+		var err error
+		if stats, err = s.companyPgRepository.BulkUpsert(pgCompanies, nil); err != nil {
 			mu.Lock()
 			insertionError = errors.Join(insertionError, err)
 			mu.Unlock()
@@ -97,10 +123,10 @@ func (s *CompanyService) BulkUpsertToDb(pgCompanies []*models.PgCompany,
 	}()
 
 	wg.Wait()
-	return insertionError
+	return stats, insertionError
 }
 
-func (s *CompanyService) BulkUpsert(pgCompanies []*models.PgCompany, esCompanies []*models.ElasticCompany) error {
+func (s *CompanyService) buildFiltersData(pgCompanies []*models.PgCompany) []*models.ModelFilterData {
 	insertedFilters, filtersData := make(map[string]struct{}), make([]*models.ModelFilterData, 0)
 
 	for _, company := range pgCompanies {
@@ -108,20 +134,384 @@ func (s *CompanyService) BulkUpsert(pgCompanies []*models.PgCompany, esCompanies
 			if filter.Service != constants.CompaniesService {
 				continue
 			}
-			value, _ := utilities.GetFieldValue(company, filter.Key).(string)
-			filterUUID := utilities.GenerateUUID5(filter.Key + filter.Service + value)
-			if _, ok := insertedFilters[filterUUID]; ok || value == "" {
-				continue
+			for _, value := range utilities.FilterValues(filter.Service, company, filter.Key) {
+				filterUUID := utilities.GenerateUUID5(filter.Key + filter.Service + value)
+				if _, ok := insertedFilters[filterUUID]; ok {
+					continue
+				}
+				insertedFilters[filterUUID] = struct{}{}
+				filtersData = append(filtersData, &models.ModelFilterData{
+					UUID:         filterUUID,
+					FilterKey:    filter.Key,
+					Service:      filter.Service,
+					DisplayValue: value,
+					Value:        value,
+				})
+			}
+		}
+	}
+	return filtersData
+}
+
+func (s *CompanyService) BulkUpsert(pgCompanies []*models.PgCompany, esCompanies []*models.ElasticCompany) (utilities.UpsertStats, error) {
+	stats, err := s.BulkUpsertToDb(pgCompanies, esCompanies, s.buildFiltersData(pgCompanies))
+	if err != nil {
+		return stats, err
+	}
+	return stats, s.propagateToContacts(pgCompanies, stats.UpdatedFields, stats.InsertedUuids)
+}
+
+// propagateToContacts refreshes the company fields denormalised into contact
+// documents for every company whose changed fields include one of them, and
+// for every company created or brought back from a soft delete, whose
+// contacts may carry no company fields or the ones blanked by the delete.
+func (s *CompanyService) propagateToContacts(pgCompanies []*models.PgCompany, changedFields map[string][]string, insertedUuids []string) error {
+	inserted := make(map[string]bool, len(insertedUuids))
+	for _, uuid := range insertedUuids {
+		inserted[uuid] = true
+	}
+	companyFields := make(map[string]map[string]any)
+	for _, company := range pgCompanies {
+		fields, err := models.ElasticContactCompanyFields(company)
+		if err != nil {
+			return err
+		}
+		if inserted[company.UUID] || slices.ContainsFunc(changedFields[company.UUID], func(field string) bool {
+			_, ok := fields["company_"+field]
+			return ok
+		}) {
+			companyFields[company.UUID] = fields
+		}
+	}
+	return s.refreshContacts(companyFields)
+}
+
+func (s *CompanyService) refreshContacts(companyFields map[string]map[string]any) error {
+	task, err := s.contactElasticRepository.UpdateCompanyFields(companyFields)
+	if err != nil {
+		return err
+	}
+	if task != "" {
+		log.Info().Msgf("Refreshing the contacts of %d companies in elasticsearch task %s", len(companyFields), task)
+	}
+	return nil
+}
+
+// UpsertMerged writes the companies following the merge policy in options.
+// Every incoming company that already exists is merged with its stored row
+// inside the upsert transaction, so a write landing in between cannot be
+// overwritten. The Elasticsearch documents, filter values and the company
+// fields of contacts are refreshed from the merged companies once Postgres has
+// them.
+func (s *CompanyService) UpsertMerged(pgCompanies []*models.PgCompany, options utilities.UpsertOptions) (utilities.UpsertStats, error) {
+	serverTime := time.Now()
+	for _, company := range pgCompanies {
+		if company.SourceDate == nil {
+			company.SourceDate = utilities.InlineIf(options.SourceDate != nil, options.SourceDate, &serverTime).(*time.Time)
+		}
+	}
+	return s.UpsertLocked(pgCompanies, func(existing, company *models.PgCompany) bool {
+		if existing == nil {
+			company.FieldSources = nil
+			return true
+		}
+		incomingIsNewer := utilities.IsNewerOrEqual(company.SourceDate, existing.SourceDate)
+		kept := utilities.MergeStruct(existing, company, options.MergePolicy, incomingIsNewer)
+		company.FieldSources = utilities.CarryLineage(kept, existing.Provenance(), existing.FieldSources)
+		company.CreatedAt = existing.CreatedAt
+		if !incomingIsNewer {
+			company.SourceDate = existing.SourceDate
+		}
+		return true
+	})
+}
+
+// UpsertLocked writes the companies resolve keeps, after it settled each one
+// against its stored row locked in the upsert transaction, then indexes them
+// and refreshes their contacts.
+func (s *CompanyService) UpsertLocked(pgCompanies []*models.PgCompany, resolve func(stored, company *models.PgCompany) bool) (utilities.UpsertStats, error) {
+	written := make([]*models.PgCompany, 0, len(pgCompanies))
+	stats, err := s.companyPgRepository.BulkUpsert(pgCompanies, func(stored, company *models.PgCompany) bool {
+		if !resolve(stored, company) {
+			return false
+		}
+		written = append(written, company)
+		return true
+	})
+	if err != nil || len(written) == 0 {
+		return stats, err
+	}
+	if err := s.upsertIndexes(helper.BuildElasticCompanies(written), s.buildFiltersData(written)); err != nil {
+		return stats, err
+	}
+	return stats, s.propagateToContacts(written, stats.UpdatedFields, stats.InsertedUuids)
+}
+
+// upsertIndexes writes the documents and filter values of companies already
+// stored in Postgres.
+func (s *CompanyService) upsertIndexes(esCompanies []*models.ElasticCompany, filtersData []*models.ModelFilterData) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var insertionError error
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := s.companyElasticRepository.BulkUpsert(esCompanies); err != nil {
+			mu.Lock()
+			insertionError = errors.Join(insertionError, err)
+			mu.Unlock()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if err := s.filtersDataRepository.BulkUpsert(filtersData); err != nil {
+			mu.Lock()
+			insertionError = errors.Join(insertionError, err)
+			mu.Unlock()
+		}
+	}()
+
+	wg.Wait()
+	return insertionError
+}
+
+func (s *CompanyService) getByUuid(uuid string) (*models.PgCompany, error) {
+	companies, err := s.companyPgRepository.ListByFilters(models.PgCompanyFilters{Uuids: []string{uuid}})
+	if err != nil {
+		return nil, err
+	}
+	if len(companies) == 0 {
+		return nil, constants.CompanyNotFoundError
+	}
+	return companies[0], nil
+}
+
+func (s *CompanyService) GetProvenance(uuid string) (helper.ProvenanceResponse, error) {
+	company, err := s.getByUuid(uuid)
+	if err != nil {
+		return helper.ProvenanceResponse{}, err
+	}
+	return helper.ToProvenanceResponse(company), nil
+}
+
+// GetHistory returns the change log of a company, newest first.
+func (s *CompanyService) GetHistory(uuid string) ([]*models.ModelRecordChange, error) {
+	if _, err := s.getByUuid(uuid); err != nil {
+		return nil, err
+	}
+	return s.recordChangesRepository.ListByRecord(constants.CompaniesService, uuid, nil)
+}
+
+// GetSnapshot reconstructs a company as it was at asOf by undoing, on its current
+// row, every change recorded after that time.
+func (s *CompanyService) GetSnapshot(uuid string, asOf time.Time) (map[string]any, error) {
+	company, err := s.getByUuid(uuid)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.recordChangesRepository.ListByRecord(constants.CompaniesService, uuid, &asOf)
+	if err != nil {
+		return nil, err
+	}
+	state, existed, err := models.RewindState(company, changes)
+	if err != nil {
+		return nil, err
+	}
+	if !existed {
+		return nil, constants.RecordNotFoundAtError
+	}
+	return state, nil
+}
+
+func (s *CompanyService) GetByUuid(uuid string) (*models.PgCompany, error) {
+	return s.getByUuid(uuid)
+}
+
+// patchCompany applies patch to a copy of existing. The patched fields are
+// attributed to the patch, the others keep their lineage.
+func patchCompany(existing *models.PgCompany, patch utilities.RecordPatch) (*models.PgCompany, []string, error) {
+	company := *existing
+	patched, err := utilities.ApplyPatch(&company, patch.Fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverTime := time.Now()
+	company.SetProvenance(patch.Provenance)
+	company.FieldSources = utilities.CarryLineage(utilities.KeptByPatch(existing, patched), existing.Provenance(), existing.FieldSources)
+	company.UpdatedAt = &serverTime
+	return &company, patched, nil
+}
+
+// Update applies a partial update to a company and re-indexes its document.
+func (s *CompanyService) Update(uuid string, patch utilities.RecordPatch) (*models.PgCompany, error) {
+	var patched []string
+	company, err := s.companyPgRepository.Update(uuid, patch.UpdatedAt, func(stored *models.PgCompany) (*models.PgCompany, []string, error) {
+		company, fields, err := patchCompany(stored, patch)
+		if err != nil {
+			return nil, nil, err
+		}
+		patched = fields
+		return company, append(fields, utilities.PatchSystemColumns...), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.companyElasticRepository.BulkUpsert(helper.BuildElasticCompanies([]*models.PgCompany{company})); err != nil {
+		return nil, err
+	}
+	if err := s.propagateToContacts([]*models.PgCompany{company}, map[string][]string{company.UUID: patched}, nil); err != nil {
+		return nil, err
+	}
+	if err := s.filtersDataRepository.BulkUpsert(s.buildFiltersData([]*models.PgCompany{company})); err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+func (s *CompanyService) Delete(uuid string, provenance utilities.Provenance) error {
+	company, err := s.getByUuid(uuid)
+	if err != nil {
+		return err
+	}
+	_, err = s.deleteRecords([]*models.PgCompany{company}, provenance)
+	return err
+}
+
+// DeleteByFilters soft deletes every company matching a VQL query, paging
+// through the matches by uuid. Queries matching more than
+// MaxDeleteByFiltersRecords records are rejected; delete_by_vql jobs take
+// those.
+func (s *CompanyService) DeleteByFilters(query utilities.VQLQuery, provenance utilities.Provenance) (int64, error) {
+	query.CompanyConfig = nil
+	matched, err := s.CountByFilters(query)
+	if err != nil {
+		return 0, err
+	}
+	if matched > constants.MaxDeleteByFiltersRecords {
+		return 0, constants.DeleteMatchLimitExceededError
+	}
+
+	query.OrderBy = []utilities.FilterOrder{{OrderBy: "uuid", OrderDirection: "desc"}}
+	query.Cursor, query.Page, query.Limit = nil, 0, constants.DeleteByFiltersBatchSize
+	var deleted int64
+	for {
+		uuids, cursor, err := s.ListUuidsByFilters(query)
+		if err != nil || len(uuids) == 0 {
+			return deleted, err
+		}
+		batchDeleted, err := s.DeleteByUuids(uuids, provenance)
+		deleted += batchDeleted
+		if err != nil {
+			return deleted, err
+		}
+		query.Cursor = cursor
+	}
+}
+
+// ListUuidsByFilters returns the uuids of a page of Elasticsearch hits and the
+// cursor of the last one. Hits without a live Postgres row are kept, so that
+// callers paging through a query do not stop at a page of stale documents.
+func (s *CompanyService) ListUuidsByFilters(query utilities.VQLQuery) ([]string, []string, error) {
+	esHits, err := s.companyElasticRepository.ListByQueryMap(query.ToElasticsearchQuery(false, []string{"uuid"}))
+	if err != nil || len(esHits) == 0 {
+		return nil, nil, err
+	}
+	uuids := make([]string, 0, len(esHits))
+	for _, esHit := range esHits {
+		uuids = append(uuids, esHit.Company.UUID)
+	}
+	return uuids, esHits[len(esHits)-1].Cursor, nil
+}
+
+// DeleteByUuids soft deletes the live companies among uuids.
+func (s *CompanyService) DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error) {
+	companies, err := s.companyPgRepository.ListByFilters(models.PgCompanyFilters{Uuids: uuids})
+	if err != nil {
+		return 0, err
+	}
+	return s.deleteRecords(companies, provenance)
+}
+
+// PatchByUuids applies the same partial update to every live company among
+// uuids. Each one is patched on its row as locked by the upsert, so writes
+// landing meanwhile are kept; uuids without a live row are skipped.
+func (s *CompanyService) PatchByUuids(uuids []string, patch utilities.RecordPatch) (utilities.UpsertStats, error) {
+	// a patch that does not decode fails before any row is locked
+	if _, _, err := patchCompany(&models.PgCompany{}, patch); err != nil {
+		return utilities.UpsertStats{}, err
+	}
+	companies := make([]*models.PgCompany, 0, len(uuids))
+	for _, uuid := range utilities.UniqueStringSlice(uuids) {
+		companies = append(companies, &models.PgCompany{UUID: uuid})
+	}
+	return s.UpsertLocked(companies, func(stored, company *models.PgCompany) bool {
+		if stored == nil {
+			return false
+		}
+		patched, _, _ := patchCompany(stored, patch)
+		*company = *patched
+		return true
+	})
+}
+
+// deleteRecords soft deletes companies in Postgres, drops their documents from
+// Elasticsearch, clears the company fields of their contacts and hides the
+// filter values no live company carries anymore.
+func (s *CompanyService) deleteRecords(companies []*models.PgCompany, provenance utilities.Provenance) (int64, error) {
+	uuids := make([]string, 0, len(companies))
+	for _, company := range companies {
+		uuids = append(uuids, company.UUID)
+	}
+	deleted, err := s.companyPgRepository.DeleteByUuids(uuids, provenance)
+	if err != nil {
+		return deleted, err
+	}
+	if _, err := s.companyElasticRepository.BulkDelete(uuids); err != nil {
+		return deleted, err
+	}
+	cleared, err := models.ElasticContactCompanyFields(&models.PgCompany{})
+	if err != nil {
+		return deleted, err
+	}
+	companyFields := make(map[string]map[string]any, len(uuids))
+	for _, uuid := range uuids {
+		companyFields[uuid] = cleared
+	}
+	if err := s.refreshContacts(companyFields); err != nil {
+		return deleted, err
+	}
+	return deleted, s.pruneFiltersData(companies)
+}
+
+// pruneFiltersData hides the filter values of companies that no live company
+// carries anymore. The values of each filter are checked in one query.
+func (s *CompanyService) pruneFiltersData(companies []*models.PgCompany) error {
+	byKey := make(map[string][]*models.ModelFilterData)
+	for _, filterData := range s.buildFiltersData(companies) {
+		byKey[filterData.FilterKey] = append(byKey[filterData.FilterKey], filterData)
+	}
+	stale := make([]string, 0)
+	for key, filtersData := range byKey {
+		values := make([]string, 0, len(filtersData))
+		for _, filterData := range filtersData {
+			values = append(values, filterData.Value)
+		}
+		carried, err := s.companyPgRepository.CarriedValues(key, values, false)
+		if err != nil {
+			return err
+		}
+		carriedSet := make(map[string]struct{}, len(carried))
+		for _, value := range carried {
+			carriedSet[value] = struct{}{}
+		}
+		for _, filterData := range filtersData {
+			if _, ok := carriedSet[filterData.Value]; !ok {
+				stale = append(stale, filterData.UUID)
 			}
-			insertedFilters[filterUUID] = struct{}{}
-			filtersData = append(filtersData, &models.ModelFilterData{
-				UUID:         filterUUID,
-				FilterKey:    filter.Key,
-				Service:      filter.Service,
-				DisplayValue: value,
-				Value:        value,
-			})
 		}
 	}
-	return s.BulkUpsertToDb(pgCompanies, esCompanies, filtersData)
+	_, err := s.filtersDataRepository.SoftDelete(stale)
+	return err
 }
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+	"vivek-ray/constants"
+	"vivek-ray/models"
+	"vivek-ray/utilities"
+)
+
+// companyStore holds company rows like the companies table. beforeLock runs
+// as BulkUpsert takes its locks, standing in for a write that lands between a
+// read and the upsert.
+type companyStore struct {
+	models.PgCompanySvcRepo
+	rows       map[string]*models.PgCompany
+	beforeLock func()
+	// carriedQueries counts the CarriedValues queries
+	carriedQueries int
+}
+
+func (s *companyStore) ListByFilters(filters models.PgCompanyFilters) ([]*models.PgCompany, error) {
+	companies := make([]*models.PgCompany, 0)
+	for _, uuid := range filters.Uuids {
+		if row, ok := s.rows[uuid]; ok {
+			company := *row
+			companies = append(companies, &company)
+		}
+	}
+	return companies, nil
+}
+
+func (s *companyStore) BulkUpsert(companies []*models.PgCompany, merge func(stored, incoming *models.PgCompany) bool) (utilities.UpsertStats, error) {
+	if s.beforeLock != nil {
+		s.beforeLock()
+	}
+	stats := utilities.UpsertStats{FieldChanges: make(map[string]int64), UpdatedFields: make(map[string][]string)}
+	for _, company := range companies {
+		var stored *models.PgCompany
+		if row, ok := s.rows[company.UUID]; ok {
+			locked := *row
+			stored = &locked
+		}
+		if merge != nil && !merge(stored, company) {
+			continue
+		}
+		if stored == nil {
+			stats.Inserted++
+			stats.InsertedUuids = append(stats.InsertedUuids, company.UUID)
+		} else if changes := utilities.DiffStruct(stored, company); len(changes) > 0 {
+			stats.Updated++
+			for field := range changes {
+				stats.UpdatedFields[company.UUID] = append(stats.UpdatedFields[company.UUID], field)
+			}
+		} else {
+			stats.Unchanged++
+		}
+		written := *company
+		s.rows[company.UUID] = &written
+	}
+	return stats, nil
+}
+
+func (s *companyStore) CarriedValues(column string, values []string, isArray bool) ([]string, error) {
+	s.carriedQueries++
+	carried := make([]string, 0)
+	for _, value := range values {
+		for _, row := range s.rows {
+			if utilities.GetFieldValue(row, column) == value {
+				carried = append(carried, value)
+				break
+			}
+		}
+	}
+	return carried, nil
+}
+
+type companyIndex struct {
+	models.ElasticCompanySvcRepo
+	documents []*models.ElasticCompany
+}
+
+func (i *companyIndex) BulkUpsert(companies []*models.ElasticCompany) (int64, error) {
+	i.documents = append(i.documents, companies...)
+	return int64(len(companies)), nil
+}
+
+type contactIndex struct {
+	models.ElasticContactSvcRepo
+	companyFields map[string]map[string]any
+}
+
+func (i *contactIndex) UpdateCompanyFields(companyFields map[string]map[string]any) (string, error) {
+	i.companyFields = companyFields
+	return "", nil
+}
+
+type filtersDataStore struct {
+	models.FiltersDataSvcRepo
+	deleted []string
+}
+
+func (s *filtersDataStore) SoftDelete(uuids []string) (int64, error) {
+	s.deleted = append(s.deleted, uuids...)
+	return int64(len(uuids)), nil
+}
+
+func (s *filtersDataStore) BulkUpsert([]*models.ModelFilterData) error {
+	return nil
+}
+
+func TestPatchByUuids(t *testing.T) {
+	store := &companyStore{rows: map[string]*models.PgCompany{
+		"a": {UUID: "a", Name: "Acme", City: "Pune"},
+		"b": {UUID: "b", Name: "Globex", City: "Delhi"},
+	}}
+	// an import moves Acme after the patch could have read it
+	store.beforeLock = func() {
+		store.rows["a"].City = "Mumbai"
+	}
+	companyIndex, contactIndex := &companyIndex{}, &contactIndex{}
+	service := &CompanyService{
+		companyPgRepository:      store,
+		companyElasticRepository: companyIndex,
+		contactElasticRepository: contactIndex,
+		filtersDataRepository:    &filtersDataStore{},
+	}
+
+	stats, err := service.PatchByUuids([]string{"a", "b", "a", "missing"}, utilities.RecordPatch{
+		Fields: map[string]json.RawMessage{"state": json.RawMessage(`"MH"`)},
+	})
+	if err != nil {
+		t.Fatalf("PatchByUuids() error = %v", err)
+	}
+	if stats.Updated != 2 || stats.Inserted != 0 {
+		t.Errorf("stats = %+v, want 2 updated and none inserted", stats.UpsertCounts)
+	}
+	if _, ok := store.rows["missing"]; ok {
+		t.Errorf("a uuid without a row was inserted")
+	}
+	if got := store.rows["a"]; got.City != "Mumbai" || got.State != "MH" || got.Name != "Acme" {
+		t.Errorf("a = %+v, want the concurrent city kept and the state patched", got)
+	}
+	indexed := make([]string, 0)
+	for _, document := range companyIndex.documents {
+		indexed = append(indexed, document.UUID+":"+document.City)
+	}
+	if want := []string{"a:Mumbai", "b:Delhi"}; !reflect.DeepEqual(indexed, want) {
+		t.Errorf("indexed = %v, want %v", indexed, want)
+	}
+}
+
+func TestPatchByUuidsRejectsAnInvalidPatch(t *testing.T) {
+	store := &companyStore{rows: map[string]*models.PgCompany{"a": {UUID: "a", Name: "Acme"}}}
+	service := &CompanyService{companyPgRepository: store}
+	store.beforeLock = func() {
+		t.Errorf("rows were locked for a patch that does not decode")
+	}
+	if _, err := service.PatchByUuids([]string{"a"}, utilities.RecordPatch{
+		Fields: map[string]json.RawMessage{"employees_count": json.RawMessage(`"many"`)},
+	}); err == nil {
+		t.Errorf("PatchByUuids() error = nil, want an error")
+	}
+}
+
+func TestPruneFiltersData(t *testing.T) {
+	store := &companyStore{rows: map[string]*models.PgCompany{
+		"live": {UUID: "live", City: "Pune", Country: "India"},
+	}}
+	filtersData := &filtersDataStore{}
+	service := &CompanyService{
+		companyPgRepository:   store,
+		filtersDataRepository: filtersData,
+		tempFilters: []*models.ModelFilter{
+			{Service: constants.CompaniesService, Key: "city"},
+			{Service: constants.CompaniesService, Key: "country"},
+		},
+	}
+	deleted := []*models.PgCompany{
+		{UUID: "x", City: "Pune", Country: "India"},
+		{UUID: "y", City: "Delhi", Country: "India"},
+		{UUID: "z", City: "Austin", Country: "USA"},
+	}
+	if err := service.pruneFiltersData(deleted); err != nil {
+		t.Fatalf("pruneFiltersData() error = %v", err)
+	}
+	if store.carriedQueries != 2 {
+		t.Errorf("ran %d queries, want one per filter", store.carriedQueries)
+	}
+	want := []string{
+		utilities.GenerateUUID5("city" + constants.CompaniesService + "Austin"),
+		utilities.GenerateUUID5("city" + constants.CompaniesService + "Delhi"),
+		utilities.GenerateUUID5("country" + constants.CompaniesService + "USA"),
+	}
+	slices.Sort(want)
+	slices.Sort(filtersData.deleted)
+	if !reflect.DeepEqual(filtersData.deleted, want) {
+		t.Errorf("deleted = %v, want %v", filtersData.deleted, want)
+	}
+}
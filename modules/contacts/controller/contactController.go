@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"vivek-ray/connections"
+	"vivek-ray/constants"
 	"vivek-ray/models"
+	commonHelper "vivek-ray/modules/common/helper"
 	"vivek-ray/modules/contacts/helper"
 	"vivek-ray/modules/contacts/service"
 
@@ -41,7 +44,7 @@ func GetContactsCountByFilter(c *gin.Context) {
 }
 
 func BatchUpsert(c *gin.Context) {
-	pgContacts, esContacts, err := helper.BindBatchUpsertRequest(c)
+	pgContacts, options, err := helper.BindBatchUpsertRequest(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
 		return
@@ -51,6 +54,136 @@ func BatchUpsert(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
 		return
 	}
-	service.NewContactService(tempFilters).BulkUpsert(pgContacts, esContacts)
+	contactService := service.NewContactService(tempFilters)
+	stats, err := contactService.UpsertMerged(pgContacts, options, helper.BuildElasticContacts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": stats, "success": true})
+}
+
+func GetContactProvenance(c *gin.Context) {
+	provenance, err := service.NewContactService([]*models.ModelFilter{}).GetProvenance(c.Param("uuid"))
+	if errors.Is(err, constants.ContactNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": provenance, "success": true})
+}
+
+func GetContactHistory(c *gin.Context) {
+	history, err := service.NewContactService([]*models.ModelFilter{}).GetHistory(c.Param("uuid"))
+	if errors.Is(err, constants.ContactNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": history, "success": true})
+}
+
+func GetContactSnapshot(c *gin.Context) {
+	asOf, err := commonHelper.BindAsOfQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	snapshot, err := service.NewContactService([]*models.ModelFilter{}).GetSnapshot(c.Param("uuid"), asOf)
+	if errors.Is(err, constants.ContactNotFoundError) || errors.Is(err, constants.RecordNotFoundAtError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": snapshot, "as_of": asOf, "success": true})
+}
+
+func GetContact(c *gin.Context) {
+	contact, err := service.NewContactService([]*models.ModelFilter{}).GetByUuid(c.Param("uuid"))
+	if errors.Is(err, constants.ContactNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": contact, "success": true})
+}
+
+func UpdateContact(c *gin.Context) {
+	patch, err := helper.BindContactPatch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	contact, err := service.NewContactService(tempFilters).Update(c.Param("uuid"), patch)
+	if errors.Is(err, constants.ContactNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if errors.Is(err, constants.UpdateConflictError) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": contact, "success": true})
+}
+
+func DeleteContact(c *gin.Context) {
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	err = service.NewContactService(tempFilters).Delete(c.Param("uuid"), commonHelper.APIProvenance(c))
+	if errors.Is(err, constants.ContactNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
+
+func DeleteContactsByFilter(c *gin.Context) {
+	query, err := helper.BindAndValidateVQLQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	tempFilters, err := models.FiltersRepository(connections.PgDBConnection.Client).GetTempFilters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	deleted, err := service.NewContactService(tempFilters).DeleteByFilters(query, commonHelper.APIProvenance(c))
+	if errors.Is(err, constants.DeleteMatchLimitExceededError) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted, "success": true})
+}
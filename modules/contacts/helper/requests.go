@@ -1,8 +1,10 @@
 package helper
 
 import (
+	"time"
 	"vivek-ray/constants"
 	"vivek-ray/models"
+	commonHelper "vivek-ray/modules/common/helper"
 	companyService "vivek-ray/modules/companies/service"
 	"vivek-ray/utilities"
 
@@ -10,11 +12,6 @@ import (
 	"github.com/google/uuid"
 )
 
-type FilterStatusUpdate struct {
-	Active    bool   `json:"active"`
-	FilterKey string `json:"filter_key"`
-}
-
 func BindAndValidateVQLQuery(c *gin.Context) (utilities.VQLQuery, error) {
 	var query utilities.VQLQuery
 	if err := c.ShouldBindJSON(&query); err != nil {
@@ -38,23 +35,28 @@ func BindAndValidateFiltersDataQuery(c *gin.Context) (models.FiltersDataQuery, e
 	return query, nil
 }
 
-func BindFilterUpdateStatus(c *gin.Context) (FilterStatusUpdate, error) {
-	var statusUpdate FilterStatusUpdate
-	err := c.ShouldBindJSON(&statusUpdate)
-
-	return statusUpdate, err
-}
-
-func BindBatchUpsertRequest(c *gin.Context) ([]*models.PgContact, []*models.ElasticContact, error) {
+func BindBatchUpsertRequest(c *gin.Context) ([]*models.PgContact, utilities.UpsertOptions, error) {
 	pgContacts := make([]*models.PgContact, 0)
-	esContacts, companyUuids := make([]*models.ElasticContact, 0), make([]string, 0)
-	err := c.ShouldBindJSON(&pgContacts)
+	options, err := commonHelper.BindUpsertOptions(c)
 	if err != nil {
-		return nil, nil, err
+		return nil, options, err
+	}
+	if err := c.ShouldBindJSON(&pgContacts); err != nil {
+		return nil, options, err
 	}
 	if len(pgContacts) > constants.MaxPageSize {
-		return nil, nil, constants.PageSizeExceededError
+		return nil, options, constants.PageSizeExceededError
+	}
+	serverTime := time.Now()
+	for i, contact := range pgContacts {
+		contact.SetProvenance(options.Provenance.AtRow(i, &serverTime))
 	}
+	return pgContacts, options, nil
+}
+
+// BuildElasticContacts denormalises each contact with its stored company.
+func BuildElasticContacts(pgContacts []*models.PgContact) ([]*models.ElasticContact, error) {
+	esContacts, companyUuids := make([]*models.ElasticContact, 0), make([]string, 0)
 	for _, contact := range pgContacts {
 		if _, err := uuid.Parse(contact.CompanyID); err == nil {
 			companyUuids = append(companyUuids, contact.CompanyID)
@@ -62,7 +64,7 @@ func BindBatchUpsertRequest(c *gin.Context) ([]*models.PgContact, []*models.Elas
 	}
 	companies, err := companyService.NewCompanyService([]*models.ModelFilter{}).GetCompanyByUuids(companyUuids, []string{})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	companyMap := make(map[string]*models.PgCompany)
 	for _, company := range companies {
@@ -75,5 +77,18 @@ func BindBatchUpsertRequest(c *gin.Context) ([]*models.PgContact, []*models.Elas
 		}
 		esContacts = append(esContacts, models.ElasticContactFromRawData(contact, company))
 	}
-	return pgContacts, esContacts, nil
+	return esContacts, nil
+}
+
+// BindContactPatch reads a PATCH body and checks that every field exists on a
+// contact and holds a value of the right type.
+func BindContactPatch(c *gin.Context) (utilities.RecordPatch, error) {
+	patch, err := commonHelper.BindRecordPatch(c)
+	if err != nil {
+		return patch, err
+	}
+	if _, err := utilities.ApplyPatch(&models.PgContact{}, patch.Fields); err != nil {
+		return patch, err
+	}
+	return patch, nil
 }
@@ -2,6 +2,7 @@ package helper
 
 import (
 	"vivek-ray/models"
+	"vivek-ray/utilities"
 )
 
 type FilterDataResponse struct {
@@ -25,3 +26,19 @@ type ContactResponse struct {
 	Company *models.PgCompany `json:"company,omitempty"`
 	Cursor  []string          `json:"cursor,omitempty"`
 }
+
+// ProvenanceResponse shows where a contact came from as a whole (Source, the last
+// upsert that touched it) and where each populated field came from.
+type ProvenanceResponse struct {
+	UUID   string                          `json:"uuid"`
+	Source utilities.Provenance            `json:"source"`
+	Fields map[string]utilities.Provenance `json:"fields"`
+}
+
+func ToProvenanceResponse(contact *models.PgContact) ProvenanceResponse {
+	return ProvenanceResponse{
+		UUID:   contact.UUID,
+		Source: contact.Provenance(),
+		Fields: contact.Lineage(),
+	}
+}
@@ -10,4 +10,11 @@ func Routes(router *gin.RouterGroup) {
 	router.POST("/", controller.GetContactsByFilter)
 	router.POST("/count", controller.GetContactsCountByFilter)
 	router.POST("/batch-upsert", controller.BatchUpsert)
+	router.POST("/delete", controller.DeleteContactsByFilter)
+	router.GET("/:uuid", controller.GetContact)
+	router.PATCH("/:uuid", controller.UpdateContact)
+	router.DELETE("/:uuid", controller.DeleteContact)
+	router.GET("/:uuid/provenance", controller.GetContactProvenance)
+	router.GET("/:uuid/history", controller.GetContactHistory)
+	router.GET("/:uuid/snapshot", controller.GetContactSnapshot)
 }
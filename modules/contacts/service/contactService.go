@@ -3,11 +3,14 @@ package service
 import (
 	"errors"
 	"sync"
+	"time"
 	"vivek-ray/connections"
 	"vivek-ray/constants"
 	"vivek-ray/models"
 	"vivek-ray/modules/contacts/helper"
 	"vivek-ray/utilities"
+
+	"github.com/rs/zerolog/log"
 )
 
 type ContactService struct {
@@ -15,6 +18,7 @@ type ContactService struct {
 	contactPgRepository      models.PgContactSvcRepo
 	companyPgRepository      models.PgCompanySvcRepo
 	filtersDataRepository    models.FiltersDataSvcRepo
+	recordChangesRepository  models.RecordChangesSvcRepo
 	tempFilters              []*models.ModelFilter
 }
 
@@ -24,6 +28,7 @@ func NewContactService(tempFilters []*models.ModelFilter) ContactSvcRepo {
 		contactPgRepository:      models.PgContactRepository(connections.PgDBConnection.Client),
 		companyPgRepository:      models.PgCompanyRepository(connections.PgDBConnection.Client),
 		filtersDataRepository:    models.FiltersDataRepository(connections.PgDBConnection.Client),
+		recordChangesRepository:  models.RecordChangesRepository(connections.PgDBConnection.Client),
 		tempFilters:              tempFilters,
 	}
 }
@@ -31,8 +36,21 @@ func NewContactService(tempFilters []*models.ModelFilter) ContactSvcRepo {
 type ContactSvcRepo interface {
 	ListByFilters(query utilities.VQLQuery) ([]helper.ContactResponse, error)
 	CountByFilters(query utilities.VQLQuery) (int64, error)
-	BulkUpsert(pgContacts []*models.PgContact, esContacts []*models.ElasticContact) error
-	BulkUpsertToDb(pgContacts []*models.PgContact, esContacts []*models.ElasticContact, filtersData []*models.ModelFilterData) error
+	BulkUpsert(pgContacts []*models.PgContact, esContacts []*models.ElasticContact) (utilities.UpsertStats, error)
+	BulkUpsertToDb(pgContacts []*models.PgContact, esContacts []*models.ElasticContact, filtersData []*models.ModelFilterData) (utilities.UpsertStats, error)
+	GetProvenance(uuid string) (helper.ProvenanceResponse, error)
+	GetHistory(uuid string) ([]*models.ModelRecordChange, error)
+	GetSnapshot(uuid string, asOf time.Time) (map[string]any, error)
+	UpsertMerged(pgContacts []*models.PgContact, options utilities.UpsertOptions, buildElastic func([]*models.PgContact) ([]*models.ElasticContact, error)) (utilities.UpsertStats, error)
+	GetByUuid(uuid string) (helper.ContactResponse, error)
+	Update(uuid string, patch utilities.RecordPatch) (*models.PgContact, error)
+	Delete(uuid string, provenance utilities.Provenance) error
+	DeleteByFilters(query utilities.VQLQuery, provenance utilities.Provenance) (int64, error)
+	ListUuidsByFilters(query utilities.VQLQuery) ([]string, []string, error)
+	DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error)
+	PatchByUuids(uuids []string, patch utilities.RecordPatch) (utilities.UpsertStats, error)
+	UpsertLocked(pgContacts []*models.PgContact, resolve func(stored, contact *models.PgContact) bool,
+		buildElastic func([]*models.PgContact) ([]*models.ElasticContact, error)) (utilities.UpsertStats, error)
 }
 
 func (s *ContactService) ListByFilters(query utilities.VQLQuery) ([]helper.ContactResponse, error) {
@@ -99,6 +117,9 @@ func (s *ContactService) ListByFilters(query utilities.VQLQuery) ([]helper.Conta
 			})
 		}
 	}
+	if dropped := len(contactUuids) - len(contactResponses); dropped > 0 {
+		log.Warn().Msgf("%d contact hits have no postgres row, run a reconcile job to repair the index", dropped)
+	}
 	if shouldPopulateCompanies {
 		companiesMap := make(map[string]*models.PgCompany)
 		for _, company := range companies {
@@ -118,16 +139,18 @@ func (s *ContactService) CountByFilters(query utilities.VQLQuery) (int64, error)
 }
 
 func (s *ContactService) BulkUpsertToDb(pgContacts []*models.PgContact,
-	esContacts []*models.ElasticContact, filtersData []*models.ModelFilterData) error {
+	esContacts []*models.ElasticContact, filtersData []*models.ModelFilterData) (utilities.UpsertStats, error) {
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var insertionError error
+	var stats utilities.UpsertStats
 
 	wg.Add(3)
 	go func() {
 		defer wg.Done()
-		if _, err := s.contactPgRepository.BulkUpsert(pgContacts); err != nil {
+		var err error
+		if stats, err = s.contactPgRepository.BulkUpsert(pgContacts, nil); err != nil {
 			mu.Lock()
 			insertionError = errors.Join(insertionError, err)
 			mu.Unlock()
@@ -153,10 +176,10 @@ func (s *ContactService) BulkUpsertToDb(pgContacts []*models.PgContact,
 	}()
 
 	wg.Wait()
-	return insertionError
+	return stats, insertionError
 }
 
-func (s *ContactService) BulkUpsert(pgContacts []*models.PgContact, esContacts []*models.ElasticContact) error {
+func (s *ContactService) buildFiltersData(pgContacts []*models.PgContact) []*models.ModelFilterData {
 	insertedFilters, filtersData := make(map[string]struct{}), make([]*models.ModelFilterData, 0)
 
 	for _, contact := range pgContacts {
@@ -165,12 +188,9 @@ func (s *ContactService) BulkUpsert(pgContacts []*models.PgContact, esContacts [
 				continue
 			}
 
-			fieldValue := utilities.GetFieldValue(contact, filter.Key)
-			values := utilities.ToStringSlice(fieldValue)
-
-			for _, value := range values {
+			for _, value := range utilities.FilterValues(filter.Service, contact, filter.Key) {
 				filterUUID := utilities.GenerateUUID5(filter.Key + filter.Service + value)
-				if _, ok := insertedFilters[filterUUID]; ok || value == "" {
+				if _, ok := insertedFilters[filterUUID]; ok {
 					continue
 				}
 				insertedFilters[filterUUID] = struct{}{}
@@ -184,5 +204,336 @@ func (s *ContactService) BulkUpsert(pgContacts []*models.PgContact, esContacts [
 			}
 		}
 	}
-	return s.BulkUpsertToDb(pgContacts, esContacts, filtersData)
+	return filtersData
+}
+
+func (s *ContactService) BulkUpsert(pgContacts []*models.PgContact, esContacts []*models.ElasticContact) (utilities.UpsertStats, error) {
+	return s.BulkUpsertToDb(pgContacts, esContacts, s.buildFiltersData(pgContacts))
+}
+
+// UpsertMerged writes the contacts following the merge policy in options. Every
+// incoming contact that already exists is merged with its stored row inside the
+// upsert transaction, so a write landing in between cannot be overwritten. The
+// Elasticsearch documents and filter values are built from the merged contacts
+// once Postgres has them.
+func (s *ContactService) UpsertMerged(pgContacts []*models.PgContact, options utilities.UpsertOptions,
+	buildElastic func([]*models.PgContact) ([]*models.ElasticContact, error)) (utilities.UpsertStats, error) {
+
+	serverTime := time.Now()
+	for _, contact := range pgContacts {
+		if contact.SourceDate == nil {
+			contact.SourceDate = utilities.InlineIf(options.SourceDate != nil, options.SourceDate, &serverTime).(*time.Time)
+		}
+	}
+	return s.UpsertLocked(pgContacts, func(existing, contact *models.PgContact) bool {
+		if existing == nil {
+			contact.FieldSources = nil
+			return true
+		}
+		incomingIsNewer := utilities.IsNewerOrEqual(contact.SourceDate, existing.SourceDate)
+		kept := utilities.MergeStruct(existing, contact, options.MergePolicy, incomingIsNewer)
+		contact.FieldSources = utilities.CarryLineage(kept, existing.Provenance(), existing.FieldSources)
+		contact.CreatedAt = existing.CreatedAt
+		if !incomingIsNewer {
+			contact.SourceDate = existing.SourceDate
+		}
+		return true
+	}, buildElastic)
+}
+
+// UpsertLocked writes the contacts resolve keeps, after it settled each one
+// against its stored row locked in the upsert transaction, then indexes them.
+func (s *ContactService) UpsertLocked(pgContacts []*models.PgContact, resolve func(stored, contact *models.PgContact) bool,
+	buildElastic func([]*models.PgContact) ([]*models.ElasticContact, error)) (utilities.UpsertStats, error) {
+
+	written := make([]*models.PgContact, 0, len(pgContacts))
+	stats, err := s.contactPgRepository.BulkUpsert(pgContacts, func(stored, contact *models.PgContact) bool {
+		if !resolve(stored, contact) {
+			return false
+		}
+		written = append(written, contact)
+		return true
+	})
+	if err != nil || len(written) == 0 {
+		return stats, err
+	}
+	esContacts, err := buildElastic(written)
+	if err != nil {
+		return stats, err
+	}
+	return stats, s.upsertIndexes(esContacts, s.buildFiltersData(written))
+}
+
+// upsertIndexes writes the documents and filter values of contacts already
+// stored in Postgres.
+func (s *ContactService) upsertIndexes(esContacts []*models.ElasticContact, filtersData []*models.ModelFilterData) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var insertionError error
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := s.contactElasticRepository.BulkUpsert(esContacts); err != nil {
+			mu.Lock()
+			insertionError = errors.Join(insertionError, err)
+			mu.Unlock()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if err := s.filtersDataRepository.BulkUpsert(filtersData); err != nil {
+			mu.Lock()
+			insertionError = errors.Join(insertionError, err)
+			mu.Unlock()
+		}
+	}()
+
+	wg.Wait()
+	return insertionError
+}
+
+func (s *ContactService) getByUuid(uuid string) (*models.PgContact, error) {
+	contacts, err := s.contactPgRepository.ListByFilters(models.PgContactFilters{Uuids: []string{uuid}})
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, constants.ContactNotFoundError
+	}
+	return contacts[0], nil
+}
+
+func (s *ContactService) GetProvenance(uuid string) (helper.ProvenanceResponse, error) {
+	contact, err := s.getByUuid(uuid)
+	if err != nil {
+		return helper.ProvenanceResponse{}, err
+	}
+	return helper.ToProvenanceResponse(contact), nil
+}
+
+// GetHistory returns the change log of a contact, newest first.
+func (s *ContactService) GetHistory(uuid string) ([]*models.ModelRecordChange, error) {
+	if _, err := s.getByUuid(uuid); err != nil {
+		return nil, err
+	}
+	return s.recordChangesRepository.ListByRecord(constants.ContactsService, uuid, nil)
+}
+
+// GetSnapshot reconstructs a contact as it was at asOf by undoing, on its current
+// row, every change recorded after that time.
+func (s *ContactService) GetSnapshot(uuid string, asOf time.Time) (map[string]any, error) {
+	contact, err := s.getByUuid(uuid)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.recordChangesRepository.ListByRecord(constants.ContactsService, uuid, &asOf)
+	if err != nil {
+		return nil, err
+	}
+	state, existed, err := models.RewindState(contact, changes)
+	if err != nil {
+		return nil, err
+	}
+	if !existed {
+		return nil, constants.RecordNotFoundAtError
+	}
+	return state, nil
+}
+
+func (s *ContactService) GetByUuid(uuid string) (helper.ContactResponse, error) {
+	contact, err := s.getByUuid(uuid)
+	if err != nil {
+		return helper.ContactResponse{}, err
+	}
+	response := helper.ContactResponse{PgContact: contact}
+	if contact.CompanyID == "" {
+		return response, nil
+	}
+	companies, err := s.companyPgRepository.ListByFilters(models.PgCompanyFilters{Uuids: []string{contact.CompanyID}})
+	if err != nil {
+		return response, err
+	}
+	if len(companies) > 0 {
+		response.Company = companies[0]
+	}
+	return response, nil
+}
+
+// patchContact applies patch to a copy of existing. The patched fields are
+// attributed to the patch, the others keep their lineage.
+func patchContact(existing *models.PgContact, patch utilities.RecordPatch) (*models.PgContact, []string, error) {
+	contact := *existing
+	patched, err := utilities.ApplyPatch(&contact, patch.Fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverTime := time.Now()
+	contact.SetProvenance(patch.Provenance)
+	contact.FieldSources = utilities.CarryLineage(utilities.KeptByPatch(existing, patched), existing.Provenance(), existing.FieldSources)
+	contact.UpdatedAt = &serverTime
+	return &contact, patched, nil
+}
+
+// Update applies a partial update to a contact, then re-indexes it with its
+// company denormalised again, since the patch may have moved it to another
+// company.
+func (s *ContactService) Update(uuid string, patch utilities.RecordPatch) (*models.PgContact, error) {
+	contact, err := s.contactPgRepository.Update(uuid, patch.UpdatedAt, func(stored *models.PgContact) (*models.PgContact, []string, error) {
+		contact, patched, err := patchContact(stored, patch)
+		if err != nil {
+			return nil, nil, err
+		}
+		return contact, append(patched, utilities.PatchSystemColumns...), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	esContacts, err := helper.BuildElasticContacts([]*models.PgContact{contact})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.contactElasticRepository.BulkUpsert(esContacts); err != nil {
+		return nil, err
+	}
+	if err := s.filtersDataRepository.BulkUpsert(s.buildFiltersData([]*models.PgContact{contact})); err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+func (s *ContactService) Delete(uuid string, provenance utilities.Provenance) error {
+	contact, err := s.getByUuid(uuid)
+	if err != nil {
+		return err
+	}
+	_, err = s.deleteRecords([]*models.PgContact{contact}, provenance)
+	return err
+}
+
+// DeleteByFilters soft deletes every contact matching a VQL query, paging
+// through the matches by uuid. Queries matching more than
+// MaxDeleteByFiltersRecords records are rejected; delete_by_vql jobs take
+// those.
+func (s *ContactService) DeleteByFilters(query utilities.VQLQuery, provenance utilities.Provenance) (int64, error) {
+	query.CompanyConfig = nil
+	matched, err := s.CountByFilters(query)
+	if err != nil {
+		return 0, err
+	}
+	if matched > constants.MaxDeleteByFiltersRecords {
+		return 0, constants.DeleteMatchLimitExceededError
+	}
+
+	query.OrderBy = []utilities.FilterOrder{{OrderBy: "uuid", OrderDirection: "desc"}}
+	query.Cursor, query.Page, query.Limit = nil, 0, constants.DeleteByFiltersBatchSize
+	var deleted int64
+	for {
+		uuids, cursor, err := s.ListUuidsByFilters(query)
+		if err != nil || len(uuids) == 0 {
+			return deleted, err
+		}
+		batchDeleted, err := s.DeleteByUuids(uuids, provenance)
+		deleted += batchDeleted
+		if err != nil {
+			return deleted, err
+		}
+		query.Cursor = cursor
+	}
+}
+
+// ListUuidsByFilters returns the uuids of a page of Elasticsearch hits and the
+// cursor of the last one. Hits without a live Postgres row are kept, so that
+// callers paging through a query do not stop at a page of stale documents.
+func (s *ContactService) ListUuidsByFilters(query utilities.VQLQuery) ([]string, []string, error) {
+	esHits, err := s.contactElasticRepository.ListByQueryMap(query.ToElasticsearchQuery(false, []string{"uuid"}))
+	if err != nil || len(esHits) == 0 {
+		return nil, nil, err
+	}
+	uuids := make([]string, 0, len(esHits))
+	for _, esHit := range esHits {
+		uuids = append(uuids, esHit.Contact.UUID)
+	}
+	return uuids, esHits[len(esHits)-1].Cursor, nil
+}
+
+// DeleteByUuids soft deletes the live contacts among uuids.
+func (s *ContactService) DeleteByUuids(uuids []string, provenance utilities.Provenance) (int64, error) {
+	contacts, err := s.contactPgRepository.ListByFilters(models.PgContactFilters{Uuids: uuids})
+	if err != nil {
+		return 0, err
+	}
+	return s.deleteRecords(contacts, provenance)
+}
+
+// PatchByUuids applies the same partial update to every live contact among
+// uuids. Each one is patched on its row as locked by the upsert, so writes
+// landing meanwhile are kept; uuids without a live row are skipped.
+func (s *ContactService) PatchByUuids(uuids []string, patch utilities.RecordPatch) (utilities.UpsertStats, error) {
+	// a patch that does not decode fails before any row is locked
+	if _, _, err := patchContact(&models.PgContact{}, patch); err != nil {
+		return utilities.UpsertStats{}, err
+	}
+	contacts := make([]*models.PgContact, 0, len(uuids))
+	for _, uuid := range utilities.UniqueStringSlice(uuids) {
+		contacts = append(contacts, &models.PgContact{UUID: uuid})
+	}
+	return s.UpsertLocked(contacts, func(stored, contact *models.PgContact) bool {
+		if stored == nil {
+			return false
+		}
+		patched, _, _ := patchContact(stored, patch)
+		*contact = *patched
+		return true
+	}, helper.BuildElasticContacts)
+}
+
+// deleteRecords soft deletes contacts in Postgres, drops their documents from
+// Elasticsearch and hides the filter values no live contact carries anymore.
+func (s *ContactService) deleteRecords(contacts []*models.PgContact, provenance utilities.Provenance) (int64, error) {
+	uuids := make([]string, 0, len(contacts))
+	for _, contact := range contacts {
+		uuids = append(uuids, contact.UUID)
+	}
+	deleted, err := s.contactPgRepository.DeleteByUuids(uuids, provenance)
+	if err != nil {
+		return deleted, err
+	}
+	if _, err := s.contactElasticRepository.BulkDelete(uuids); err != nil {
+		return deleted, err
+	}
+	return deleted, s.pruneFiltersData(contacts)
+}
+
+// pruneFiltersData hides the filter values of contacts that no live contact
+// carries anymore. The values of each filter are checked in one query.
+func (s *ContactService) pruneFiltersData(contacts []*models.PgContact) error {
+	byKey := make(map[string][]*models.ModelFilterData)
+	for _, filterData := range s.buildFiltersData(contacts) {
+		byKey[filterData.FilterKey] = append(byKey[filterData.FilterKey], filterData)
+	}
+	stale := make([]string, 0)
+	for key, filtersData := range byKey {
+		_, isArray := utilities.GetFieldValue(&models.PgContact{}, key).([]string)
+		values := make([]string, 0, len(filtersData))
+		for _, filterData := range filtersData {
+			values = append(values, filterData.Value)
+		}
+		carried, err := s.contactPgRepository.CarriedValues(key, values, isArray)
+		if err != nil {
+			return err
+		}
+		carriedSet := make(map[string]struct{}, len(carried))
+		for _, value := range carried {
+			carriedSet[value] = struct{}{}
+		}
+		for _, filterData := range filtersData {
+			if _, ok := carriedSet[filterData.Value]; !ok {
+				stale = append(stale, filterData.UUID)
+			}
+		}
+	}
+	_, err := s.filtersDataRepository.SoftDelete(stale)
+	return err
 }
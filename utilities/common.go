@@ -2,6 +2,8 @@ package utilities
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -43,6 +45,28 @@ func GetFieldValue(v interface{}, fieldName string) any {
 	return nil
 }
 
+// FieldType returns the type of the field GetFieldValue would read, or nil
+// when v has no such field.
+func FieldType(v interface{}, fieldName string) reflect.Type {
+	rt := reflect.TypeOf(v)
+	if rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if jsonTag := strings.Split(field.Tag.Get("json"), ",")[0]; jsonTag != "" && jsonTag == fieldName {
+			return field.Type
+		}
+		if strings.EqualFold(field.Name, fieldName) {
+			return field.Type
+		}
+	}
+	return nil
+}
+
 func ToStringSlice(v interface{}) []string {
 	if v == nil {
 		return nil
@@ -69,7 +93,26 @@ func ToStringSlice(v interface{}) []string {
 		return result
 	}
 
-	return []string{fmt.Sprintf("%v", v)}
+	return []string{fmt.Sprintf("%v", rv.Interface())}
+}
+
+// FilterValues returns the values of the field key of a record of service that
+// filters_data holds: every element of a contact field as text, zero values
+// included, and company fields that are strings. Empty strings are skipped.
+func FilterValues(service string, record any, key string) []string {
+	var values []string
+	if service == constants.CompaniesService {
+		if value, _ := GetFieldValue(record, key).(string); value != "" {
+			values = append(values, value)
+		}
+		return values
+	}
+	for _, value := range ToStringSlice(GetFieldValue(record, key)) {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
 
 func AddToBuffer(buf *bytes.Buffer, data any) error {
@@ -192,17 +235,30 @@ func CsvRowToMap(headers, row []string) map[string]string {
 func StructToCsvSlice(v interface{}, columns []string) []string {
 	row := make([]string, len(columns))
 	for i, col := range columns {
-		val := GetFieldValue(v, col)
-		if val == nil {
-			row[i] = ""
-			continue
-		}
-		switch v := val.(type) {
-		case []string:
-			row[i] = strings.Join(v, ",")
-		default:
-			row[i] = fmt.Sprintf("%v", v)
-		}
+		row[i] = CsvValue(GetFieldValue(v, col))
 	}
 	return row
 }
+
+// CsvValue formats a field value as a csv cell; arrays are joined with commas.
+func CsvValue(val any) string {
+	if val == nil {
+		return ""
+	}
+	switch v := val.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// APIKeyFingerprint returns a short, non-reversible identifier for an API key
+// so records can be traced to a caller without storing the key.
+func APIKeyFingerprint(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,40 @@
+package utilities
+
+import (
+	"reflect"
+	"testing"
+	"vivek-ray/constants"
+)
+
+type filterValuesRecord struct {
+	Title       string   `json:"title"`
+	Departments []string `json:"departments"`
+	Employees   int64    `json:"employees"`
+	Revenue     *int64   `json:"revenue"`
+}
+
+func TestFilterValues(t *testing.T) {
+	revenue := int64(0)
+	record := &filterValuesRecord{Title: "CEO", Departments: []string{"sales", "", "ops"}, Revenue: &revenue}
+	tests := []struct {
+		name    string
+		service string
+		key     string
+		want    []string
+	}{
+		{name: "contact string", service: constants.ContactsService, key: "title", want: []string{"CEO"}},
+		{name: "contact array skips empty elements", service: constants.ContactsService, key: "departments", want: []string{"sales", "ops"}},
+		{name: "contact zero value is kept", service: constants.ContactsService, key: "employees", want: []string{"0"}},
+		{name: "contact pointer to zero is kept", service: constants.ContactsService, key: "revenue", want: []string{"0"}},
+		{name: "company string", service: constants.CompaniesService, key: "title", want: []string{"CEO"}},
+		{name: "company only takes strings", service: constants.CompaniesService, key: "departments", want: nil},
+		{name: "company number is not a value", service: constants.CompaniesService, key: "employees", want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := FilterValues(test.service, record, test.key); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("FilterValues(%q) = %#v, want %#v", test.key, got, test.want)
+			}
+		})
+	}
+}
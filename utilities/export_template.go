@@ -0,0 +1,73 @@
+package utilities
+
+import "vivek-ray/constants"
+
+// Inputs returns the source columns the column reads, in order.
+func (c ExportTemplateColumn) Inputs() []string {
+	if len(c.Sources) > 0 {
+		return c.Sources
+	}
+	return []string{c.Source}
+}
+
+// OutputHeader is the header of the column, the source column by default.
+func (c ExportTemplateColumn) OutputHeader() string {
+	return InlineIf(c.Header != "", c.Header, c.Source).(string)
+}
+
+// Typed reports whether the column passes its source value through as is,
+// so that it keeps the source's type in typed formats.
+func (c ExportTemplateColumn) Typed() bool {
+	return len(c.Sources) == 0 && len(c.Transforms) == 0 && c.Default == ""
+}
+
+// Validate checks that the column has its sources, that its transforms are
+// known and that they leave a single value.
+func (c ExportTemplateColumn) Validate() error {
+	if (c.Source == "") == (len(c.Sources) == 0) || (len(c.Sources) > 0 && c.Header == "") {
+		return constants.ExportTemplateSourceError(InlineIf(c.Header != "", c.Header, c.Source).(string))
+	}
+	values := len(c.Inputs())
+	for _, transform := range c.Transforms {
+		switch transform.Type {
+		case constants.ExportTransformJoin, constants.ExportTransformCoalesce:
+			values = 1
+		case constants.ExportTransformLowercase, constants.ExportTransformFormatPhone:
+		default:
+			return constants.InvalidExportTransformError(c.OutputHeader(), transform.Type)
+		}
+	}
+	if values > 1 {
+		return constants.ExportTemplateArityError(c.OutputHeader())
+	}
+	return nil
+}
+
+// ValidateExportTemplate checks every column of a template and that no two
+// share a header.
+func ValidateExportTemplate(columns []ExportTemplateColumn) error {
+	if len(columns) == 0 {
+		return constants.ExportTemplateColumnsRequiredError
+	}
+	headers := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if err := column.Validate(); err != nil {
+			return err
+		}
+		if headers[column.OutputHeader()] {
+			return constants.DuplicateExportHeaderError(column.OutputHeader())
+		}
+		headers[column.OutputHeader()] = true
+	}
+	return nil
+}
+
+// ExportTemplateSources returns the distinct source columns of a template, in
+// the order the columns first read them.
+func ExportTemplateSources(columns []ExportTemplateColumn) []string {
+	sources := make([]string, 0, len(columns))
+	for _, column := range columns {
+		sources = append(sources, column.Inputs()...)
+	}
+	return UniqueStringSlice(sources)
+}
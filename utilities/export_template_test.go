@@ -0,0 +1,65 @@
+package utilities
+
+import (
+	"testing"
+	"vivek-ray/constants"
+)
+
+func TestValidateExportTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []ExportTemplateColumn
+		wantErr error
+	}{
+		{
+			name:    "no columns",
+			wantErr: constants.ExportTemplateColumnsRequiredError,
+		},
+		{
+			name: "valid template",
+			columns: []ExportTemplateColumn{
+				{Source: "email", Transforms: []ExportTransform{{Type: constants.ExportTransformLowercase}}},
+				{Header: "name", Sources: []string{"first_name", "last_name"}, Transforms: []ExportTransform{{Type: constants.ExportTransformJoin}}},
+				{Header: "phone", Sources: []string{"mobile_phone", "home_phone"}, Transforms: []ExportTransform{{Type: constants.ExportTransformCoalesce}, {Type: constants.ExportTransformFormatPhone}}},
+			},
+		},
+		{
+			name:    "no source",
+			columns: []ExportTemplateColumn{{Header: "email"}},
+			wantErr: constants.ExportTemplateSourceError("email"),
+		},
+		{
+			name:    "both source and sources",
+			columns: []ExportTemplateColumn{{Header: "name", Source: "first_name", Sources: []string{"last_name"}}},
+			wantErr: constants.ExportTemplateSourceError("name"),
+		},
+		{
+			name:    "sources without a header",
+			columns: []ExportTemplateColumn{{Sources: []string{"first_name", "last_name"}, Transforms: []ExportTransform{{Type: constants.ExportTransformJoin}}}},
+			wantErr: constants.ExportTemplateSourceError(""),
+		},
+		{
+			name:    "unknown transform",
+			columns: []ExportTemplateColumn{{Source: "email", Transforms: []ExportTransform{{Type: "uppercase"}}}},
+			wantErr: constants.InvalidExportTransformError("email", "uppercase"),
+		},
+		{
+			name:    "sources left as several values",
+			columns: []ExportTemplateColumn{{Header: "name", Sources: []string{"first_name", "last_name"}, Transforms: []ExportTransform{{Type: constants.ExportTransformLowercase}}}},
+			wantErr: constants.ExportTemplateArityError("name"),
+		},
+		{
+			name:    "duplicate header",
+			columns: []ExportTemplateColumn{{Source: "email"}, {Header: "email", Source: "personal_email"}},
+			wantErr: constants.DuplicateExportHeaderError("email"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateExportTemplate(test.columns)
+			if (err == nil) != (test.wantErr == nil) || (err != nil && err.Error() != test.wantErr.Error()) {
+				t.Errorf("ValidateExportTemplate() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package utilities
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"vivek-ray/constants"
+)
+
+// Columns owned by the system rather than the data source; they are never
+// subject to a merge policy.
+var protectedMergeFields = map[string]struct{}{
+	"id":          {},
+	"uuid":        {},
+	"created_at":  {},
+	"updated_at":  {},
+	"deleted_at":  {},
+	"source_date": {},
+
+	"source_job":     {},
+	"source_api_key": {},
+	"source_file":    {},
+	"source_row":     {},
+	"ingested_at":    {},
+	"field_sources":  {},
+}
+
+// System columns rewritten by every single-record update alongside the
+// patched fields.
+var PatchSystemColumns = []string{
+	"source_job", "source_api_key", "source_file", "source_row", "ingested_at", "field_sources", "updated_at",
+}
+
+var sourceDateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func isValidMergePolicy(policy string) bool {
+	switch policy {
+	case constants.MergeOverwrite, constants.MergeKeepExisting, constants.MergePreferNewer, constants.MergeUnion:
+		return true
+	}
+	return false
+}
+
+// Validate checks the policies, and that every key of Fields is the json name
+// of a data field of at least one of records, which are pointers to the
+// record types the policy is applied to.
+func (p *MergePolicy) Validate(records ...any) error {
+	if p.Default != "" && !isValidMergePolicy(p.Default) {
+		return constants.InvalidMergePolicyError("default", p.Default)
+	}
+	for field, policy := range p.Fields {
+		if !isValidMergePolicy(policy) {
+			return constants.InvalidMergePolicyError(field, policy)
+		}
+		if !slices.ContainsFunc(records, func(record any) bool { return IsDataField(record, field) }) {
+			return constants.InvalidMergeFieldError(field)
+		}
+	}
+	return nil
+}
+
+func (p *MergePolicy) defaultPolicy() string {
+	return InlineIf(p.Default != "", p.Default, constants.MergeOverwrite).(string)
+}
+
+func (p *MergePolicy) For(field string) string {
+	if policy, ok := p.Fields[field]; ok {
+		return policy
+	}
+	return p.defaultPolicy()
+}
+
+// MergeStruct folds existing into incoming in place according to policy.
+// Both arguments must be pointers to the same struct type. incomingIsNewer
+// drives the prefer_newer policy. It returns the json names of the fields
+// whose stored value was kept, so their lineage can be carried over.
+func MergeStruct(existing, incoming any, policy MergePolicy, incomingIsNewer bool) []string {
+	existingValue := reflect.ValueOf(existing).Elem()
+	incomingValue := reflect.ValueOf(incoming).Elem()
+	structType := incomingValue.Type()
+	kept := make([]string, 0)
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if _, ok := protectedMergeFields[name]; ok || name == "" || name == "-" {
+			continue
+		}
+
+		existingField, incomingField := existingValue.Field(i), incomingValue.Field(i)
+		switch policy.For(name) {
+		case constants.MergeKeepExisting:
+			if !isEmptyField(existingField) {
+				incomingField.Set(existingField)
+				kept = append(kept, name)
+			}
+		case constants.MergePreferNewer:
+			if !incomingIsNewer && !isEmptyField(existingField) {
+				incomingField.Set(existingField)
+				kept = append(kept, name)
+			}
+		case constants.MergeUnion:
+			// union only has meaning for array columns; scalars are overwritten
+			if values, ok := existingField.Interface().([]string); ok {
+				merged := UniqueStringSlice(append(append([]string{}, values...), incomingField.Interface().([]string)...))
+				incomingField.Set(reflect.ValueOf(merged))
+			}
+		}
+	}
+	return kept
+}
+
+// CarryLineage builds the field_sources of a merged record: every field kept
+// from the stored row keeps pointing at whichever source wrote it, while all
+// other fields are attributed to the record level provenance of the upsert.
+func CarryLineage(kept []string, existingSource Provenance, existingFields map[string]Provenance) map[string]Provenance {
+	if len(kept) == 0 {
+		return nil
+	}
+	fieldSources := make(map[string]Provenance, len(kept))
+	for _, name := range kept {
+		if source, ok := existingFields[name]; ok {
+			fieldSources[name] = source
+		} else {
+			fieldSources[name] = existingSource
+		}
+	}
+	return fieldSources
+}
+
+// PopulatedMergeFields lists the json names of the non-empty data fields of
+// record, i.e. the fields a merge policy and lineage apply to.
+func PopulatedMergeFields(record any) []string {
+	recordValue := reflect.ValueOf(record).Elem()
+	structType := recordValue.Type()
+	fields := make([]string, 0, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if _, ok := protectedMergeFields[name]; ok || name == "" || name == "-" {
+			continue
+		}
+		if !isEmptyField(recordValue.Field(i)) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// isEmptyField reports whether a field holds no value. Array columns scan
+// as empty rather than nil slices, which count as empty too.
+func isEmptyField(value reflect.Value) bool {
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Map {
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// dataFieldIndexes maps the json names of the data fields of a model struct,
+// i.e. all but the system columns, to their field index.
+func dataFieldIndexes(structType reflect.Type) map[string]int {
+	indexes := make(map[string]int, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if _, ok := protectedMergeFields[name]; ok || name == "" || name == "-" {
+			continue
+		}
+		indexes[name] = i
+	}
+	return indexes
+}
+
+// IsDataField reports whether name is the json name of a data field of
+// record, a pointer to a model struct.
+func IsDataField(record any, name string) bool {
+	_, ok := dataFieldIndexes(reflect.TypeOf(record).Elem())[name]
+	return ok
+}
+
+// ApplyPatch decodes a partial update onto record, a pointer to a model
+// struct, touching only the fields present in fields. System columns cannot
+// be patched. It returns the sorted json names of the patched fields.
+func ApplyPatch(record any, fields map[string]json.RawMessage) ([]string, error) {
+	recordValue := reflect.ValueOf(record).Elem()
+	indexes := dataFieldIndexes(recordValue.Type())
+
+	patched := make([]string, 0, len(fields))
+	for name, raw := range fields {
+		index, ok := indexes[name]
+		if !ok {
+			return nil, constants.InvalidPatchFieldError(name)
+		}
+		field := recordValue.Field(index)
+		// decode into a fresh value so a null clears the field
+		value := reflect.New(field.Type())
+		if err := json.Unmarshal(raw, value.Interface()); err != nil {
+			return nil, constants.InvalidPatchValueError(name, err)
+		}
+		field.Set(value.Elem())
+		patched = append(patched, name)
+	}
+	sort.Strings(patched)
+	return patched, nil
+}
+
+// KeptByPatch lists the populated fields of existing that a patch leaves
+// untouched, whose lineage must be carried over like merge-kept fields.
+func KeptByPatch(existing any, patched []string) []string {
+	kept := make([]string, 0)
+	for _, name := range PopulatedMergeFields(existing) {
+		if !slices.Contains(patched, name) {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// FieldChange is one entry of a record's change history. A nil Old means the
+// field was empty before, a nil New that it was cleared.
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+func fieldValue(value reflect.Value) any {
+	if value.IsZero() {
+		return nil
+	}
+	return value.Interface()
+}
+
+// DiffStruct compares the data fields of two records of the same struct type
+// and returns the ones that differ, keyed by json name. A nil existing diffs
+// against an empty record.
+func DiffStruct(existing, incoming any) map[string]FieldChange {
+	incomingValue := reflect.ValueOf(incoming).Elem()
+	structType := incomingValue.Type()
+	existingValue := reflect.New(structType).Elem()
+	if existing != nil && !reflect.ValueOf(existing).IsNil() {
+		existingValue = reflect.ValueOf(existing).Elem()
+	}
+
+	changes := make(map[string]FieldChange)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if _, ok := protectedMergeFields[name]; ok || name == "" || name == "-" {
+			continue
+		}
+		existingField, incomingField := existingValue.Field(i), incomingValue.Field(i)
+		if existingField.IsZero() && incomingField.IsZero() {
+			continue
+		}
+		if reflect.DeepEqual(existingField.Interface(), incomingField.Interface()) {
+			continue
+		}
+		changes[name] = FieldChange{Old: fieldValue(existingField), New: fieldValue(incomingField)}
+	}
+	return changes
+}
+
+// ParseSourceDate reads the optional source_date column of an import row.
+func ParseSourceDate(value string) *time.Time {
+	for _, layout := range sourceDateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return &parsed
+		}
+	}
+	return nil
+}
+
+// IsNewerOrEqual reports whether incoming is at least as recent as existing;
+// a missing date on either side counts as newer for the incoming record.
+func IsNewerOrEqual(incoming, existing *time.Time) bool {
+	if incoming == nil || existing == nil {
+		return true
+	}
+	return !incoming.Before(*existing)
+}
@@ -0,0 +1,221 @@
+package utilities
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"vivek-ray/constants"
+)
+
+type mergeRecord struct {
+	UUID        string   `json:"uuid"`
+	Title       string   `json:"title"`
+	City        string   `json:"city"`
+	Departments []string `json:"departments"`
+	Employees   int64    `json:"employees"`
+}
+
+func TestMergeStruct(t *testing.T) {
+	existing := &mergeRecord{UUID: "a", Title: "CTO", City: "Pune", Departments: []string{"sales", "ops"}}
+	tests := []struct {
+		name            string
+		existing        *mergeRecord
+		incoming        mergeRecord
+		policy          MergePolicy
+		incomingIsNewer bool
+		want            mergeRecord
+		wantKept        []string
+	}{
+		{
+			name:     "overwrite by default",
+			incoming: mergeRecord{UUID: "b", Title: "CEO"},
+			want:     mergeRecord{UUID: "b", Title: "CEO"},
+			wantKept: []string{},
+		},
+		{
+			name:     "keep existing skips empty stored values",
+			incoming: mergeRecord{Title: "CEO", Employees: 10},
+			policy:   MergePolicy{Default: constants.MergeKeepExisting},
+			want:     mergeRecord{Title: "CTO", City: "Pune", Departments: []string{"sales", "ops"}, Employees: 10},
+			wantKept: []string{"title", "city", "departments"},
+		},
+		{
+			name:            "prefer newer keeps the stored value of an older record",
+			incoming:        mergeRecord{Title: "CEO"},
+			policy:          MergePolicy{Fields: map[string]string{"title": constants.MergePreferNewer}},
+			incomingIsNewer: false,
+			want:            mergeRecord{Title: "CTO"},
+			wantKept:        []string{"title"},
+		},
+		{
+			name:            "prefer newer takes a newer record",
+			incoming:        mergeRecord{Title: "CEO"},
+			policy:          MergePolicy{Fields: map[string]string{"title": constants.MergePreferNewer}},
+			incomingIsNewer: true,
+			want:            mergeRecord{Title: "CEO"},
+			wantKept:        []string{},
+		},
+		{
+			name:     "keep existing does not keep an empty array",
+			existing: &mergeRecord{Departments: []string{}},
+			incoming: mergeRecord{Departments: []string{"hr"}},
+			policy:   MergePolicy{Fields: map[string]string{"departments": constants.MergeKeepExisting}},
+			want:     mergeRecord{Departments: []string{"hr"}},
+			wantKept: []string{},
+		},
+		{
+			name:            "prefer newer does not keep an empty array",
+			existing:        &mergeRecord{Departments: []string{}},
+			incoming:        mergeRecord{Departments: []string{"hr"}},
+			policy:          MergePolicy{Fields: map[string]string{"departments": constants.MergePreferNewer}},
+			incomingIsNewer: false,
+			want:            mergeRecord{Departments: []string{"hr"}},
+			wantKept:        []string{},
+		},
+		{
+			name:     "union joins arrays and overwrites scalars",
+			incoming: mergeRecord{Title: "CEO", Departments: []string{"ops", "hr"}},
+			policy:   MergePolicy{Default: constants.MergeUnion},
+			want:     mergeRecord{Title: "CEO", Departments: []string{"sales", "ops", "hr"}},
+			wantKept: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing := existing
+			if test.existing != nil {
+				existing = test.existing
+			}
+			incoming := test.incoming
+			kept := MergeStruct(existing, &incoming, test.policy, test.incomingIsNewer)
+			if !reflect.DeepEqual(incoming, test.want) {
+				t.Errorf("merged = %+v, want %+v", incoming, test.want)
+			}
+			if !reflect.DeepEqual(kept, test.wantKept) {
+				t.Errorf("kept = %v, want %v", kept, test.wantKept)
+			}
+		})
+	}
+}
+
+func TestPopulatedMergeFields(t *testing.T) {
+	record := &mergeRecord{UUID: "a", Title: "CEO", Departments: []string{}, Employees: 10}
+	if got, want := PopulatedMergeFields(record), []string{"title", "employees"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("PopulatedMergeFields() = %v, want %v", got, want)
+	}
+}
+
+func TestDiffStruct(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *mergeRecord
+		incoming *mergeRecord
+		want     map[string]FieldChange
+	}{
+		{
+			name:     "nil existing diffs against an empty record",
+			incoming: &mergeRecord{UUID: "a", Title: "CEO"},
+			want:     map[string]FieldChange{"title": {New: "CEO"}},
+		},
+		{
+			name:     "changed and cleared fields",
+			existing: &mergeRecord{Title: "CTO", City: "Pune", Departments: []string{"sales"}},
+			incoming: &mergeRecord{Title: "CEO", Departments: []string{"sales"}},
+			want:     map[string]FieldChange{"title": {Old: "CTO", New: "CEO"}, "city": {Old: "Pune"}},
+		},
+		{
+			name:     "system fields are ignored",
+			existing: &mergeRecord{UUID: "a", Title: "CEO"},
+			incoming: &mergeRecord{UUID: "b", Title: "CEO"},
+			want:     map[string]FieldChange{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DiffStruct(test.existing, test.incoming); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DiffStruct() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		fields      map[string]json.RawMessage
+		want        mergeRecord
+		wantPatched []string
+		wantErr     bool
+	}{
+		{
+			name:        "sets only the given fields",
+			fields:      map[string]json.RawMessage{"title": json.RawMessage(`"CEO"`), "employees": json.RawMessage(`10`)},
+			want:        mergeRecord{Title: "CEO", City: "Pune", Departments: []string{"sales"}, Employees: 10},
+			wantPatched: []string{"employees", "title"},
+		},
+		{
+			name:        "null clears a field",
+			fields:      map[string]json.RawMessage{"departments": json.RawMessage(`null`)},
+			want:        mergeRecord{Title: "CTO", City: "Pune"},
+			wantPatched: []string{"departments"},
+		},
+		{
+			name:    "system field",
+			fields:  map[string]json.RawMessage{"uuid": json.RawMessage(`"b"`)},
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			fields:  map[string]json.RawMessage{"country": json.RawMessage(`"India"`)},
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			fields:  map[string]json.RawMessage{"employees": json.RawMessage(`"ten"`)},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := &mergeRecord{Title: "CTO", City: "Pune", Departments: []string{"sales"}}
+			patched, err := ApplyPatch(record, test.fields)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ApplyPatch() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyPatch() error = %v", err)
+			}
+			if !reflect.DeepEqual(*record, test.want) {
+				t.Errorf("patched record = %+v, want %+v", *record, test.want)
+			}
+			if !reflect.DeepEqual(patched, test.wantPatched) {
+				t.Errorf("patched fields = %v, want %v", patched, test.wantPatched)
+			}
+		})
+	}
+}
+
+func TestMergePolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  MergePolicy
+		wantErr bool
+	}{
+		{name: "empty policy", policy: MergePolicy{}},
+		{name: "known policies", policy: MergePolicy{Default: constants.MergeKeepExisting, Fields: map[string]string{"title": constants.MergeUnion}}},
+		{name: "unknown default", policy: MergePolicy{Default: "latest"}, wantErr: true},
+		{name: "unknown field policy", policy: MergePolicy{Fields: map[string]string{"title": "latest"}}, wantErr: true},
+		{name: "unknown field", policy: MergePolicy{Fields: map[string]string{"country": constants.MergeOverwrite}}, wantErr: true},
+		{name: "system field", policy: MergePolicy{Fields: map[string]string{"uuid": constants.MergeOverwrite}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.policy.Validate(&mergeRecord{}); (err != nil) != test.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
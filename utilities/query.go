@@ -1,7 +1,10 @@
 package utilities
 
 import (
+	"maps"
 	"reflect"
+	"strings"
+	"unicode"
 	"vivek-ray/constants"
 )
 
@@ -202,3 +205,83 @@ func (q *VQLQuery) ToElasticsearchQuery(forCount bool, sourceFields []string) ma
 	resultQuery["query"] = map[string]any{"bool": boolQuery}
 	return resultQuery
 }
+
+// EscapeLike escapes the LIKE wildcards in value so that it matches literally.
+func EscapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// prefixRegex turns prefix into a Lucene regular expression that matches the
+// values starting with it, ignoring case like the ILIKE prefix search does.
+func prefixRegex(prefix string) string {
+	var builder strings.Builder
+	for _, r := range prefix {
+		lower, upper := unicode.ToLower(r), unicode.ToUpper(r)
+		switch {
+		case lower != upper:
+			builder.WriteString("[" + string(lower) + string(upper) + "]")
+		case strings.ContainsRune(`.?+*|{}[]()"\#@&<>~`, r):
+			builder.WriteString(`\` + string(r))
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	builder.WriteString(".*")
+	return builder.String()
+}
+
+// WithoutFilterKey returns a copy of the where clause without the conditions
+// on key, so that the current selection of a filter does not hide its other
+// options.
+func (w WhereStruct) WithoutFilterKey(key string) WhereStruct {
+	withoutKey := func(conditions map[string]any) map[string]any {
+		conditions = maps.Clone(conditions)
+		delete(conditions, key)
+		return conditions
+	}
+	withoutText := func(conditions []TextMatchStruct) []TextMatchStruct {
+		kept := make([]TextMatchStruct, 0, len(conditions))
+		for _, condition := range conditions {
+			if condition.FilterKey != key {
+				kept = append(kept, condition)
+			}
+		}
+		return kept
+	}
+	return WhereStruct{
+		TextMatch: TextMatchQuery{
+			Must:    withoutText(w.TextMatch.Must),
+			MustNot: withoutText(w.TextMatch.MustNot),
+		},
+		KeywordMatch: ElasticQuery{
+			Must:    withoutKey(w.KeywordMatch.Must),
+			MustNot: withoutKey(w.KeywordMatch.MustNot),
+		},
+		RangeQuery: ElasticQuery{
+			Must:    withoutKey(w.RangeQuery.Must),
+			MustNot: withoutKey(w.RangeQuery.MustNot),
+		},
+	}
+}
+
+// ToTermsAggregation counts the values of field over the records matching the
+// query. No hits are returned; the buckets come back under "values", ordered
+// by document count or alphabetically, and only those starting with prefix
+// when it is set.
+func (q *VQLQuery) ToTermsAggregation(field, prefix, sortBy string, size int) map[string]any {
+	terms := map[string]any{
+		"field": field,
+		"size":  size,
+		"order": InlineIf(sortBy == constants.FilterSortAlpha, map[string]any{"_key": "asc"}, map[string]any{"_count": "desc"}),
+	}
+	if prefix != "" {
+		terms["include"] = prefixRegex(prefix)
+	}
+	resultQuery := q.ToElasticsearchQuery(true, nil)
+	resultQuery["size"] = 0
+	resultQuery["track_total_hits"] = false
+	resultQuery["aggs"] = map[string]any{
+		"values": map[string]any{"terms": terms},
+	}
+	return resultQuery
+}
@@ -0,0 +1,96 @@
+package utilities
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestPrefixRegex(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		want    string
+		matches []string
+		misses  []string
+	}{
+		{name: "empty", prefix: "", want: ".*", matches: []string{"", "Sales"}},
+		{name: "letters ignore case", prefix: "Sa", want: "[sS][aA].*", matches: []string{"Sales", "sales", "SAP"}, misses: []string{"ops", "a sale"}},
+		{name: "digits and spaces are literal", prefix: "1 a", want: "1 [aA].*", matches: []string{"1 A"}, misses: []string{"1a"}},
+		{name: "reserved characters are escaped", prefix: "c++ (", want: `[cC]\+\+ \(.*`, matches: []string{"C++ (dev)"}, misses: []string{"cc ("}},
+		{name: "non ascii letters", prefix: "É", want: "[éÉ].*", matches: []string{"école", "École"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := prefixRegex(test.prefix)
+			if got != test.want {
+				t.Fatalf("prefixRegex(%q) = %q, want %q", test.prefix, got, test.want)
+			}
+			// Lucene regular expressions are anchored, as is this one
+			pattern := regexp.MustCompile("^(?:" + got + ")$")
+			for _, value := range test.matches {
+				if !pattern.MatchString(value) {
+					t.Errorf("%q does not match %q", got, value)
+				}
+			}
+			for _, value := range test.misses {
+				if pattern.MatchString(value) {
+					t.Errorf("%q matches %q", got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestWithoutFilterKey(t *testing.T) {
+	where := WhereStruct{
+		TextMatch: TextMatchQuery{
+			Must:    []TextMatchStruct{{FilterKey: "title", TextValue: "CEO"}, {FilterKey: "city", TextValue: "Pune"}},
+			MustNot: []TextMatchStruct{{FilterKey: "title", TextValue: "CTO"}},
+		},
+		KeywordMatch: ElasticQuery{
+			Must:    map[string]any{"title": []string{"CEO"}, "country": "India"},
+			MustNot: map[string]any{"title": "CTO"},
+		},
+		RangeQuery: ElasticQuery{
+			Must: map[string]any{"employees": map[string]any{"gte": 10}},
+		},
+	}
+	tests := []struct {
+		name string
+		key  string
+		want WhereStruct
+	}{
+		{
+			name: "drops every condition on the key",
+			key:  "title",
+			want: WhereStruct{
+				TextMatch: TextMatchQuery{
+					Must:    []TextMatchStruct{{FilterKey: "city", TextValue: "Pune"}},
+					MustNot: []TextMatchStruct{},
+				},
+				KeywordMatch: ElasticQuery{Must: map[string]any{"country": "India"}, MustNot: map[string]any{}},
+				RangeQuery:   ElasticQuery{Must: map[string]any{"employees": map[string]any{"gte": 10}}},
+			},
+		},
+		{
+			name: "drops range conditions",
+			key:  "employees",
+			want: WhereStruct{
+				TextMatch:    where.TextMatch,
+				KeywordMatch: where.KeywordMatch,
+				RangeQuery:   ElasticQuery{Must: map[string]any{}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := where.WithoutFilterKey(test.key); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("WithoutFilterKey(%q) = %+v, want %+v", test.key, got, test.want)
+			}
+		})
+	}
+	if _, ok := where.KeywordMatch.Must["title"]; !ok {
+		t.Errorf("WithoutFilterKey changed the original where clause")
+	}
+}
@@ -1,9 +1,31 @@
 package utilities
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
 type ElasticCount struct {
 	Count int64 `json:"count"`
 }
 
+// TermsBucket is one value of a terms aggregation and the number of
+// documents that carry it.
+type TermsBucket struct {
+	Key      any   `json:"key"`
+	DocCount int64 `json:"doc_count"`
+}
+
+type ElasticTermsAggregation struct {
+	Aggregations struct {
+		Values struct {
+			Buckets []TermsBucket `json:"buckets"`
+		} `json:"values"`
+	} `json:"aggregations"`
+}
+
 type FilterOrder struct {
 	OrderBy        string `json:"order_by"`
 	OrderDirection string `json:"order_direction"`
@@ -51,13 +73,317 @@ type VQLQuery struct { // vivek Query Language
 	Limit int `json:"limit,omitempty"`
 }
 
+// MergePolicy decides, field by field, how an incoming record is combined
+// with the row already stored under the same uuid. Fields keys are json
+// column names; anything not listed falls back to Default.
+type MergePolicy struct {
+	Default string            `json:"default,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Provenance identifies where a record, or a single field of it, came from.
+// Exactly one of SourceJob (imports) or SourceAPIKey (direct API calls, as a
+// fingerprint rather than the key itself) is set. SourceRow is 1-based and
+// excludes the csv header.
+type Provenance struct {
+	SourceJob    string     `json:"source_job,omitempty"`
+	SourceAPIKey string     `json:"source_api_key,omitempty"`
+	SourceFile   string     `json:"source_file,omitempty"`
+	SourceRow    int64      `json:"source_row,omitempty"`
+	IngestedAt   *time.Time `json:"ingested_at,omitempty"`
+}
+
+// AtRow returns the provenance of the row offset positions after the first
+// row of the batch p describes.
+func (p Provenance) AtRow(offset int, ingestedAt *time.Time) Provenance {
+	p.SourceRow += int64(offset)
+	p.IngestedAt = ingestedAt
+	return p
+}
+
+// Actor names who wrote a record for the change history: the API key
+// fingerprint for direct calls, otherwise the import job.
+func (p Provenance) Actor() string {
+	switch {
+	case p.SourceAPIKey != "":
+		return "api_key:" + p.SourceAPIKey
+	case p.SourceJob != "":
+		return "job:" + p.SourceJob
+	}
+	return "system"
+}
+
+type UpsertOptions struct {
+	MergePolicy MergePolicy `json:"merge_policy,omitempty"`
+	SourceDate  *time.Time  `json:"source_date,omitempty"`
+
+	// Provenance is filled in by the server and never read from requests.
+	Provenance Provenance `json:"-"`
+}
+
 type InsertFileJobData struct {
 	FileS3Key    string `json:"s3_key"`
 	FileS3Bucket string `json:"s3_bucket"`
+	// WriteNewUuids uploads a csv of the uuids the import created
+	WriteNewUuids bool `json:"write_new_uuids,omitempty"`
+	UpsertOptions
+}
+
+// RecordPatch is a partial update of a single record. UpdatedAt, when set,
+// must match the stored row for the update to apply.
+type RecordPatch struct {
+	Fields     map[string]json.RawMessage
+	UpdatedAt  *time.Time
+	Provenance Provenance
+}
+
+type UpsertCounts struct {
+	Inserted  int64 `json:"inserted"`
+	Updated   int64 `json:"updated"`
+	Unchanged int64 `json:"unchanged"`
+}
+
+// UpsertStats describes what a bulk upsert actually did to the stored rows.
+// FieldChanges counts, per field, how many existing records it changed, and
+// UpdatedFields lists the changed fields per updated record uuid.
+type UpsertStats struct {
+	UpsertCounts
+	FieldChanges  map[string]int64    `json:"field_changes,omitempty"`
+	InsertedUuids []string            `json:"-"`
+	UpdatedFields map[string][]string `json:"-"`
+}
+
+// Add accumulates the counts of other. InsertedUuids and UpdatedFields are
+// not carried over; callers consume them batch by batch.
+func (s *UpsertStats) Add(other UpsertStats) {
+	s.Inserted += other.Inserted
+	s.Updated += other.Updated
+	s.Unchanged += other.Unchanged
+	for field, count := range other.FieldChanges {
+		if s.FieldChanges == nil {
+			s.FieldChanges = make(map[string]int64)
+		}
+		s.FieldChanges[field] += count
+	}
+}
+
+// TopFieldChanges lists the n most often changed fields as "field (count)".
+func (s *UpsertStats) TopFieldChanges(n int) []string {
+	fields := make([]string, 0, len(s.FieldChanges))
+	for field := range s.FieldChanges {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if s.FieldChanges[fields[i]] != s.FieldChanges[fields[j]] {
+			return s.FieldChanges[fields[i]] > s.FieldChanges[fields[j]]
+		}
+		return fields[i] < fields[j]
+	})
+	top := make([]string, 0, n)
+	for _, field := range fields[:min(n, len(fields))] {
+		top = append(top, fmt.Sprintf("%s (%d)", field, s.FieldChanges[field]))
+	}
+	return top
+}
+
+type ImportStats struct {
+	Contacts  UpsertStats `json:"contacts"`
+	Companies UpsertStats `json:"companies"`
+}
+
+type ImportBatchStats struct {
+	FirstRow  int64        `json:"first_row"`
+	Rows      int          `json:"rows"`
+	Contacts  UpsertCounts `json:"contacts"`
+	Companies UpsertCounts `json:"companies"`
+}
+
+// ImportReport is the outcome of an insert_csv_file job: totals with field
+// change frequencies, plus the counts of every batch.
+type ImportReport struct {
+	ImportStats
+	Batches []ImportBatchStats `json:"batches"`
+}
+
+func (r *ImportReport) AddBatch(firstRow int64, rows int, stats ImportStats) {
+	r.Contacts.Add(stats.Contacts)
+	r.Companies.Add(stats.Companies)
+	r.Batches = append(r.Batches, ImportBatchStats{
+		FirstRow:  firstRow,
+		Rows:      rows,
+		Contacts:  stats.Contacts.UpsertCounts,
+		Companies: stats.Companies.UpsertCounts,
+	})
+}
+
+// Rows is the number of csv rows the batches covered.
+func (r *ImportReport) Rows() int64 {
+	var rows int64
+	for _, batch := range r.Batches {
+		rows += int64(batch.Rows)
+	}
+	return rows
 }
 
 type ExportFileJobData struct {
 	FileS3Bucket string   `json:"s3_bucket"`
 	Service      string   `json:"service"`
 	VQL          VQLQuery `json:"vql"`
+	// Format is csv (the default), jsonl, xlsx or parquet.
+	Format string `json:"format,omitempty"`
+	// ColumnLabels renames the header of select columns, keyed by column.
+	ColumnLabels map[string]string `json:"column_labels,omitempty"`
+
+	// Setting either cap splits the export into numbered parts. The byte cap
+	// is checked after every batch, so parts can run over it by one batch.
+	MaxRowsPerPart  int64 `json:"max_rows_per_part,omitempty"`
+	MaxBytesPerPart int64 `json:"max_bytes_per_part,omitempty"`
+	// Compression is empty or gzip.
+	Compression string `json:"compression,omitempty"`
+	// Bundle also uploads all the parts as a single zip.
+	Bundle bool `json:"bundle,omitempty"`
+
+	// Parallel splits the export into uuid ranges that workers read at the
+	// same time. Ordered writes the rows in the order of a serial export;
+	// otherwise every worker writes parts of its own.
+	Parallel bool `json:"parallel,omitempty"`
+	Ordered  bool `json:"ordered,omitempty"`
+
+	// Template names a stored export template of the service, which then
+	// defines the columns instead of vql.select_columns.
+	Template string `json:"template,omitempty"`
+}
+
+// ExportTransform is one step of an export template column. join and
+// coalesce make the column's sources one value; lowercase and format_phone
+// change every value.
+type ExportTransform struct {
+	Type string `json:"type"`
+	// Separator of join, a space by default.
+	Separator *string `json:"separator,omitempty"`
+}
+
+// ExportTemplateColumn is one output column of an export template. It reads a
+// single source column, or several that its transforms make one value, and
+// falls back to Default when that value is empty.
+type ExportTemplateColumn struct {
+	Header     string            `json:"header,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Sources    []string          `json:"sources,omitempty"`
+	Transforms []ExportTransform `json:"transforms,omitempty"`
+	Default    string            `json:"default,omitempty"`
+}
+
+// ExportPart is one uploaded file of an export. Number is the one in its
+// name, counted from 1. Checksum is the hex sha256 of the file as uploaded.
+type ExportPart struct {
+	Number   int    `json:"number"`
+	S3Key    string `json:"s3_key"`
+	Rows     int64  `json:"rows"`
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"sha256"`
+}
+
+// ExportReport is the outcome of an export_csv_file job. An export that is
+// not split has a single part.
+type ExportReport struct {
+	Template    string       `json:"template,omitempty"`
+	Parts       []ExportPart `json:"parts"`
+	BundleKey   string       `json:"bundle_key,omitempty"`
+	ManifestKey string       `json:"manifest_key"`
+	Format      string       `json:"format"`
+	Compression string       `json:"compression,omitempty"`
+	Rows        int64        `json:"rows"`
+	Bytes       int64        `json:"bytes"`
+	Columns     []string     `json:"columns"`
+	StartedAt   time.Time    `json:"started_at"`
+	FinishedAt  time.Time    `json:"finished_at"`
+	DurationMs  int64        `json:"duration_ms"`
+}
+
+// Keys lists every object of the export: the parts, the bundle and the
+// manifest.
+func (r ExportReport) Keys() []string {
+	keys := make([]string, 0, len(r.Parts)+2)
+	for _, part := range r.Parts {
+		keys = append(keys, part.S3Key)
+	}
+	if r.BundleKey != "" {
+		keys = append(keys, r.BundleKey)
+	}
+	if r.ManifestKey != "" {
+		keys = append(keys, r.ManifestKey)
+	}
+	return keys
+}
+
+// ExportManifest is written next to every completed export so that a reader
+// of the bucket can check the file and tell how it was produced.
+type ExportManifest struct {
+	JobUUID string   `json:"job_uuid"`
+	Service string   `json:"service"`
+	VQL     VQLQuery `json:"vql"`
+	ExportReport
+}
+
+type ReconcileJobData struct {
+	FileS3Bucket string `json:"s3_bucket"`
+	Service      string `json:"service"`
+	Repair       bool   `json:"repair"`
+	ChunkSize    int    `json:"chunk_size,omitempty"`
+}
+
+type RollbackImportJobData struct {
+	FileS3Bucket string `json:"s3_bucket"`
+	SourceJob    string `json:"job_uuid"`
+}
+
+// VQLMutationJobData drives the update_by_vql and delete_by_vql jobs. Patch
+// is only used by update_by_vql. The matches are always counted first, and
+// the job refuses to run when there are more than MaxRecords.
+type VQLMutationJobData struct {
+	Service    string                     `json:"service"`
+	VQL        VQLQuery                   `json:"vql"`
+	Patch      map[string]json.RawMessage `json:"patch,omitempty"`
+	MaxRecords int64                      `json:"max_records,omitempty"`
+	DryRun     bool                       `json:"dry_run,omitempty"`
+}
+
+type RebuildFilterDataJobData struct {
+	Service   string `json:"service"`
+	FilterKey string `json:"filter_key"`
+}
+
+type PurgeDeletedJobData struct {
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// PurgeExportsJobData removes the files of one export when JobUUID is set,
+// otherwise of every export completed more than RetentionDays ago.
+type PurgeExportsJobData struct {
+	JobUUID       string `json:"job_uuid,omitempty"`
+	RetentionDays int    `json:"retention_days,omitempty"`
+}
+
+type ElasticBulkItem struct {
+	Id     string         `json:"_id"`
+	Status int            `json:"status"`
+	Error  map[string]any `json:"error,omitempty"`
+}
+
+type ElasticBulkResponse struct {
+	Errors bool                         `json:"errors"`
+	Items  []map[string]ElasticBulkItem `json:"items"`
+}
+
+func (r *ElasticBulkResponse) FirstError() string {
+	for _, item := range r.Items {
+		for _, result := range item {
+			if result.Error != nil {
+				reason, _ := json.Marshal(result.Error)
+				return fmt.Sprintf("document %s: %s", result.Id, reason)
+			}
+		}
+	}
+	return ""
 }